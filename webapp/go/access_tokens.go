@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// accessTokenHashPrefix はハッシュ化済みのアクセストークンの先頭につける文字列
+// 平文のまま残っているトークンと区別して、移行を何度実行しても二重にハッシュ化しないようにする
+const accessTokenHashPrefix = "sha256:"
+
+// accessTokenLookupPrefixLength はDBで候補の行を引くのに使う、ハッシュの先頭の長さ
+const accessTokenLookupPrefixLength = 16
+
+// hashAccessToken はDBとキャッシュに保存するアクセストークンのハッシュを返す
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return accessTokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// accessTokenLookupPrefix はハッシュ化済みのトークンから、token_prefix に保存する先頭部分を返す
+// ハッシュの一部なので元のトークンは分からず、owner_api_keys.key_prefix と同じく秘密ではない値として扱える
+func accessTokenLookupPrefix(hashedToken string) string {
+	digest := strings.TrimPrefix(hashedToken, accessTokenHashPrefix)
+	return digest[:min(len(digest), accessTokenLookupPrefixLength)]
+}
+
+// findByAccessTokenHash は token_prefix で引いた候補から、保存されたハッシュが hashedToken と一致するものを返す
+// ハッシュはDBの検索に使わず、ここで一定時間で比較する
+func findByAccessTokenHash[T any](candidates []T, hashedToken string, storedHash func(*T) string) (*T, bool) {
+	for i := range candidates {
+		if subtle.ConstantTimeCompare([]byte(storedHash(&candidates[i])), []byte(hashedToken)) == 1 {
+			return &candidates[i], true
+		}
+	}
+	return nil, false
+}
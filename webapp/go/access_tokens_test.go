@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestHashAccessToken(t *testing.T) {
+	// dbInitialize の移行で使う CONCAT('sha256:', SHA2('token', 256)) と同じ値になること
+	const want = "sha256:3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0"
+	if got := hashAccessToken("token"); got != want {
+		t.Errorf("hashAccessToken() = %q, want %q", got, want)
+	}
+	// 移行で使う SUBSTRING(token, 8, 16) と同じ値になること
+	if got := accessTokenLookupPrefix(want); got != "3c469e9d6c5875d3" {
+		t.Errorf("accessTokenLookupPrefix() = %q, want %q", got, "3c469e9d6c5875d3")
+	}
+}
+
+func TestFindByAccessTokenHash(t *testing.T) {
+	hashed := hashAccessToken("token")
+	// 先頭が同じでも、ハッシュ全体が一致するものだけを返す
+	candidates := []Session{
+		{ID: "other", Token: accessTokenHashPrefix + accessTokenLookupPrefix(hashed) + "0000"},
+		{ID: "match", Token: hashed},
+	}
+	got, ok := findByAccessTokenHash(candidates, hashed, func(s *Session) string { return s.Token })
+	if !ok || got.ID != "match" {
+		t.Errorf("findByAccessTokenHash() = %+v, %v, want match", got, ok)
+	}
+	if _, ok := findByAccessTokenHash(candidates[:1], hashed, func(s *Session) string { return s.Token }); ok {
+		t.Error("findByAccessTokenHash() should not match a different hash with the same prefix")
+	}
+}
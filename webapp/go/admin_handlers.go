@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type adminChairModel struct {
+	Name      string `json:"name"`
+	Speed     int    `json:"speed"`
+	RetiredAt *int64 `json:"retired_at,omitempty"`
+}
+
+func toAdminChairModel(model ChairModel) adminChairModel {
+	res := adminChairModel{
+		Name:  model.Name,
+		Speed: model.Speed,
+	}
+	if model.RetiredAt != nil {
+		t := model.RetiredAt.UnixMilli()
+		res.RetiredAt = &t
+	}
+	return res
+}
+
+type adminGetChairModelsResponse struct {
+	Models []adminChairModel `json:"models"`
+}
+
+func adminGetChairModels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	models := []ChairModel{}
+	if err := db.SelectContext(ctx, &models, "SELECT * FROM chair_models ORDER BY name"); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetChairModelsResponse{Models: []adminChairModel{}}
+	for _, model := range models {
+		res.Models = append(res.Models, toAdminChairModel(model))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminPostChairModelsRequest struct {
+	Name  string `json:"name" validate:"required,max=50"`
+	Speed int    `json:"speed" validate:"min=1"`
+}
+
+func adminPostChairModels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := &adminPostChairModelsRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO chair_models (name, speed) VALUES (?, ?)", req.Name, req.Speed); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			writeError(w, http.StatusConflict, errors.New("chair model already exists"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toAdminChairModel(ChairModel{Name: req.Name, Speed: req.Speed}))
+}
+
+type adminPatchChairModelRequest struct {
+	Speed   *int  `json:"speed" validate:"min=1"`
+	Retired *bool `json:"retired"`
+}
+
+func adminPatchChairModel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := r.PathValue("name")
+
+	req := &adminPatchChairModelRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	model := &ChairModel{}
+	if err := tx.GetContext(ctx, model, "SELECT * FROM chair_models WHERE name = ? FOR UPDATE", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("chair model not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if req.Speed != nil {
+		model.Speed = *req.Speed
+	}
+	if req.Retired != nil {
+		if *req.Retired && model.RetiredAt == nil {
+			now := time.Now()
+			model.RetiredAt = &now
+		} else if !*req.Retired {
+			model.RetiredAt = nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE chair_models SET speed = ?, retired_at = ? WHERE name = ?", model.Speed, model.RetiredAt, model.Name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAdminChairModel(*model))
+}
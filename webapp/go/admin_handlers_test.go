@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestAdminChairModels(t *testing.T) {
+	retiredAt := time.Now().Add(-time.Hour)
+
+	t.Run("list", func(t *testing.T) {
+		runHandlerTests(t, adminGetChairModels, []handlerTest{
+			{
+				name: "including retired",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM chair_models ORDER BY name`, ChairModel{Name: "a", Speed: 2}, ChairModel{Name: "old", Speed: 1, RetiredAt: &retiredAt})
+				},
+				req:        newRequest(http.MethodGet, "/api/admin/chair-models", ""),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					res := decodeJSON[adminGetChairModelsResponse](t, body)
+					if len(res.Models) != 2 || res.Models[0].RetiredAt != nil || res.Models[1].RetiredAt == nil || *res.Models[1].RetiredAt != retiredAt.UnixMilli() {
+						t.Errorf("models = %+v", res.Models)
+					}
+				},
+			},
+		})
+	})
+
+	t.Run("create", func(t *testing.T) {
+		runHandlerTests(t, adminPostChairModels, []handlerTest{
+			{
+				name: "created",
+				setup: func(f *fakeDB) {
+					f.exec(`^INSERT INTO chair_models`, 1).with("new", 4)
+				},
+				req:        newRequest(http.MethodPost, "/api/admin/chair-models", `{"name":"new","speed":4}`),
+				wantStatus: http.StatusCreated,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					if res := decodeJSON[adminChairModel](t, body); res.Name != "new" || res.Speed != 4 || res.RetiredAt != nil {
+						t.Errorf("response = %+v", res)
+					}
+				},
+			},
+			{
+				name:       "invalid speed",
+				req:        newRequest(http.MethodPost, "/api/admin/chair-models", `{"name":"new","speed":0}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"speed"},
+			},
+			{
+				name: "duplicated",
+				setup: func(f *fakeDB) {
+					f.fail(`^INSERT INTO chair_models`, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+				},
+				req:        newRequest(http.MethodPost, "/api/admin/chair-models", `{"name":"a","speed":4}`),
+				wantStatus: http.StatusConflict,
+				wantCode:   errCodeConflict,
+			},
+		})
+	})
+
+	t.Run("update", func(t *testing.T) {
+		newPatch := func(name, body string) *http.Request {
+			return newRequest(http.MethodPatch, "/api/admin/chair-models/"+name, body, "name", name)
+		}
+		runHandlerTests(t, adminPatchChairModel, []handlerTest{
+			{
+				name: "retire",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM chair_models WHERE name = \? FOR UPDATE`, ChairModel{Name: "a", Speed: 2}).with("a")
+					f.exec(`^UPDATE chair_models`, 1)
+				},
+				req:        newPatch("a", `{"speed":3,"retired":true}`),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					if res := decodeJSON[adminChairModel](t, body); res.Speed != 3 || res.RetiredAt == nil {
+						t.Errorf("response = %+v, want speed 3 and retired", res)
+					}
+				},
+			},
+			{
+				name: "keep retired_at when already retired",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM chair_models WHERE name = \? FOR UPDATE`, ChairModel{Name: "old", Speed: 1, RetiredAt: &retiredAt})
+					f.exec(`^UPDATE chair_models`, 1)
+				},
+				req:        newPatch("old", `{"retired":true}`),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					if res := decodeJSON[adminChairModel](t, body); res.RetiredAt == nil || *res.RetiredAt != retiredAt.UnixMilli() {
+						t.Errorf("response = %+v, want the original retired_at", res)
+					}
+				},
+			},
+			{
+				name: "restore",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM chair_models WHERE name = \? FOR UPDATE`, ChairModel{Name: "old", Speed: 1, RetiredAt: &retiredAt})
+					f.exec(`^UPDATE chair_models`, 1)
+				},
+				req:        newPatch("old", `{"retired":false}`),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					if res := decodeJSON[adminChairModel](t, body); res.RetiredAt != nil {
+						t.Errorf("response = %+v, want not retired", res)
+					}
+				},
+			},
+			{
+				name:       "invalid speed",
+				req:        newPatch("a", `{"speed":0}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"speed"},
+			},
+			{
+				name: "not found",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM chair_models WHERE name = \? FOR UPDATE`)
+				},
+				req:        newPatch("missing", `{"speed":3}`),
+				wantStatus: http.StatusNotFound,
+				wantCode:   errCodeNotFound,
+			},
+		})
+	})
+}
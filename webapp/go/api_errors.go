@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/validation"
+)
+
+// エラーレスポンスの code。クライアントが分岐に使うので値は変えないこと
+const (
+	errCodeBadRequest       = "bad_request"
+	errCodeInvalidJSON      = "invalid_json"
+	errCodeUnknownField     = "unknown_field"
+	errCodeInvalidType      = "invalid_type"
+	errCodeValidationFailed = "validation_failed"
+	errCodeRequestTooLarge  = "request_too_large"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeNotFound         = "not_found"
+	errCodeConflict         = "conflict"
+	errCodeTooManyRequests  = "too_many_requests"
+	errCodeInternal         = "internal_error"
+	errCodeUpstream         = "upstream_error"
+	errCodeUnavailable      = "service_unavailable"
+)
+
+var errorCodesByStatus = map[int]string{
+	http.StatusBadRequest:            errCodeBadRequest,
+	http.StatusUnauthorized:          errCodeUnauthorized,
+	http.StatusForbidden:             errCodeForbidden,
+	http.StatusNotFound:              errCodeNotFound,
+	http.StatusConflict:              errCodeConflict,
+	http.StatusRequestEntityTooLarge: errCodeRequestTooLarge,
+	http.StatusTooManyRequests:       errCodeTooManyRequests,
+	http.StatusInternalServerError:   errCodeInternal,
+	http.StatusBadGateway:            errCodeUpstream,
+	http.StatusServiceUnavailable:    errCodeUnavailable,
+}
+
+// errorResponse はすべてのエラーレスポンスの形式
+type errorResponse struct {
+	Message string                  `json:"message"`
+	Code    string                  `json:"code"`
+	Details []validation.FieldError `json:"details,omitempty"`
+}
+
+// apiError は code やフィールドごとの詳細をエラーレスポンスに含めたい場合に使う
+type apiError struct {
+	// Status が0でなければ writeError に渡したステータスコードの代わりに使う
+	Status  int
+	Code    string
+	Message string
+	Details []validation.FieldError
+	err     error
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// toErrorResponse は err からレスポンスとステータスコードを作る
+func toErrorResponse(statusCode int, err error) (int, *errorResponse) {
+	res := &errorResponse{Message: err.Error()}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		if apiErr.Status != 0 {
+			statusCode = apiErr.Status
+		}
+		res.Message = apiErr.Message
+		res.Code = apiErr.Code
+		res.Details = apiErr.Details
+	}
+	if res.Code == "" {
+		res.Code = errorCodesByStatus[statusCode]
+	}
+	if res.Code == "" {
+		res.Code = errCodeInternal
+		if statusCode < 500 {
+			res.Code = errCodeBadRequest
+		}
+	}
+	return statusCode, res
+}
+
+// maxRequestBodyBytes を超えるリクエストボディは読まずに 413 を返す
+const maxRequestBodyBytes = 1 << 20
+
+// toBindError は JSON のデコードエラーを apiError に変換する
+func toBindError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &apiError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    errCodeRequestTooLarge,
+			Message: fmt.Sprintf("request body must be at most %d bytes", maxBytesErr.Limit),
+			err:     err,
+		}
+	case errors.As(err, &typeErr):
+		return &apiError{
+			Code:    errCodeInvalidType,
+			Message: fmt.Sprintf("%s must be %s", typeErr.Field, typeErr.Type),
+			Details: []validation.FieldError{{Field: typeErr.Field, Code: errCodeInvalidType, Message: fmt.Sprintf("%s must be %s", typeErr.Field, typeErr.Type)}},
+			err:     err,
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json は未知のフィールドのエラーを型で返さないのでメッセージから取り出す
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &apiError{
+			Code:    errCodeUnknownField,
+			Message: fmt.Sprintf("unknown field %q", field),
+			Details: []validation.FieldError{{Field: field, Code: errCodeUnknownField, Message: fmt.Sprintf("unknown field %q", field)}},
+			err:     err,
+		}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &apiError{Code: errCodeInvalidJSON, Message: "request body must be a valid JSON", err: err}
+	}
+	return &apiError{Code: errCodeInvalidJSON, Message: err.Error(), err: err}
+}
+
+// newFieldError はハンドラで検証したフィールドのエラーを validation_failed として返す
+func newFieldError(field, code, message string) error {
+	return &apiError{
+		Code:    errCodeValidationFailed,
+		Message: message,
+		Details: []validation.FieldError{{Field: field, Code: code, Message: message}},
+	}
+}
+
+// parseQueryUnixMilli はミリ秒のUNIX時刻を受け取るクエリパラメータを読む。指定がなければ def を返す
+func parseQueryUnixMilli(r *http.Request, name string, def time.Time) (time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, newFieldError(name, "int", name+" must be an integer")
+	}
+	return time.UnixMilli(parsed), nil
+}
+
+// parseQueryLimit は件数を受け取るクエリパラメータを読み、maxLimit で切り詰める。指定がなければ def を返す
+func parseQueryLimit(r *http.Request, name string, def, maxLimit int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, newFieldError(name, "int", name+" must be an integer")
+	}
+	if parsed < 1 {
+		return 0, newFieldError(name, "min", name+" must be at least 1")
+	}
+	return min(parsed, maxLimit), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBindJSONErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantFields []string
+	}{
+		{
+			name:       "valid",
+			body:       `{"name":"chair","model":"m","chair_register_token":"t"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "invalid json",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeInvalidJSON,
+		},
+		{
+			name:       "trailing value",
+			body:       `{"name":"chair","model":"m","chair_register_token":"t"} {}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeInvalidJSON,
+		},
+		{
+			name:       "unknown field",
+			body:       `{"name":"chair","model":"m","chair_register_token":"t","speed":1}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeUnknownField,
+			wantFields: []string{"speed"},
+		},
+		{
+			name:       "invalid type",
+			body:       `{"name":1}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeInvalidType,
+			wantFields: []string{"name"},
+		},
+		{
+			name:       "validation failed",
+			body:       `{"name":"` + strings.Repeat("a", 31) + `","model":"m"}`,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"name", "chair_register_token"},
+		},
+		{
+			name:       "too large",
+			body:       `{"name":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantCode:   errCodeRequestTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/chair/chairs", strings.NewReader(tt.body))
+			if err := bindJSON(r, &chairPostChairsRequest{}); err != nil {
+				writeError(rec, http.StatusBadRequest, err)
+			} else {
+				rec.WriteHeader(http.StatusNoContent)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNoContent {
+				return
+			}
+			res := errorResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", res.Code, tt.wantCode)
+			}
+			if len(res.Details) != len(tt.wantFields) {
+				t.Fatalf("details = %+v, want fields %v", res.Details, tt.wantFields)
+			}
+			for i, field := range tt.wantFields {
+				if res.Details[i].Field != field {
+					t.Errorf("details[%d].field = %q, want %q", i, res.Details[i].Field, field)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteErrorCode(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusNotFound, errors.New("chair not found"))
+	res := errorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != errCodeNotFound || res.Message != "chair not found" {
+		t.Errorf("response = %+v", res)
+	}
+}
+
+// assertFieldError は err が field の rule に違反した validation_failed のエラーであることを確かめる
+func assertFieldError(t *testing.T, err error, field, rule string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("error = nil, want %s error for %s", rule, field)
+	}
+	_, res := toErrorResponse(http.StatusBadRequest, err)
+	if res.Code != errCodeValidationFailed {
+		t.Errorf("code = %q, want %q", res.Code, errCodeValidationFailed)
+	}
+	if len(res.Details) != 1 || res.Details[0].Field != field || res.Details[0].Code != rule {
+		t.Errorf("details = %+v, want %s error for %s", res.Details, rule, field)
+	}
+}
+
+func TestParseQueryLimit(t *testing.T) {
+	tests := []struct {
+		query    string
+		want     int
+		wantRule string
+	}{
+		{query: "", want: 10},
+		{query: "limit=5", want: 5},
+		{query: "limit=500", want: 100},
+		{query: "limit=0", wantRule: "min"},
+		{query: "limit=abc", wantRule: "int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseQueryLimit(httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil), "limit", 10, 100)
+			if tt.wantRule != "" {
+				assertFieldError(t, err, "limit", tt.wantRule)
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseQueryLimit() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerFieldErrors(t *testing.T) {
+	user := &User{ID: "user1"}
+	owner := &Owner{ID: "owner1"}
+	chair := &Chair{ID: "chair1", OwnerID: owner.ID}
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		req       *http.Request
+		wantField string
+		wantRule  string
+	}{
+		{name: "app rides status", handler: appGetRides, req: asUser(newRequest(http.MethodGet, "/api/app/rides?status=FLYING", ""), user), wantField: "status", wantRule: "oneof"},
+		{name: "app rides since", handler: appGetRides, req: asUser(newRequest(http.MethodGet, "/api/app/rides?since=yesterday", ""), user), wantField: "since", wantRule: "int"},
+		{name: "app rides limit", handler: appGetRides, req: asUser(newRequest(http.MethodGet, "/api/app/rides?limit=0", ""), user), wantField: "limit", wantRule: "min"},
+		{name: "recent places limit", handler: appGetRecentPlaces, req: asUser(newRequest(http.MethodGet, "/api/app/places/recent?limit=x", ""), user), wantField: "limit", wantRule: "int"},
+		{name: "nearby chairs latitude", handler: appGetNearbyChairs, req: asUser(newRequest(http.MethodGet, "/api/app/nearby-chairs?longitude=1", ""), user), wantField: "latitude", wantRule: "required"},
+		{name: "chair rides limit", handler: chairGetRides, req: asChair(newRequest(http.MethodGet, "/api/chair/rides?limit=-1", ""), chair), wantField: "limit", wantRule: "min"},
+		{name: "chair earnings until", handler: chairGetEarnings, req: asChair(newRequest(http.MethodGet, "/api/chair/earnings?until=1.5", ""), chair), wantField: "until", wantRule: "int"},
+		{name: "owner sales since", handler: ownerGetSales, req: asOwner(newRequest(http.MethodGet, "/api/owner/sales?since=x", ""), owner), wantField: "since", wantRule: "int"},
+		{name: "evaluation comment", handler: appPostRideEvaluatation, req: asUser(newRequest(http.MethodPost, "/api/app/rides/ride1/evaluation", `{"evaluation":5,"comment":"see https://example.com"}`, "ride_id", "ride1"), user), wantField: "comment", wantRule: "no_url"},
+		{name: "evaluation tags", handler: appPostRideEvaluatation, req: asUser(newRequest(http.MethodPost, "/api/app/rides/ride1/evaluation", `{"evaluation":5,"tags":["clean","fast"]}`, "ride_id", "ride1"), user), wantField: "tags[1]", wantRule: "oneof"},
+		{name: "chair trail tolerance", handler: ownerGetChairTrail, req: asOwner(newRequest(http.MethodGet, "/api/owner/chairs/chair1/trail?tolerance=-1", "", "chair_id", chair.ID), owner), wantField: "tolerance", wantRule: "min"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 検証に失敗した場合はDBを読まない
+			newFakeDB(t)
+			rec := httptest.NewRecorder()
+			tt.handler(rec, tt.req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+			assertErrorResponse(t, rec.Body.Bytes(), errCodeValidationFailed, tt.wantField)
+			if res := decodeJSON[errorResponse](t, rec.Body.Bytes()); res.Details[0].Code != tt.wantRule {
+				t.Errorf("details[0].code = %q, want %q", res.Details[0].Code, tt.wantRule)
+			}
+		})
+	}
+}
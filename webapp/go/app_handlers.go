@@ -4,8 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,10 +16,10 @@ import (
 )
 
 type appPostUsersRequest struct {
-	Username       string  `json:"username"`
-	FirstName      string  `json:"firstname"`
-	LastName       string  `json:"lastname"`
-	DateOfBirth    string  `json:"date_of_birth"`
+	Username       string  `json:"username" validate:"required,max=30"`
+	FirstName      string  `json:"firstname" validate:"required,max=30"`
+	LastName       string  `json:"lastname" validate:"required,max=30"`
+	DateOfBirth    string  `json:"date_of_birth" validate:"required,date"`
 	InvitationCode *string `json:"invitation_code"`
 }
 
@@ -35,13 +37,11 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.Username == "" || req.FirstName == "" || req.LastName == "" || req.DateOfBirth == "" {
-		writeError(w, http.StatusBadRequest, errors.New("required fields(username, firstname, lastname, date_of_birth) are empty"))
-		return
-	}
 
 	userID := ulid.Make().String()
 	accessToken := secureRandomStr(32)
+	// DBとキャッシュにはハッシュだけを保存し、平文はクッキーでだけ返す
+	hashedToken := hashAccessToken(accessToken)
 	invitationCode := secureRandomStr(15)
 
 	tx, err := db.Beginx()
@@ -58,7 +58,7 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 		Firstname:      req.FirstName,
 		Lastname:       req.LastName,
 		DateOfBirth:    req.DateOfBirth,
-		AccessToken:    accessToken,
+		AccessToken:    hashedToken,
 		InvitationCode: invitationCode,
 		CreatedAt:      now,
 		UpdatedAt:      now,
@@ -66,7 +66,7 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 	_, err = tx.ExecContext(
 		ctx,
 		"INSERT INTO users (id, username, firstname, lastname, date_of_birth, access_token, invitation_code,created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		userID, req.Username, req.FirstName, req.LastName, req.DateOfBirth, accessToken, invitationCode, now, now,
+		userID, req.Username, req.FirstName, req.LastName, req.DateOfBirth, hashedToken, invitationCode, now, now,
 	)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
@@ -132,17 +132,24 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	session, err := createSession(ctx, tx, sessionRoleApp, userID, hashedToken, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := createPrincipalCredential(ctx, tx, sessionRoleApp, userID, hashedToken, now); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	userTokenCache.Store(accessToken, newUser)
+	userTokenCache.Store(newUser.ID, newUser)
+	cacheSession(session)
 
-	http.SetCookie(w, &http.Cookie{
-		Path:  "/",
-		Name:  "app_session",
-		Value: accessToken,
-	})
+	setSessionCookie(w, sessionRoleApp, accessToken)
 
 	writeJSON(w, http.StatusCreated, &appPostUsersResponse{
 		ID:             userID,
@@ -151,22 +158,22 @@ func appPostUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 type appPostPaymentMethodsRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token" validate:"required"`
 }
 
 func appPostPaymentMethods(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
 	req := &appPostPaymentMethodsRequest{}
 	if err := bindJSON(r, req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.Token == "" {
-		writeError(w, http.StatusBadRequest, errors.New("token is required but was empty"))
-		return
-	}
-
-	user := ctx.Value("user").(*User)
 
 	_, err := db.ExecContext(
 		ctx,
@@ -183,7 +190,8 @@ func appPostPaymentMethods(w http.ResponseWriter, r *http.Request) {
 }
 
 type getAppRidesResponse struct {
-	Rides []getAppRidesResponseItem `json:"rides"`
+	Rides      []getAppRidesResponseItem `json:"rides"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
 }
 
 type getAppRidesResponseItem struct {
@@ -204,94 +212,161 @@ type getAppRidesResponseItemChair struct {
 	Model string `json:"model"`
 }
 
+type appGetRidesRow struct {
+	Ride
+	Status     string         `db:"status"`
+	ChairName  sql.NullString `db:"chair_name"`
+	ChairModel sql.NullString `db:"chair_model"`
+	OwnerName  sql.NullString `db:"owner_name"`
+	Discount   int            `db:"discount"`
+}
+
+var rideStatuses = map[string]bool{
+	"MATCHING":  true,
+	"ENROUTE":   true,
+	"PICKUP":    true,
+	"CARRYING":  true,
+	"ARRIVED":   true,
+	"COMPLETED": true,
+}
+
+const appGetRidesMaxLimit = 100
+
+// appGetRides はユーザーのライド履歴を新しい順に返す
+// cursor, limit を指定しない場合は従来通りすべて返す
+// status はカンマ区切りで複数指定でき、省略時は COMPLETED のみ返す
 func appGetRides(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user := ctx.Value("user").(*User)
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 
-	tx, err := db.Beginx()
+	query := r.URL.Query()
+	statuses := []string{"COMPLETED"}
+	if query.Get("status") != "" {
+		statuses = strings.Split(query.Get("status"), ",")
+		for _, status := range statuses {
+			if !rideStatuses[status] {
+				writeError(w, http.StatusBadRequest, newFieldError("status", "oneof", fmt.Sprintf("unknown status %q", status)))
+				return
+			}
+		}
+	}
+	since, err := parseQueryUnixMilli(r, "since", time.Unix(0, 0))
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	until, err := parseQueryUnixMilli(r, "until", time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cursor := query.Get("cursor")
+	// limit を指定せずに cursor だけを渡した場合は、最大件数ずつ返す
+	defaultLimit := 0
+	if cursor != "" {
+		defaultLimit = appGetRidesMaxLimit
+	}
+	limit, err := parseQueryLimit(r, "limit", defaultLimit, appGetRidesMaxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	defer tx.Rollback()
 
-	rides := []Ride{}
-	if err := tx.SelectContext(
-		ctx,
-		&rides,
-		`SELECT * FROM rides WHERE user_id = ? ORDER BY created_at DESC`,
-		user.ID,
-	); err != nil {
+	// ライドIDはULIDなので、IDの降順がそのまま要求日時の降順になる
+	sqlQuery := `
+		SELECT
+			rides.*,
+			rs.status AS status,
+			chairs.name AS chair_name,
+			chairs.model AS chair_model,
+			owners.name AS owner_name,
+			COALESCE(coupons.discount, 0) AS discount
+		FROM rides
+		INNER JOIN ride_statuses rs ON rs.id = (
+			SELECT id FROM ride_statuses WHERE ride_id = rides.id ORDER BY created_at DESC LIMIT 1
+		)
+		LEFT JOIN chairs ON chairs.id = rides.chair_id
+		LEFT JOIN owners ON owners.id = chairs.owner_id
+		LEFT JOIN coupons ON coupons.used_by = rides.id
+		WHERE rides.user_id = ?
+		AND rs.status IN (?)
+		AND rides.created_at BETWEEN ? AND ? + INTERVAL 999 MICROSECOND`
+	args := []interface{}{user.ID, statuses, since, until}
+	if cursor != "" {
+		sqlQuery += " AND rides.id < ?"
+		args = append(args, cursor)
+	}
+	sqlQuery += " ORDER BY rides.id DESC"
+	if limit > 0 {
+		// 次のページがあるかを判定するために1件多く取得する
+		sqlQuery += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+	sqlQuery, args, err = sqlx.In(sqlQuery, args...)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	items := []getAppRidesResponseItem{}
-	for _, ride := range rides {
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
-		if status != "COMPLETED" {
-			continue
-		}
+	rows := []appGetRidesRow{}
+	if err := db.SelectContext(ctx, &rows, sqlQuery, args...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 
-		fare, err := calculateDiscountedFare(ctx, tx, user.ID, &ride, ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
+	res := &getAppRidesResponse{Rides: []getAppRidesResponseItem{}}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+		res.NextCursor = rows[limit-1].ID
+	}
 
+	for _, row := range rows {
 		item := getAppRidesResponseItem{
-			ID:                    ride.ID,
-			PickupCoordinate:      Coordinate{Latitude: ride.PickupLatitude, Longitude: ride.PickupLongitude},
-			DestinationCoordinate: Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude},
-			Fare:                  fare,
-			Evaluation:            *ride.Evaluation,
-			RequestedAt:           ride.CreatedAt.UnixMilli(),
-			CompletedAt:           ride.UpdatedAt.UnixMilli(),
+			ID:                    row.ID,
+			PickupCoordinate:      Coordinate{Latitude: row.PickupLatitude, Longitude: row.PickupLongitude},
+			DestinationCoordinate: Coordinate{Latitude: row.DestinationLatitude, Longitude: row.DestinationLongitude},
+			Chair: getAppRidesResponseItemChair{
+				ID:    row.ChairID.String,
+				Owner: row.OwnerName.String,
+				Name:  row.ChairName.String,
+				Model: row.ChairModel.String,
+			},
+			Fare:        calculateFareByDistance(rideDistance(&row.Ride), row.Discount),
+			RequestedAt: row.CreatedAt.UnixMilli(),
 		}
-
-		item.Chair = getAppRidesResponseItemChair{}
-
-		chair := &Chair{}
-		if err := tx.GetContext(ctx, chair, `SELECT * FROM chairs WHERE id = ?`, ride.ChairID); err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
+		if row.Evaluation != nil {
+			item.Evaluation = *row.Evaluation
 		}
-		item.Chair.ID = chair.ID
-		item.Chair.Name = chair.Name
-		item.Chair.Model = chair.Model
-
-		owner := &Owner{}
-		if err := tx.GetContext(ctx, owner, `SELECT * FROM owners WHERE id = ?`, chair.OwnerID); err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
+		if row.Status == "COMPLETED" {
+			item.CompletedAt = row.UpdatedAt.UnixMilli()
 		}
-		item.Chair.Owner = owner.Name
-
-		items = append(items, item)
-	}
-
-	if err := tx.Commit(); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
+		res.Rides = append(res.Rides, item)
 	}
 
-	writeJSON(w, http.StatusOK, &getAppRidesResponse{
-		Rides: items,
-	})
+	writeJSON(w, http.StatusOK, res)
 }
 
 type appPostRidesRequest struct {
-	PickupCoordinate      *Coordinate `json:"pickup_coordinate"`
+	PickupCoordinate      *Coordinate `json:"pickup_coordinate" validate:"required"`
 	DestinationCoordinate *Coordinate `json:"destination_coordinate"`
+	// PlaceID を指定すると登録地点を目的地にする。destination_coordinate とは同時に指定できない
+	PlaceID string `json:"place_id"`
+	// Waypoints は配車位置と目的地の間に順に立ち寄る経由地
+	Waypoints []Coordinate `json:"waypoints"`
+	// ScheduledAt を指定すると予約になる(UNIXミリ秒)
+	ScheduledAt *int64 `json:"scheduled_at"`
 }
 
 type appPostRidesResponse struct {
-	RideID string `json:"ride_id"`
-	Fare   int    `json:"fare"`
+	// RideID は予約の場合は省略する。ライドは予約時刻が近づいてから作成する
+	RideID        string `json:"ride_id,omitempty"`
+	ReservationID string `json:"reservation_id,omitempty"`
+	Fare          int    `json:"fare"`
+	ScheduledAt   int64  `json:"scheduled_at,omitempty"`
 }
 
 type executableGet interface {
@@ -312,19 +387,96 @@ func getLatestRideStatus(ctx context.Context, tx executableGet, rideID string) (
 	return status, nil
 }
 
+// hasContinuingRide はユーザーに完了していないライドがあるかを返す
+func hasContinuingRide(ctx context.Context, tx *sqlx.Tx, userID string) (bool, error) {
+	rides := []Ride{}
+	if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE user_id = ?`, userID); err != nil {
+		return false, err
+	}
+
+	for _, ride := range rides {
+		status, err := getLatestRideStatus(ctx, tx, ride.ID)
+		if err != nil {
+			return false, err
+		}
+		if status != "COMPLETED" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// assignCoupon はライドにクーポンを紐づける
+// 初回利用なら初回利用クーポンを優先し、それ以外は付与された順番に使う
+func assignCoupon(ctx context.Context, tx *sqlx.Tx, userID, rideID string, firstRide bool) error {
+	var coupon Coupon
+	if firstRide {
+		// 初回利用で、初回利用クーポンがあれば必ず使う
+		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND code = 'CP_NEW2024' AND used_by IS NULL FOR UPDATE", userID); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		} else {
+			_, err := tx.ExecContext(
+				ctx,
+				"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = 'CP_NEW2024'",
+				rideID, userID,
+			)
+			return err
+		}
+	}
+
+	// 他のクーポンを付与された順番に使う
+	if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1 FOR UPDATE", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	_, err := tx.ExecContext(
+		ctx,
+		"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = ?",
+		rideID, userID, coupon.Code,
+	)
+	return err
+}
+
 func appPostRides(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
 	req := &appPostRidesRequest{}
 	if err := bindJSON(r, req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.PickupCoordinate == nil || req.DestinationCoordinate == nil {
-		writeError(w, http.StatusBadRequest, errors.New("required fields(pickup_coordinate, destination_coordinate) are empty"))
+	destination, err := resolveDestinationPlace(ctx, user.ID, req.PlaceID, req.DestinationCoordinate)
+	if err != nil {
+		if errors.Is(err, errInvalidPlace) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	req.DestinationCoordinate = destination
+	if req.DestinationCoordinate == nil {
+		writeError(w, http.StatusBadRequest, newFieldError("destination_coordinate", "required", "destination_coordinate or place_id is required"))
+		return
+	}
+	if err := validateWaypoints(req.Waypoints); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.ScheduledAt != nil && !time.UnixMilli(*req.ScheduledAt).After(time.Now()) {
+		writeError(w, http.StatusBadRequest, newFieldError("scheduled_at", "future", "scheduled_at must be in the future"))
 		return
 	}
 
-	user := ctx.Value("user").(*User)
 	rideID := ulid.Make().String()
 
 	tx, err := db.Beginx()
@@ -334,34 +486,47 @@ func appPostRides(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	rides := []Ride{}
-	if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE user_id = ?`, user.ID); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	continuingRideCount := 0
-	for _, ride := range rides {
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
+	if req.ScheduledAt != nil {
+		// 予約は進行中のライドがあっても受け付ける。ライドの作成は予約時刻が近づいてから行う
+		reservationID := ulid.Make().String()
+		fare, err := createRideReservation(ctx, tx, user.ID, reservationID, *req.PickupCoordinate, req.Waypoints, *req.DestinationCoordinate, time.UnixMilli(*req.ScheduledAt))
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
-		if status != "COMPLETED" {
-			continuingRideCount++
+		if err := tx.Commit(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
 		}
+		writeJSON(w, http.StatusAccepted, &appPostRidesResponse{
+			ReservationID: reservationID,
+			Fare:          fare,
+			ScheduledAt:   *req.ScheduledAt,
+		})
+		return
 	}
 
-	if continuingRideCount > 0 {
+	continuing, err := hasContinuingRide(ctx, tx, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if continuing {
 		writeError(w, http.StatusConflict, errors.New("ride already exists"))
 		return
 	}
 
+	routeDistance, err := insertRideWaypoints(ctx, tx, rideID, *req.PickupCoordinate, req.Waypoints, *req.DestinationCoordinate)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	if _, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO rides (id, user_id, pickup_latitude, pickup_longitude, destination_latitude, destination_longitude)
-				  VALUES (?, ?, ?, ?, ?, ?)`,
-		rideID, user.ID, req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude,
+		`INSERT INTO rides (id, user_id, pickup_latitude, pickup_longitude, destination_latitude, destination_longitude, route_distance)
+				  VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rideID, user.ID, req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude, routeDistance,
 	); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -376,64 +541,21 @@ func appPostRides(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 予約済みのライドは予約時にクーポンを紐づけているので、初回利用かどうかの判定に含める
 	var rideCount int
-	if err := tx.GetContext(ctx, &rideCount, `SELECT COUNT(*) FROM rides WHERE user_id = ? `, user.ID); err != nil {
+	if err := tx.GetContext(
+		ctx,
+		&rideCount,
+		`SELECT (SELECT COUNT(*) FROM rides WHERE user_id = ?) + (SELECT COUNT(*) FROM ride_reservations WHERE user_id = ? AND status = 'SCHEDULED')`,
+		user.ID, user.ID,
+	); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	var coupon Coupon
-	if rideCount == 1 {
-		// 初回利用で、初回利用クーポンがあれば必ず使う
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND code = 'CP_NEW2024' AND used_by IS NULL FOR UPDATE", user.ID); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-
-			// 無ければ他のクーポンを付与された順番に使う
-			if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1 FOR UPDATE", user.ID); err != nil {
-				if !errors.Is(err, sql.ErrNoRows) {
-					writeError(w, http.StatusInternalServerError, err)
-					return
-				}
-			} else {
-				if _, err := tx.ExecContext(
-					ctx,
-					"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = ?",
-					rideID, user.ID, coupon.Code,
-				); err != nil {
-					writeError(w, http.StatusInternalServerError, err)
-					return
-				}
-			}
-		} else {
-			if _, err := tx.ExecContext(
-				ctx,
-				"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = 'CP_NEW2024'",
-				rideID, user.ID,
-			); err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		}
-	} else {
-		// 他のクーポンを付与された順番に使う
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1 FOR UPDATE", user.ID); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		} else {
-			if _, err := tx.ExecContext(
-				ctx,
-				"UPDATE coupons SET used_by = ? WHERE user_id = ? AND code = ?",
-				rideID, user.ID, coupon.Code,
-			); err != nil {
-				writeError(w, http.StatusInternalServerError, err)
-				return
-			}
-		}
+	if err := assignCoupon(ctx, tx, user.ID, rideID, rideCount == 1); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
 	}
 
 	ride := Ride{}
@@ -462,28 +584,56 @@ func appPostRides(w http.ResponseWriter, r *http.Request) {
 }
 
 type appPostRidesEstimatedFareRequest struct {
-	PickupCoordinate      *Coordinate `json:"pickup_coordinate"`
-	DestinationCoordinate *Coordinate `json:"destination_coordinate"`
+	PickupCoordinate      *Coordinate  `json:"pickup_coordinate" validate:"required"`
+	DestinationCoordinate *Coordinate  `json:"destination_coordinate"`
+	PlaceID               string       `json:"place_id"`
+	Waypoints             []Coordinate `json:"waypoints"`
+	ScheduledAt           *int64       `json:"scheduled_at"`
 }
 
 type appPostRidesEstimatedFareResponse struct {
-	Fare     int `json:"fare"`
-	Discount int `json:"discount"`
+	Fare        int      `json:"fare"`
+	Discount    int      `json:"discount"`
+	ETA         *rideETA `json:"eta,omitempty"`
+	ScheduledAt int64    `json:"scheduled_at,omitempty"`
 }
 
 func appPostRidesEstimatedFare(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
 	req := &appPostRidesEstimatedFareRequest{}
 	if err := bindJSON(r, req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.PickupCoordinate == nil || req.DestinationCoordinate == nil {
-		writeError(w, http.StatusBadRequest, errors.New("required fields(pickup_coordinate, destination_coordinate) are empty"))
+	destination, err := resolveDestinationPlace(ctx, user.ID, req.PlaceID, req.DestinationCoordinate)
+	if err != nil {
+		if errors.Is(err, errInvalidPlace) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	req.DestinationCoordinate = destination
+	if req.DestinationCoordinate == nil {
+		writeError(w, http.StatusBadRequest, newFieldError("destination_coordinate", "required", "destination_coordinate or place_id is required"))
 		return
 	}
 
-	user := ctx.Value("user").(*User)
+	if err := validateWaypoints(req.Waypoints); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.ScheduledAt != nil && !time.UnixMilli(*req.ScheduledAt).After(time.Now()) {
+		writeError(w, http.StatusBadRequest, newFieldError("scheduled_at", "future", "scheduled_at must be in the future"))
+		return
+	}
 
 	tx, err := db.Beginx()
 	if err != nil {
@@ -492,21 +642,52 @@ func appPostRidesEstimatedFare(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	discounted, err := calculateDiscountedFare(ctx, tx, user.ID, nil, req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude)
+	// 予約中のライドに紐づいたクーポンは除かれるので、予約時刻に適用される見積もりになる
+	discount, err := getUnusedCouponDiscount(ctx, tx, user.ID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	distance := calculateRouteDistance(*req.PickupCoordinate, req.Waypoints, *req.DestinationCoordinate)
+	discounted := calculateFareByDistance(distance, discount)
+
+	// 今マッチングした場合に最も早く着ける椅子で到着予定を見積もる
+	// 見積もりのたびにDBを引かないよう、空いている椅子は直近のマッチングの結果を、位置はメモリ上の最新位置を使う
+	// 予約の場合は予約時刻の椅子の位置がわからないので見積もらない
+	chairs := []*chairWithSpeed{}
+	if req.ScheduledAt == nil {
+		chairs, err = getAvailableChairsForEstimate(ctx, tx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	var estimate *rideETA
+	for _, chair := range chairs {
+		state, ok := chairLocations.Get(chair.ID)
+		if !ok || state.Latest == nil {
+			continue
+		}
+		e := estimateRideETA("MATCHING", *req.PickupCoordinate, *req.DestinationCoordinate, Coordinate{Latitude: state.Latest.Latitude, Longitude: state.Latest.Longitude}, chair.Speed)
+		if e != nil && (estimate == nil || e.PickupMs < estimate.PickupMs) {
+			estimate = e
+		}
+	}
 
 	if err := tx.Commit(); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, &appPostRidesEstimatedFareResponse{
+	res := &appPostRidesEstimatedFareResponse{
 		Fare:     discounted,
-		Discount: calculateFare(req.PickupCoordinate.Latitude, req.PickupCoordinate.Longitude, req.DestinationCoordinate.Latitude, req.DestinationCoordinate.Longitude) - discounted,
-	})
+		Discount: calculateFareByDistance(distance, 0) - discounted,
+		ETA:      estimate,
+	}
+	if req.ScheduledAt != nil {
+		res.ScheduledAt = *req.ScheduledAt
+	}
+	writeJSON(w, http.StatusOK, res)
 }
 
 // マンハッタン距離を求める
@@ -521,7 +702,9 @@ func abs(a int) int {
 }
 
 type appPostRideEvaluationRequest struct {
-	Evaluation int `json:"evaluation"`
+	Evaluation int      `json:"evaluation" validate:"min=1,max=5"`
+	Comment    string   `json:"comment"`
+	Tags       []string `json:"tags"`
 }
 
 type appPostRideEvaluationResponse struct {
@@ -530,6 +713,11 @@ type appPostRideEvaluationResponse struct {
 
 func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 	rideID := r.PathValue("ride_id")
 
 	req := &appPostRideEvaluationRequest{}
@@ -537,8 +725,13 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.Evaluation < 1 || req.Evaluation > 5 {
-		writeError(w, http.StatusBadRequest, errors.New("evaluation must be between 1 and 5"))
+	comment, err := normalizeEvaluationComment(req.Comment)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateEvaluationTags(req.Tags); err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -549,8 +742,9 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
+	// 自動完了のジョブと同時に決済しないよう、ライドをロックしてからキャッシュを通さずにステータスを読む
 	ride := &Ride{}
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ?`, rideID); err != nil {
+	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ? FOR UPDATE`, rideID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, errors.New("ride not found"))
 			return
@@ -558,17 +752,56 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	status, err := getLatestRideStatus(ctx, tx, ride.ID)
-	if err != nil {
+	// 他のユーザーのライドは存在を明かさない
+	if ride.UserID != user.ID {
+		writeError(w, http.StatusNotFound, errors.New("ride not found"))
+		return
+	}
+	var status string
+	if err := tx.GetContext(ctx, &status, `SELECT status FROM ride_statuses WHERE ride_id = ? ORDER BY created_at DESC LIMIT 1`, ride.ID); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	// 評価せずに自動完了したライドは、完了から ride.evaluation_window 以内なら後から評価できる
+	// 評価済みのライドは上書きしない。完了日時が変わらないよう updated_at は据え置く
+	if status == "COMPLETED" {
+		if ride.Evaluation != nil {
+			writeError(w, http.StatusConflict, errors.New("already evaluated"))
+			return
+		}
+		if time.Since(ride.UpdatedAt) > time.Duration(appConfig.Ride.EvaluationWindow) {
+			writeError(w, http.StatusBadRequest, errors.New("evaluation period has expired"))
+			return
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE rides SET evaluation = ?, updated_at = updated_at WHERE id = ? AND evaluation IS NULL`, req.Evaluation, ride.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := saveEvaluationFeedback(ctx, tx, ride.ID, comment, req.Tags); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &appPostRideEvaluationResponse{
+			CompletedAt: ride.UpdatedAt.UnixMilli(),
+		})
+		return
+	}
+
 	if status != "ARRIVED" {
 		writeError(w, http.StatusBadRequest, errors.New("not arrived yet"))
 		return
 	}
 
+	if err := saveEvaluationFeedback(ctx, tx, ride.ID, comment, req.Tags); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	result, err := tx.ExecContext(
 		ctx,
 		`UPDATE rides SET evaluation = ? WHERE id = ?`,
@@ -585,56 +818,11 @@ func appPostRideEvaluatation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = tx.ExecContext(
-		ctx,
-		`INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)`,
-		ulid.Make().String(), rideID, "COMPLETED")
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ?`, rideID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusNotFound, errors.New("ride not found"))
-			return
-		}
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	paymentToken := &PaymentToken{}
-	if err := tx.GetContext(ctx, paymentToken, `SELECT * FROM payment_tokens WHERE user_id = ?`, ride.UserID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusBadRequest, errors.New("payment token not registered"))
+	if err := completeRide(ctx, tx, ride); err != nil {
+		if errors.Is(err, errPaymentTokenNotRegistered) {
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	fare, err := calculateDiscountedFare(ctx, tx, ride.UserID, ride, ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-	paymentGatewayRequest := &paymentGatewayPostPaymentRequest{
-		Amount: fare,
-	}
-
-	var paymentGatewayURL string
-	if err := tx.GetContext(ctx, &paymentGatewayURL, "SELECT value FROM settings WHERE name = 'payment_gateway_url'"); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	if err := requestPaymentGatewayPostPayment(ctx, paymentGatewayURL, paymentToken.Token, paymentGatewayRequest, func() ([]Ride, error) {
-		rides := []Ride{}
-		if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE user_id = ? ORDER BY created_at ASC`, ride.UserID); err != nil {
-			return nil, err
-		}
-		return rides, nil
-	}); err != nil {
 		if errors.Is(err, erroredUpstream) {
 			writeError(w, http.StatusBadGateway, err)
 			return
@@ -667,6 +855,7 @@ type appGetNotificationResponseData struct {
 	Fare                  int                              `json:"fare"`
 	Status                string                           `json:"status"`
 	Chair                 *appGetNotificationResponseChair `json:"chair,omitempty"`
+	ETA                   *rideETA                         `json:"eta,omitempty"`
 	CreatedAt             int64                            `json:"created_at"`
 	UpdateAt              int64                            `json:"updated_at"`
 }
@@ -685,7 +874,11 @@ type appGetNotificationResponseChairStats struct {
 
 func appGetNotification(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	user := ctx.Value("user").(*User)
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 
 	tx, err := db.Beginx()
 	if err != nil {
@@ -767,6 +960,27 @@ func appGetNotification(w http.ResponseWriter, r *http.Request) {
 			Model: chair.Model,
 			Stats: stats,
 		}
+
+		// 椅子の最新の位置とモデルの速度から到着予定を見積もる
+		location, err := getChairLocation(ctx, tx, chair.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		model, err := getChairModel(ctx, tx, chair.Model)
+		if err != nil && !errors.Is(err, errUnknownChairModel) {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if location != nil && model != nil {
+			response.Data.ETA = estimateRideETA(
+				status,
+				response.Data.PickupCoordinate,
+				response.Data.DestinationCoordinate,
+				Coordinate{Latitude: location.Latitude, Longitude: location.Longitude},
+				model.Speed,
+			)
+		}
 	}
 
 	if yetSentRideStatus.ID != "" {
@@ -800,6 +1014,8 @@ func getChairStats(ctx context.Context, tx *sqlx.Tx, chairID string) (appGetNoti
 	}
 
 	totalRideCount := 0
+	// 自動完了したライドは評価がないので、平均は評価のあるライドだけで出す
+	evaluatedRideCount := 0
 	totalEvaluation := 0.0
 	for _, ride := range rides {
 		rideStatuses := []RideStatus{}
@@ -818,7 +1034,8 @@ func getChairStats(ctx context.Context, tx *sqlx.Tx, chairID string) (appGetNoti
 		for _, status := range rideStatuses {
 			if status.Status == "ARRIVED" {
 				arrivedAt = &status.CreatedAt
-			} else if status.Status == "CARRYING" {
+			} else if status.Status == "CARRYING" && pickupedAt == nil {
+				// 経由地を出発するたびに CARRYING が記録されるので、最初のものが乗車日時
 				pickupedAt = &status.CreatedAt
 			}
 			if status.Status == "COMPLETED" {
@@ -833,12 +1050,15 @@ func getChairStats(ctx context.Context, tx *sqlx.Tx, chairID string) (appGetNoti
 		}
 
 		totalRideCount++
-		totalEvaluation += float64(*ride.Evaluation)
+		if ride.Evaluation != nil {
+			evaluatedRideCount++
+			totalEvaluation += float64(*ride.Evaluation)
+		}
 	}
 
 	stats.TotalRidesCount = totalRideCount
-	if totalRideCount > 0 {
-		stats.TotalEvaluationAvg = totalEvaluation / float64(totalRideCount)
+	if evaluatedRideCount > 0 {
+		stats.TotalEvaluationAvg = totalEvaluation / float64(evaluatedRideCount)
 	}
 
 	return stats, nil
@@ -856,15 +1076,12 @@ type appGetNearbyChairsResponseChair struct {
 	CurrentCoordinate Coordinate `json:"current_coordinate"`
 }
 
-var chairLocationCache sync.Map
-
-func getChairLocation(ctx context.Context, tx *sqlx.Tx, chairID string) (*ChairLocation, error) {
+// getChairLocation は椅子の最新の位置情報を返す
+// write-behind が有効な場合はまだDBに書き出していない位置情報も返す
+func getChairLocation(ctx context.Context, tx executableGet, chairID string) (*ChairLocation, error) {
 	// キャッシュから取得
-	if val, found := chairLocationCache.Load(chairID); found {
-		if cachedLocation, ok := val.(*ChairLocation); ok {
-			// キャッシュにヒットした場合、ポインタを返す
-			return cachedLocation, nil
-		}
+	if state, found := chairLocations.Get(chairID); found && state.Latest != nil {
+		return state.Latest, nil
 	}
 
 	// データベースから取得
@@ -880,29 +1097,38 @@ func getChairLocation(ctx context.Context, tx *sqlx.Tx, chairID string) (*ChairL
 	}
 
 	// キャッシュに格納
-	chairLocationCache.Store(chairID, chairLocation)
+	chairLocations.SetLatest(chairLocation)
 
 	return chairLocation, nil
 }
+
 func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	if _, ok := UserFrom(ctx); !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 	latStr := r.URL.Query().Get("latitude")
 	lonStr := r.URL.Query().Get("longitude")
 	distanceStr := r.URL.Query().Get("distance")
-	if latStr == "" || lonStr == "" {
-		writeError(w, http.StatusBadRequest, errors.New("latitude or longitude is empty"))
+	if latStr == "" {
+		writeError(w, http.StatusBadRequest, newFieldError("latitude", "required", "latitude is required"))
+		return
+	}
+	if lonStr == "" {
+		writeError(w, http.StatusBadRequest, newFieldError("longitude", "required", "longitude is required"))
 		return
 	}
 
 	lat, err := strconv.Atoi(latStr)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, errors.New("latitude is invalid"))
+		writeError(w, http.StatusBadRequest, newFieldError("latitude", "int", "latitude must be an integer"))
 		return
 	}
 
 	lon, err := strconv.Atoi(lonStr)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, errors.New("longitude is invalid"))
+		writeError(w, http.StatusBadRequest, newFieldError("longitude", "int", "longitude must be an integer"))
 		return
 	}
 
@@ -910,7 +1136,7 @@ func appGetNearbyChairs(w http.ResponseWriter, r *http.Request) {
 	if distanceStr != "" {
 		distance, err = strconv.Atoi(distanceStr)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, errors.New("distance is invalid"))
+			writeError(w, http.StatusBadRequest, newFieldError("distance", "int", "distance must be an integer"))
 			return
 		}
 	}
@@ -1013,11 +1239,9 @@ func calculateFare(pickupLatitude, pickupLongitude, destLatitude, destLongitude
 func calculateDiscountedFare(ctx context.Context, tx *sqlx.Tx, userID string, ride *Ride, pickupLatitude, pickupLongitude, destLatitude, destLongitude int) (int, error) {
 	var coupon Coupon
 	discount := 0
+	distance := calculateDistance(pickupLatitude, pickupLongitude, destLatitude, destLongitude)
 	if ride != nil {
-		destLatitude = ride.DestinationLatitude
-		destLongitude = ride.DestinationLongitude
-		pickupLatitude = ride.PickupLatitude
-		pickupLongitude = ride.PickupLongitude
+		distance = rideDistance(ride)
 
 		// すでにクーポンが紐づいているならそれの割引額を参照
 		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE used_by = ?", ride.ID); err != nil {
@@ -1028,27 +1252,40 @@ func calculateDiscountedFare(ctx context.Context, tx *sqlx.Tx, userID string, ri
 			discount = coupon.Discount
 		}
 	} else {
-		// 初回利用クーポンを最優先で使う
-		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND code = 'CP_NEW2024' AND used_by IS NULL", userID); err != nil {
+		var err error
+		discount, err = getUnusedCouponDiscount(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return calculateFareByDistance(distance, discount), nil
+}
+
+// getUnusedCouponDiscount は次のライドに適用されるクーポンの割引額を返す
+func getUnusedCouponDiscount(ctx context.Context, tx *sqlx.Tx, userID string) (int, error) {
+	var coupon Coupon
+	// 初回利用クーポンを最優先で使う
+	if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND code = 'CP_NEW2024' AND used_by IS NULL", userID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+
+		// 無いなら他のクーポンを付与された順番に使う
+		if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1", userID); err != nil {
 			if !errors.Is(err, sql.ErrNoRows) {
 				return 0, err
 			}
-
-			// 無いなら他のクーポンを付与された順番に使う
-			if err := tx.GetContext(ctx, &coupon, "SELECT * FROM coupons WHERE user_id = ? AND used_by IS NULL ORDER BY created_at LIMIT 1", userID); err != nil {
-				if !errors.Is(err, sql.ErrNoRows) {
-					return 0, err
-				}
-			} else {
-				discount = coupon.Discount
-			}
-		} else {
-			discount = coupon.Discount
+			return 0, nil
 		}
 	}
+	return coupon.Discount, nil
+}
 
-	meteredFare := farePerDistance * calculateDistance(pickupLatitude, pickupLongitude, destLatitude, destLongitude)
+// calculateFareByDistance は割引額を距離料金から差し引いた運賃を返す。初乗り運賃は割り引かない
+func calculateFareByDistance(distance, discount int) int {
+	meteredFare := farePerDistance * distance
 	discountedMeteredFare := max(meteredFare-discount, 0)
 
-	return initialFare + discountedMeteredFare, nil
+	return initialFare + discountedMeteredFare
 }
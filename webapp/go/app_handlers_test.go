@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAppGetRides(t *testing.T) {
+	user := &User{ID: "user1"}
+	base := time.Date(2024, 11, 1, 10, 0, 0, 0, time.Local)
+	evaluation := 4
+	completed := appGetRidesRow{
+		Ride:       Ride{ID: "ride3", UserID: user.ID, ChairID: sqlNullString("chair1"), DestinationLatitude: 10, Evaluation: &evaluation, CreatedAt: base, UpdatedAt: base.Add(time.Hour)},
+		Status:     "COMPLETED",
+		ChairName:  sqlNullString("chair"),
+		ChairModel: sqlNullString("model"),
+		OwnerName:  sqlNullString("owner"),
+		Discount:   100,
+	}
+	carrying := appGetRidesRow{
+		Ride:   Ride{ID: "ride2", UserID: user.ID, ChairID: sqlNullString("chair1"), DestinationLatitude: 5, CreatedAt: base, UpdatedAt: base},
+		Status: "CARRYING",
+	}
+	matching := appGetRidesRow{
+		Ride:   Ride{ID: "ride1", UserID: user.ID, CreatedAt: base, UpdatedAt: base},
+		Status: "MATCHING",
+	}
+	since := time.Unix(0, 0)
+	until := time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+	const ridesQuery = `WHERE rides.user_id = \?\s+AND rs.status IN \(\?.*\)\s+AND rides.created_at BETWEEN`
+
+	runHandlerTests(t, appGetRides, []handlerTest{
+		{
+			// 従来通り、指定がなければ完了したライドをすべて返す
+			name: "default",
+			setup: func(f *fakeDB) {
+				f.returns(ridesQuery+`.* ORDER BY rides.id DESC$`, completed).with(user.ID, "COMPLETED", since, until)
+			},
+			req:        asUser(newRequest(http.MethodGet, "/api/app/rides", ""), user),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[getAppRidesResponse](t, body)
+				if len(res.Rides) != 1 || res.NextCursor != "" {
+					t.Fatalf("response = %+v, want 1 ride without next_cursor", res)
+				}
+				ride := res.Rides[0]
+				want := getAppRidesResponseItemChair{ID: "chair1", Owner: "owner", Name: "chair", Model: "model"}
+				if ride.ID != "ride3" || ride.Chair != want || ride.Evaluation != 4 || ride.CompletedAt != base.Add(time.Hour).UnixMilli() {
+					t.Errorf("ride = %+v", ride)
+				}
+				if ride.Fare != calculateFareByDistance(rideDistance(&completed.Ride), 100) {
+					t.Errorf("fare = %d, want the discounted fare", ride.Fare)
+				}
+			},
+		},
+		{
+			name: "filtered page",
+			setup: func(f *fakeDB) {
+				f.returns(ridesQuery+`.* AND rides.id < \? ORDER BY rides.id DESC LIMIT \?$`, carrying, matching).
+					with(user.ID, "CARRYING", "MATCHING", time.UnixMilli(1000), time.UnixMilli(2000), "ride9", 2)
+			},
+			req:        asUser(newRequest(http.MethodGet, "/api/app/rides?status=CARRYING,MATCHING&since=1000&until=2000&cursor=ride9&limit=1", ""), user),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[getAppRidesResponse](t, body)
+				if len(res.Rides) != 1 || res.NextCursor != "ride2" {
+					t.Fatalf("response = %+v, want 1 ride and next_cursor ride2", res)
+				}
+				// 完了していないライドには completed_at を入れない
+				if res.Rides[0].ID != "ride2" || res.Rides[0].CompletedAt != 0 {
+					t.Errorf("ride = %+v", res.Rides[0])
+				}
+			},
+		},
+		{
+			name: "cursor without limit",
+			setup: func(f *fakeDB) {
+				f.returns(ridesQuery+`.* LIMIT \?$`).with(user.ID, "COMPLETED", since, until, "ride9", appGetRidesMaxLimit+1)
+			},
+			req:        asUser(newRequest(http.MethodGet, "/api/app/rides?cursor=ride9", ""), user),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				if res := decodeJSON[getAppRidesResponse](t, body); res.Rides == nil || len(res.Rides) != 0 {
+					t.Errorf("rides = %+v, want an empty list", res.Rides)
+				}
+			},
+		},
+		{
+			name:       "unknown status",
+			req:        asUser(newRequest(http.MethodGet, "/api/app/rides?status=COMPLETED,LOST", ""), user),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"status"},
+		},
+		{
+			name:       "invalid until",
+			req:        asUser(newRequest(http.MethodGet, "/api/app/rides?until=tomorrow", ""), user),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"until"},
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodGet, "/api/app/rides", ""),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+// detectRideStatusTransition は椅子が prev から current へ移動したときに、ライドのステータスが変わるかを判定する
+// ENROUTE 中に配車位置を、CARRYING 中に目的地を通過していれば、それぞれ PICKUP, ARRIVED を返す
+// 変化しない場合は空文字を返す。prev が nil の場合は current だけで判定する
+func detectRideStatusTransition(ride *Ride, status string, prev *Coordinate, current Coordinate, radius int) string {
+	switch status {
+	case "ENROUTE":
+		if passedThrough(prev, current, Coordinate{Latitude: ride.PickupLatitude, Longitude: ride.PickupLongitude}, radius) {
+			return "PICKUP"
+		}
+	case "CARRYING":
+		if passedThrough(prev, current, Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude}, radius) {
+			return "ARRIVED"
+		}
+	}
+	return ""
+}
+
+// countPassedWaypoints は prev から current への移動で、waypoints を先頭から順にいくつ通過したかを返す
+// 順番を飛ばして先の経由地を通過しても数えない
+func countPassedWaypoints(waypoints []Coordinate, prev *Coordinate, current Coordinate, radius int) int {
+	passed := 0
+	for _, waypoint := range waypoints {
+		if !passedThrough(prev, current, waypoint, radius) {
+			break
+		}
+		passed++
+	}
+	return passed
+}
+
+// passedThrough は prev から current への移動経路上で target から radius 以内に入ったかを判定する
+// 経路は prev と current を結ぶ線分とみなすので、1回の投稿で目的地を行き過ぎたり、斜めに移動した場合でも判定できる
+func passedThrough(prev *Coordinate, current Coordinate, target Coordinate, radius int) bool {
+	if prev == nil {
+		return calculateDistance(current.Latitude, current.Longitude, target.Latitude, target.Longitude) <= radius
+	}
+
+	// 線分上の点 prev + t(current - prev) (0 <= t <= 1) と target とのマンハッタン距離は t について下に凸な折れ線なので、
+	// 最小値は両端か、緯度・経度の差がそれぞれ0になる t のどれかでとる。t = p/q として整数のまま比べる
+	lat := prev.Latitude - target.Latitude
+	lon := prev.Longitude - target.Longitude
+	dLat := current.Latitude - prev.Latitude
+	dLon := current.Longitude - prev.Longitude
+	within := func(p, q int) bool {
+		return abs(lat*q+p*dLat)+abs(lon*q+p*dLon) <= radius*q
+	}
+	if within(0, 1) || within(1, 1) {
+		return true
+	}
+	for _, t := range [][2]int{{-lat, dLat}, {-lon, dLon}} {
+		p, q := t[0], t[1]
+		if q == 0 {
+			continue
+		}
+		if q < 0 {
+			p, q = -p, -q
+		}
+		if 0 <= p && p <= q && within(p, q) {
+			return true
+		}
+	}
+	return false
+}
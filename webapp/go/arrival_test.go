@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestDetectRideStatusTransition(t *testing.T) {
+	ride := &Ride{
+		PickupLatitude:       0,
+		PickupLongitude:      10,
+		DestinationLatitude:  10,
+		DestinationLongitude: 10,
+	}
+	c := func(lat, lon int) *Coordinate {
+		return &Coordinate{Latitude: lat, Longitude: lon}
+	}
+
+	tests := []struct {
+		name    string
+		status  string
+		prev    *Coordinate
+		current Coordinate
+		radius  int
+		want    string
+	}{
+		{name: "exact pickup", status: "ENROUTE", prev: c(0, 9), current: *c(0, 10), want: "PICKUP"},
+		{name: "exact pickup without previous location", status: "ENROUTE", current: *c(0, 10), want: "PICKUP"},
+		{name: "not reached yet", status: "ENROUTE", prev: c(0, 7), current: *c(0, 9), want: ""},
+		{name: "overshoot pickup by a cell", status: "ENROUTE", prev: c(0, 9), current: *c(0, 11), want: "PICKUP"},
+		{name: "overshoot destination by a cell", status: "CARRYING", prev: c(9, 10), current: *c(11, 10), want: "ARRIVED"},
+		{name: "diagonal move through destination", status: "CARRYING", prev: c(8, 8), current: *c(12, 12), want: "ARRIVED"},
+		{name: "diagonal move passing beside destination", status: "CARRYING", prev: c(8, 9), current: *c(12, 13), want: ""},
+		{name: "diagonal move beside destination within radius", status: "CARRYING", prev: c(8, 9), current: *c(12, 13), radius: 1, want: "ARRIVED"},
+		{name: "long move through destination", status: "CARRYING", prev: c(10, -100000), current: *c(10, 100000), want: "ARRIVED"},
+		{name: "long move beside destination", status: "CARRYING", prev: c(11, -100000), current: *c(11, 100000), want: ""},
+		{name: "within radius", status: "ENROUTE", prev: c(0, 6), current: *c(0, 8), radius: 2, want: "PICKUP"},
+		{name: "outside radius", status: "ENROUTE", prev: c(0, 5), current: *c(0, 7), radius: 2, want: ""},
+		{name: "destination is ignored while enroute", status: "ENROUTE", prev: c(9, 10), current: *c(10, 10), want: ""},
+		{name: "pickup is ignored while carrying", status: "CARRYING", prev: c(0, 9), current: *c(0, 10), want: ""},
+		{name: "repeated post at pickup after transition", status: "PICKUP", prev: c(0, 10), current: *c(0, 10), want: ""},
+		{name: "repeated post at destination after transition", status: "ARRIVED", prev: c(10, 10), current: *c(10, 10), want: ""},
+		{name: "repeated post at pickup while enroute", status: "ENROUTE", prev: c(0, 10), current: *c(0, 10), want: "PICKUP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectRideStatusTransition(ride, tt.status, tt.prev, tt.current, tt.radius); got != tt.want {
+				t.Errorf("detectRideStatusTransition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountPassedWaypoints(t *testing.T) {
+	waypoints := []Coordinate{{Latitude: 0, Longitude: 5}, {Latitude: 0, Longitude: 8}, {Latitude: 3, Longitude: 8}}
+	c := func(lat, lon int) *Coordinate {
+		return &Coordinate{Latitude: lat, Longitude: lon}
+	}
+
+	tests := []struct {
+		name    string
+		prev    *Coordinate
+		current Coordinate
+		want    int
+	}{
+		{name: "not reached", prev: c(0, 0), current: *c(0, 4), want: 0},
+		{name: "first waypoint", prev: c(0, 4), current: *c(0, 5), want: 1},
+		{name: "pass two waypoints in one move", prev: c(0, 4), current: *c(0, 9), want: 2},
+		{name: "skipped waypoint is not counted", prev: c(3, 7), current: *c(3, 9), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countPassedWaypoints(waypoints, tt.prev, tt.current, 0); got != tt.want {
+				t.Errorf("countPassedWaypoints() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
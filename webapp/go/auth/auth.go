@@ -0,0 +1,66 @@
+// Package auth は認証済みの利用者をリクエストのコンテキストに載せる。
+// 文字列のキーを使わず、型ごとのキーで保持するので他のパッケージの値と衝突しない。
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Role は利用者の種類
+type Role string
+
+const (
+	RoleUser  Role = "app"
+	RoleOwner Role = "owner"
+	RoleChair Role = "chair"
+)
+
+// Principal は認証済みの利用者。1つのリクエストに複数のロールの Principal を載せられる
+type Principal struct {
+	Role Role
+	// ID はユーザー、オーナー、椅子のID
+	ID string
+	// SessionID は認証に使ったセッションのID。セッションを使わない認証では空
+	SessionID string
+}
+
+type principalsKey struct{}
+
+type valueKey[T any] struct{}
+
+// WithPrincipal は Principal と、その利用者の値 v をコンテキストに載せる
+// v は From[T] で取り出せる
+func WithPrincipal[T any](ctx context.Context, p Principal, v *T) context.Context {
+	// 親のコンテキストのスライスを書き換えないようにコピーする
+	principals := append(slices.Clone(Principals(ctx)), p)
+	ctx = context.WithValue(ctx, principalsKey{}, principals)
+	return With(ctx, v)
+}
+
+// With は値 v をコンテキストに載せる
+func With[T any](ctx context.Context, v *T) context.Context {
+	return context.WithValue(ctx, valueKey[T]{}, v)
+}
+
+// From は With や WithPrincipal で載せた値を取り出す。載っていない場合は ok=false を返す
+func From[T any](ctx context.Context) (*T, bool) {
+	v, ok := ctx.Value(valueKey[T]{}).(*T)
+	return v, ok && v != nil
+}
+
+// Principals はコンテキストに載っている Principal をすべて返す
+func Principals(ctx context.Context) []Principal {
+	principals, _ := ctx.Value(principalsKey{}).([]Principal)
+	return principals
+}
+
+// PrincipalFor は role の Principal を返す
+func PrincipalFor(ctx context.Context, role Role) (Principal, bool) {
+	for _, p := range Principals(ctx) {
+		if p.Role == role {
+			return p, true
+		}
+	}
+	return Principal{}, false
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+type user struct{ ID string }
+type chair struct{ ID string }
+
+func TestFrom(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := From[user](ctx); ok {
+		t.Fatal("From() on empty context should not be ok")
+	}
+
+	ctx = WithPrincipal(ctx, Principal{Role: RoleUser, ID: "u1"}, &user{ID: "u1"})
+	if u, ok := From[user](ctx); !ok || u.ID != "u1" {
+		t.Errorf("From[user]() = %v, %v", u, ok)
+	}
+	if _, ok := From[chair](ctx); ok {
+		t.Error("From[chair]() should not be ok when only a user is set")
+	}
+
+	// 文字列のキーで同じ名前の値を載せても衝突しない
+	ctx = context.WithValue(ctx, "user", "not a user")
+	if u, ok := From[user](ctx); !ok || u.ID != "u1" {
+		t.Errorf("From[user]() = %v, %v after setting a string key", u, ok)
+	}
+
+	if _, ok := From[user](With[user](context.Background(), nil)); ok {
+		t.Error("From() should not be ok for a nil value")
+	}
+}
+
+func TestPrincipals(t *testing.T) {
+	parent := WithPrincipal(context.Background(), Principal{Role: RoleOwner, ID: "o1"}, &user{})
+	ctx := WithPrincipal(parent, Principal{Role: RoleChair, ID: "c1"}, &chair{})
+
+	if got := len(Principals(ctx)); got != 2 {
+		t.Fatalf("len(Principals()) = %d, want 2", got)
+	}
+	if p, ok := PrincipalFor(ctx, RoleChair); !ok || p.ID != "c1" {
+		t.Errorf("PrincipalFor(chair) = %v, %v", p, ok)
+	}
+	if p, ok := PrincipalFor(ctx, RoleOwner); !ok || p.ID != "o1" {
+		t.Errorf("PrincipalFor(owner) = %v, %v", p, ok)
+	}
+	if _, ok := PrincipalFor(ctx, RoleUser); ok {
+		t.Error("PrincipalFor(user) should not be ok")
+	}
+	// 親のコンテキストには子で追加した Principal は見えない
+	if _, ok := PrincipalFor(parent, RoleChair); ok {
+		t.Error("parent context should not see the child principal")
+	}
+}
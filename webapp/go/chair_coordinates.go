@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/oklog/ulid/v2"
+)
+
+type chairCoordinatePoint struct {
+	Latitude   int
+	Longitude  int
+	RecordedAt time.Time
+}
+
+type chairRideStatusTransition struct {
+	// Index はステータス変更のきっかけになった位置情報の添字
+	Index  int    `json:"index"`
+	RideID string `json:"ride_id"`
+	Status string `json:"status"`
+}
+
+type chairWaypointArrival struct {
+	// Index は到着のきっかけになった位置情報の添字
+	Index      int    `json:"index"`
+	RideID     string `json:"ride_id"`
+	WaypointID string `json:"waypoint_id"`
+	Position   int    `json:"position"`
+}
+
+type chairCoordinatesResult struct {
+	// Skipped は保存済みの最新の位置情報より古いために捨てた先頭の位置情報の数
+	Skipped          int
+	MovedDistance    int
+	Latest           ChairLocation
+	Writes           []chairLocationWrite
+	Transitions      []chairRideStatusTransition
+	WaypointArrivals []chairWaypointArrival
+}
+
+// recordChairCoordinates は椅子の位置情報を古い順に適用する
+// 移動距離の積算とPICKUP/ARRIVEDの判定を1点ずつ行い、位置情報はまとめて1回のINSERTで書き込む
+// points は1件以上で、RecordedAt の昇順に並んでいること
+// 保存済みの最新の位置情報より古い点は、移動距離を二重に数えないよう捨てる
+// write-behind が有効な場合、位置情報と総移動距離の書き込みはコミット後に applyChairCoordinatesResult で行う
+func recordChairCoordinates(ctx context.Context, tx *sqlx.Tx, chair *Chair, points []chairCoordinatePoint) (*chairCoordinatesResult, error) {
+	result := &chairCoordinatesResult{Transitions: []chairRideStatusTransition{}, WaypointArrivals: []chairWaypointArrival{}}
+
+	// 初回の位置情報は移動距離に含めない
+	var prev *ChairLocation
+	lastChairLocation, err := getChairLocation(ctx, tx, chair.ID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	} else {
+		prev = lastChairLocation
+	}
+
+	var ride *Ride
+	status := ""
+	waypoints := []RideWaypoint{}
+	r := &Ride{}
+	if err := tx.GetContext(ctx, r, `SELECT * FROM rides WHERE chair_id = ? ORDER BY updated_at DESC LIMIT 1`, chair.ID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	} else {
+		ride = r
+		status, err = getLatestRideStatus(ctx, tx, ride.ID)
+		if err != nil {
+			return nil, err
+		}
+		waypoints, err = getPendingRideWaypoints(ctx, tx, ride)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if prev != nil {
+		result.Skipped = countStaleCoordinates(points, prev.CreatedAt)
+	}
+	if result.Skipped == len(points) {
+		result.Latest = *prev
+		result.Writes = []chairLocationWrite{}
+		return result, nil
+	}
+
+	result.Writes = make([]chairLocationWrite, 0, len(points)-result.Skipped)
+	for i := result.Skipped; i < len(points); i++ {
+		point := points[i]
+		movedDistance := 0
+		var prevCoordinate *Coordinate
+		if prev != nil {
+			movedDistance = calculateDistance(prev.Latitude, prev.Longitude, point.Latitude, point.Longitude)
+			prevCoordinate = &Coordinate{Latitude: prev.Latitude, Longitude: prev.Longitude}
+		}
+		result.MovedDistance += movedDistance
+
+		location := ChairLocation{
+			ID:        ulid.Make().String(),
+			ChairID:   chair.ID,
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			CreatedAt: point.RecordedAt,
+		}
+		result.Writes = append(result.Writes, chairLocationWrite{Location: location, MovedDistance: movedDistance})
+		prev = &location
+
+		if ride == nil {
+			continue
+		}
+		current := Coordinate{Latitude: point.Latitude, Longitude: point.Longitude}
+		if status == "CARRYING" && len(waypoints) > 0 {
+			coordinates := make([]Coordinate, 0, len(waypoints))
+			for _, waypoint := range waypoints {
+				coordinates = append(coordinates, Coordinate{Latitude: waypoint.Latitude, Longitude: waypoint.Longitude})
+			}
+			passed := countPassedWaypoints(coordinates, prevCoordinate, current, appConfig.Ride.ArrivalRadius)
+			for _, waypoint := range waypoints[:passed] {
+				result.WaypointArrivals = append(result.WaypointArrivals, chairWaypointArrival{
+					Index:      i,
+					RideID:     ride.ID,
+					WaypointID: waypoint.ID,
+					Position:   waypoint.Position,
+				})
+			}
+			waypoints = waypoints[passed:]
+			// 経由地を回り終えるまでは目的地を通っても到着にしない
+			if len(waypoints) > 0 {
+				continue
+			}
+		}
+		if newStatus := detectRideStatusTransition(ride, status, prevCoordinate, current, appConfig.Ride.ArrivalRadius); newStatus != "" {
+			status = newStatus
+			result.Transitions = append(result.Transitions, chairRideStatusTransition{
+				Index:  i,
+				RideID: ride.ID,
+				Status: newStatus,
+			})
+		}
+	}
+	result.Latest = result.Writes[len(result.Writes)-1].Location
+
+	// 経由地への到着は、同じ投稿で目的地に着いた場合の ARRIVED より前に記録する
+	for _, arrival := range result.WaypointArrivals {
+		if err := recordWaypointArrival(ctx, tx, arrival, points[arrival.Index].RecordedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, transition := range result.Transitions {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), transition.RideID, transition.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	if chairLocationWriter != nil {
+		return result, nil
+	}
+
+	locations := make([]ChairLocation, 0, len(result.Writes))
+	for _, write := range result.Writes {
+		locations = append(locations, write.Location)
+	}
+	if _, err := tx.NamedExecContext(
+		ctx,
+		`INSERT INTO chair_locations (id, chair_id, latitude, longitude, created_at) VALUES (:id, :chair_id, :latitude, :longitude, :created_at)`,
+		locations,
+	); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE chairs
+		SET
+			total_distance = total_distance + ?,
+			total_distance_updated_at = ?
+		WHERE id = ?;`
+	if _, err := tx.ExecContext(ctx, query, result.MovedDistance, result.Latest.CreatedAt, chair.ID); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// countStaleCoordinates は RecordedAt の昇順に並んだ points のうち、latest より古い先頭の点の数を返す
+func countStaleCoordinates(points []chairCoordinatePoint, latest time.Time) int {
+	for i, point := range points {
+		if !point.RecordedAt.Before(latest) {
+			return i
+		}
+	}
+	return len(points)
+}
+
+// applyChairCoordinatesResult はコミット後に各種キャッシュへ反映する
+// write-behind が有効な場合はここで位置情報を書き込みキューに積む
+func applyChairCoordinatesResult(chair *Chair, result *chairCoordinatesResult) {
+	chairLocations.Apply(chair, result.Latest, result.MovedDistance)
+	if chairLocationWriter != nil {
+		for _, write := range result.Writes {
+			chairLocationWriter.Send(write)
+		}
+	}
+
+	// chair はリクエストの開始時に読んだ値なので、キャッシュには総移動距離だけを反映する
+	// 名前などを書き換えて無効化されたキャッシュに、古い値を入れ直さないようにする
+	chairTokenCache.Update(chair.ID, func(cached Chair) Chair {
+		cached.TotalDistance += result.MovedDistance
+		if !cached.TotalDistanceUpdatedAt.Valid || result.Latest.CreatedAt.After(cached.TotalDistanceUpdatedAt.Time) {
+			cached.TotalDistanceUpdatedAt = sql.NullTime{Time: result.Latest.CreatedAt, Valid: true}
+		}
+		return cached
+	})
+
+	for _, transition := range result.Transitions {
+		rideStatusCache.Store(transition.RideID, transition.Status)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountStaleCoordinates(t *testing.T) {
+	base := time.UnixMilli(1_700_000_000_000)
+	points := []chairCoordinatePoint{
+		{RecordedAt: base.Add(-2 * time.Second)},
+		{RecordedAt: base.Add(-time.Second)},
+		{RecordedAt: base},
+		{RecordedAt: base.Add(time.Second)},
+	}
+
+	tests := []struct {
+		name   string
+		latest time.Time
+		want   int
+	}{
+		{name: "all new", latest: base.Add(-3 * time.Second), want: 0},
+		{name: "same timestamp is kept", latest: base, want: 2},
+		{name: "all stale", latest: base.Add(2 * time.Second), want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countStaleCoordinates(points, tt.latest); got != tt.want {
+				t.Errorf("countStaleCoordinates() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
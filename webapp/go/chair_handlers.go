@@ -7,13 +7,14 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/oklog/ulid/v2"
 )
 
 type chairPostChairsRequest struct {
-	Name               string `json:"name"`
-	Model              string `json:"model"`
-	ChairRegisterToken string `json:"chair_register_token"`
+	Name               string `json:"name" validate:"required,max=30"`
+	Model              string `json:"model" validate:"required"`
+	ChairRegisterToken string `json:"chair_register_token" validate:"required"`
 }
 
 type chairPostChairsResponse struct {
@@ -25,16 +26,20 @@ func chairPostChairs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	req := &chairPostChairsRequest{}
 	if err := bindJSON(r, req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.Name == "" || req.Model == "" || req.ChairRegisterToken == "" {
-		writeError(w, http.StatusBadRequest, errors.New("some of required fields(name, model, chair_register_token) are empty"))
+
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	defer tx.Rollback()
 
-	owner := &Owner{}
-	if err := db.GetContext(ctx, owner, "SELECT * FROM owners WHERE chair_register_token = ?", req.ChairRegisterToken); err != nil {
+	now := time.Now()
+	registerToken := &ChairRegisterToken{}
+	if err := tx.GetContext(ctx, registerToken, "SELECT * FROM chair_register_tokens WHERE token = ? FOR UPDATE", req.ChairRegisterToken); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusUnauthorized, errors.New("invalid chair_register_token"))
 			return
@@ -42,56 +47,105 @@ func chairPostChairs(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if err := validateChairRegisterToken(registerToken, now); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := validateChairModel(ctx, tx, req.Model); err != nil {
+		if errors.Is(err, errUnknownChairModel) || errors.Is(err, errRetiredChairModel) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	chairID := ulid.Make().String()
 	accessToken := secureRandomStr(32)
+	hashedToken := hashAccessToken(accessToken)
 
-	now := time.Now()
-	_, err := db.ExecContext(
+	_, err = tx.ExecContext(
 		ctx,
-		"INSERT INTO chairs (id, owner_id, name, model, is_active, access_token,created_at,updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		chairID, owner.ID, req.Name, req.Model, false, accessToken, now, now,
+		"INSERT INTO chairs (id, owner_id, name, model, is_active, access_token, created_at, updated_at, register_token_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		chairID, registerToken.OwnerID, req.Name, req.Model, false, hashedToken, now, now, registerToken.ID,
 	)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	if _, err := tx.ExecContext(ctx, "UPDATE chair_register_tokens SET used_count = used_count + 1 WHERE id = ?", registerToken.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	session, err := createSession(ctx, tx, sessionRoleChair, chairID, hashedToken, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := createPrincipalCredential(ctx, tx, sessionRoleChair, chairID, hashedToken, now); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	newChair := Chair{
-		ID:          chairID,
-		OwnerID:     owner.ID,
-		Name:        req.Name,
-		Model:       req.Model,
-		IsActive:    false,
-		AccessToken: accessToken,
-		CreatedAt:   now,
-		UpdatedAt:   now,
-	}
-	chairTokenCache.Store(accessToken, newChair)
-
-	http.SetCookie(w, &http.Cookie{
-		Path:  "/",
-		Name:  "chair_session",
-		Value: accessToken,
-	})
+		ID:              chairID,
+		OwnerID:         registerToken.OwnerID,
+		Name:            req.Name,
+		Model:           req.Model,
+		IsActive:        false,
+		AccessToken:     hashedToken,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		RegisterTokenID: sql.NullString{String: registerToken.ID, Valid: true},
+	}
+	chairTokenCache.Store(newChair.ID, newChair)
+	cacheSession(session)
+
+	setSessionCookie(w, sessionRoleChair, accessToken)
 
 	writeJSON(w, http.StatusCreated, &chairPostChairsResponse{
 		ID:      chairID,
-		OwnerID: owner.ID,
+		OwnerID: registerToken.OwnerID,
 	})
 }
 
+// validateChairRegisterToken は椅子登録トークンが現時点で利用可能かどうかを検証する
+func validateChairRegisterToken(token *ChairRegisterToken, now time.Time) error {
+	if token.RevokedAt != nil {
+		return errors.New("chair_register_token has been revoked")
+	}
+	if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+		return errors.New("chair_register_token has expired")
+	}
+	if token.MaxUses != nil && token.UsedCount >= *token.MaxUses {
+		return errors.New("chair_register_token has reached its usage limit")
+	}
+	return nil
+}
+
 type postChairActivityRequest struct {
 	IsActive bool `json:"is_active"`
 }
 
 func chairPostActivity(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	chair := ctx.Value("chair").(*Chair)
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 
 	req := &postChairActivityRequest{}
 	if err := bindJSON(r, req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -110,14 +164,18 @@ type chairPostCoordinateResponse struct {
 
 func chairPostCoordinate(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
 	req := &Coordinate{}
 	if err := bindJSON(r, req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	chair := ctx.Value("chair").(*Chair)
-
 	tx, err := db.Beginx()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
@@ -125,81 +183,95 @@ func chairPostCoordinate(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	//移動距離の計算と総移動距離の更新
-	lastChairLocation, err := getChairLocation(ctx, tx, chair.ID)
-	prevLatitude := 0
-	prevLongtitude := 0
+	result, err := recordChairCoordinates(ctx, tx, chair, []chairCoordinatePoint{
+		{Latitude: req.Latitude, Longitude: req.Longitude, RecordedAt: time.Now()},
+	})
 	if err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
-	} else {
-		prevLongtitude = lastChairLocation.Longitude
-		prevLatitude = lastChairLocation.Latitude
-	}
-	movedDistance := abs(prevLatitude-req.Latitude) + abs(prevLongtitude-req.Longitude)
-
-	now := time.Now()
-	query := `
-		UPDATE chairs
-		SET 
-			total_distance = total_distance + ?, 
-			total_distance_updated_at = ?
-		WHERE id = ?;`
-	if _, err := tx.ExecContext(ctx, query, movedDistance, now, chair.ID); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	chairLocationID := ulid.Make().String()
-	if _, err := tx.ExecContext(
-		ctx,
-		`INSERT INTO chair_locations (id, chair_id, latitude, longitude, created_at) VALUES (?, ?, ?, ?, ?)`,
-		chairLocationID, chair.ID, req.Latitude, req.Longitude, now,
-	); err != nil {
+	if err := tx.Commit(); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	// 更新したchair.idのキャッシュをクリア
-	chairLocationCache.Delete(chair.ID)
+	applyChairCoordinatesResult(chair, result)
+
+	writeJSON(w, http.StatusOK, &chairPostCoordinateResponse{
+		RecordedAt: result.Latest.CreatedAt.UnixMilli(),
+	})
+}
+
+type chairPostCoordinatesRequest struct {
+	Coordinates []chairPostCoordinatesRequestCoordinate `json:"coordinates" validate:"required,max=1000"`
+}
 
-	location := ChairLocation{ID: chairLocationID, ChairID: chair.ID, Latitude: req.Latitude, Longitude: req.Longitude, CreatedAt: now}
+type chairPostCoordinatesRequestCoordinate struct {
+	Latitude  int `json:"latitude" validate:"min=-100000,max=100000"`
+	Longitude int `json:"longitude" validate:"min=-100000,max=100000"`
+	// Timestamp は計測日時(UNIXミリ秒)。省略した場合はサーバーの受信日時になる
+	Timestamp *int64 `json:"timestamp"`
+}
 
-	ride := &Ride{}
-	var newStatus string //変更後のステータス
-	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE chair_id = ? ORDER BY updated_at DESC LIMIT 1`, chair.ID); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusInternalServerError, err)
+type chairPostCoordinatesResponse struct {
+	RecordedAt int64 `json:"recorded_at"`
+	// Skipped は記録済みの位置情報より古いために記録しなかった位置情報の数
+	Skipped     int                         `json:"skipped"`
+	Transitions []chairRideStatusTransition `json:"transitions"`
+	// WaypointArrivals は経由地への到着。経由地のないライドでは常に空
+	WaypointArrivals []chairWaypointArrival `json:"waypoint_arrivals"`
+}
+
+func chairPostCoordinates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	req := &chairPostCoordinatesRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now()
+	points := make([]chairCoordinatePoint, 0, len(req.Coordinates))
+	for i, c := range req.Coordinates {
+		recordedAt := now
+		if c.Timestamp != nil {
+			recordedAt = time.UnixMilli(*c.Timestamp)
+		}
+		if recordedAt.After(now) {
+			field := fmt.Sprintf("coordinates[%d].timestamp", i)
+			writeError(w, http.StatusBadRequest, newFieldError(field, "future", field+" is in the future"))
 			return
 		}
-	} else {
-		status, err := getLatestRideStatus(ctx, tx, ride.ID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
+		if i > 0 && recordedAt.Before(points[i-1].RecordedAt) {
+			field := fmt.Sprintf("coordinates[%d].timestamp", i)
+			writeError(w, http.StatusBadRequest, newFieldError(field, "order", fmt.Sprintf("coordinates[%d] is out of order", i)))
 			return
 		}
-		if status != "COMPLETED" && status != "CANCELED" {
-			if req.Latitude == ride.PickupLatitude && req.Longitude == ride.PickupLongitude && status == "ENROUTE" {
-				if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), ride.ID, "PICKUP"); err != nil {
-					writeError(w, http.StatusInternalServerError, err)
-					return
-				}
-				newStatus = "PICKUP"
-				// rideStatusCache.Store(ride.ID, "PICKUP")
-			}
+		points = append(points, chairCoordinatePoint{
+			Latitude:   c.Latitude,
+			Longitude:  c.Longitude,
+			RecordedAt: recordedAt,
+		})
+	}
 
-			if req.Latitude == ride.DestinationLatitude && req.Longitude == ride.DestinationLongitude && status == "CARRYING" {
-				if _, err := tx.ExecContext(ctx, "INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)", ulid.Make().String(), ride.ID, "ARRIVED"); err != nil {
-					writeError(w, http.StatusInternalServerError, err)
-					return
-				}
-				newStatus = "ARRIVED"
-				// rideStatusCache.Store(ride.ID, "ARRIVED")
-			}
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
 
-		}
+	result, err := recordChairCoordinates(ctx, tx, chair, points)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -207,24 +279,21 @@ func chairPostCoordinate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chair.TotalDistanceUpdatedAt.Time = now
-	chair.TotalDistanceUpdatedAt.Valid = true
-	chair.TotalDistance += movedDistance
-	chairTokenCache.Store(chair.AccessToken, *chair)
+	applyChairCoordinatesResult(chair, result)
 
-	if newStatus != "" {
-		rideStatusCache.Store(ride.ID, newStatus)
-		// log.Printf("Updated cache for rideID: %s with status: %s", ride.ID, newStatus)
-	}
-
-	writeJSON(w, http.StatusOK, &chairPostCoordinateResponse{
-		RecordedAt: location.CreatedAt.UnixMilli(),
+	writeJSON(w, http.StatusOK, &chairPostCoordinatesResponse{
+		RecordedAt:       result.Latest.CreatedAt.UnixMilli(),
+		Skipped:          result.Skipped,
+		Transitions:      result.Transitions,
+		WaypointArrivals: result.WaypointArrivals,
 	})
 }
 
 type simpleUser struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
+	// EvaluationAvg は椅子からの評価の平均。まだ評価がなければ省略する
+	EvaluationAvg *float64 `json:"evaluation_avg,omitempty"`
 }
 
 type chairGetNotificationResponse struct {
@@ -238,11 +307,17 @@ type chairGetNotificationResponseData struct {
 	PickupCoordinate      Coordinate `json:"pickup_coordinate"`
 	DestinationCoordinate Coordinate `json:"destination_coordinate"`
 	Status                string     `json:"status"`
+	// NextWaypoint は配車後に次に向かう経由地。経由地がないか回り終えていれば省略する
+	NextWaypoint *Coordinate `json:"next_waypoint,omitempty"`
 }
 
 func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	chair := ctx.Value("chair").(*Chair)
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 
 	tx, err := db.Beginx()
 	if err != nil {
@@ -287,6 +362,25 @@ func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	evaluations, err := getUserEvaluationStats(ctx, tx, []string{user.ID})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var evaluationAvg *float64
+	if stats, ok := evaluations[user.ID]; ok {
+		evaluationAvg = &stats.Avg
+	}
+
+	var nextWaypoint *Coordinate
+	if status == "PICKUP" || status == "CARRYING" {
+		nextWaypoint, err = getNextRideWaypoint(ctx, tx, ride)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	if yetSentRideStatus.ID != "" {
 		_, err := tx.ExecContext(ctx, `UPDATE ride_statuses SET chair_sent_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, yetSentRideStatus.ID)
 		if err != nil {
@@ -304,8 +398,9 @@ func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 		Data: &chairGetNotificationResponseData{
 			RideID: ride.ID,
 			User: simpleUser{
-				ID:   user.ID,
-				Name: fmt.Sprintf("%s %s", user.Firstname, user.Lastname),
+				ID:            user.ID,
+				Name:          fmt.Sprintf("%s %s", user.Firstname, user.Lastname),
+				EvaluationAvg: evaluationAvg,
 			},
 			PickupCoordinate: Coordinate{
 				Latitude:  ride.PickupLatitude,
@@ -315,21 +410,26 @@ func chairGetNotification(w http.ResponseWriter, r *http.Request) {
 				Latitude:  ride.DestinationLatitude,
 				Longitude: ride.DestinationLongitude,
 			},
-			Status: status,
+			Status:       status,
+			NextWaypoint: nextWaypoint,
 		},
 		RetryAfterMs: 1000,
 	})
 }
 
 type postChairRidesRideIDStatusRequest struct {
-	Status string `json:"status"`
+	Status string `json:"status" validate:"required,oneof=ENROUTE CARRYING"`
 }
 
 func chairPostRideStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	rideID := r.PathValue("ride_id")
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 
-	chair := ctx.Value("chair").(*Chair)
+	rideID := r.PathValue("ride_id")
 
 	req := &postChairRidesRideIDStatusRequest{}
 	if err := bindJSON(r, req); err != nil {
@@ -395,3 +495,165 @@ func chairPostRideStatus(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+type chairGetRidesResponse struct {
+	Rides      []chairGetRidesResponseItem `json:"rides"`
+	NextCursor string                      `json:"next_cursor,omitempty"`
+}
+
+type chairGetRidesResponseItem struct {
+	ID                    string                        `json:"id"`
+	PickupCoordinate      Coordinate                    `json:"pickup_coordinate"`
+	DestinationCoordinate Coordinate                    `json:"destination_coordinate"`
+	Fare                  int                           `json:"fare"`
+	Evaluation            *int                          `json:"evaluation"`
+	Status                string                        `json:"status"`
+	Statuses              []chairGetRidesResponseStatus `json:"statuses"`
+	RequestedAt           int64                         `json:"requested_at"`
+}
+
+type chairGetRidesResponseStatus struct {
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+const (
+	chairGetRidesDefaultLimit = 20
+	chairGetRidesMaxLimit     = 100
+)
+
+func chairGetRides(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	// ライドIDはULIDなので、IDの降順がそのまま要求日時の降順になる
+	cursor := r.URL.Query().Get("cursor")
+	limit, err := parseQueryLimit(r, "limit", chairGetRidesDefaultLimit, chairGetRidesMaxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rides := []Ride{}
+	if cursor == "" {
+		if err := db.SelectContext(ctx, &rides, "SELECT * FROM rides WHERE chair_id = ? ORDER BY id DESC LIMIT ?", chair.ID, limit+1); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		if err := db.SelectContext(ctx, &rides, "SELECT * FROM rides WHERE chair_id = ? AND id < ? ORDER BY id DESC LIMIT ?", chair.ID, cursor, limit+1); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	res := chairGetRidesResponse{Rides: []chairGetRidesResponseItem{}}
+	if len(rides) > limit {
+		rides = rides[:limit]
+		res.NextCursor = rides[limit-1].ID
+	}
+	if len(rides) == 0 {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	rideIDs := make([]string, 0, len(rides))
+	for _, ride := range rides {
+		rideIDs = append(rideIDs, ride.ID)
+	}
+	query, args, err := sqlx.In("SELECT * FROM ride_statuses WHERE ride_id IN (?) ORDER BY created_at", rideIDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rideStatuses := []RideStatus{}
+	if err := db.SelectContext(ctx, &rideStatuses, db.Rebind(query), args...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	statusesByRideID := map[string][]chairGetRidesResponseStatus{}
+	for _, status := range rideStatuses {
+		statusesByRideID[status.RideID] = append(statusesByRideID[status.RideID], chairGetRidesResponseStatus{
+			Status:    status.Status,
+			CreatedAt: status.CreatedAt.UnixMilli(),
+		})
+	}
+
+	for _, ride := range rides {
+		item := chairGetRidesResponseItem{
+			ID:                    ride.ID,
+			PickupCoordinate:      Coordinate{Latitude: ride.PickupLatitude, Longitude: ride.PickupLongitude},
+			DestinationCoordinate: Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude},
+			Fare:                  calculateSale(ride),
+			Evaluation:            ride.Evaluation,
+			Statuses:              []chairGetRidesResponseStatus{},
+			RequestedAt:           ride.CreatedAt.UnixMilli(),
+		}
+		if statuses, ok := statusesByRideID[ride.ID]; ok {
+			item.Statuses = statuses
+			item.Status = statuses[len(statuses)-1].Status
+		}
+		res.Rides = append(res.Rides, item)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type chairGetEarningsResponse struct {
+	TotalSales int                      `json:"total_sales"`
+	TotalRides int                      `json:"total_rides"`
+	Days       []chairGetEarningsPerDay `json:"days"`
+}
+
+type chairGetEarningsPerDay struct {
+	Date  string `json:"date"`
+	Rides int    `json:"rides"`
+	Sales int    `json:"sales"`
+}
+
+func chairGetEarnings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	since, err := parseQueryUnixMilli(r, "since", time.Unix(0, 0))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	until, err := parseQueryUnixMilli(r, "until", time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rides := []Ride{}
+	if err := db.SelectContext(ctx, &rides, "SELECT rides.* FROM rides JOIN ride_statuses ON rides.id = ride_statuses.ride_id WHERE chair_id = ? AND status = 'COMPLETED' AND updated_at BETWEEN ? AND ? + INTERVAL 999 MICROSECOND ORDER BY updated_at", chair.ID, since, until); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := chairGetEarningsResponse{Days: []chairGetEarningsPerDay{}}
+	for _, ride := range rides {
+		sale := calculateSale(ride)
+		res.TotalSales += sale
+		res.TotalRides++
+
+		// ridesは完了日時の昇順なので、同じ日付は連続して並ぶ
+		date := ride.UpdatedAt.Format(time.DateOnly)
+		if len(res.Days) == 0 || res.Days[len(res.Days)-1].Date != date {
+			res.Days = append(res.Days, chairGetEarningsPerDay{Date: date})
+		}
+		res.Days[len(res.Days)-1].Rides++
+		res.Days[len(res.Days)-1].Sales += sale
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
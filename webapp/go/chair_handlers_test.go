@@ -0,0 +1,278 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChairPostChairs(t *testing.T) {
+	t.Cleanup(func() {
+		chairTokenCache.Clear()
+		sessionCache.Clear()
+	})
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	two := 2
+	registerToken := func(modify func(token *ChairRegisterToken)) ChairRegisterToken {
+		token := ChairRegisterToken{ID: "token1", OwnerID: "owner1", Name: "default", Token: "register-token", CreatedAt: past}
+		if modify != nil {
+			modify(&token)
+		}
+		return token
+	}
+	newPost := func(body string) *http.Request {
+		return newRequest(http.MethodPost, "/api/chair/chairs", body)
+	}
+	const body = `{"name":"chair","model":"model1","chair_register_token":"register-token"}`
+
+	runHandlerTests(t, chairPostChairs, []handlerTest{
+		{
+			name: "registered",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`, registerToken(func(token *ChairRegisterToken) {
+					token.MaxUses = &two
+					token.UsedCount = 1
+					token.ExpiresAt = &future
+				})).with("register-token")
+				f.returns(`FROM chair_models WHERE name = \?`, ChairModel{Name: "model1", Speed: 3}).with("model1")
+				f.exec(`^INSERT INTO chairs`, 1)
+				f.exec(`^UPDATE chair_register_tokens SET used_count = used_count \+ 1`, 1).with("token1")
+				f.exec(`^INSERT INTO sessions`, 1)
+				f.exec(`^INSERT INTO principal_credentials`, 1)
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusCreated,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[chairPostChairsResponse](t, body)
+				if res.OwnerID != "owner1" || res.ID == "" {
+					t.Errorf("response = %+v", res)
+				}
+				inserts := f.executed(`^INSERT INTO chairs`)
+				if len(inserts) != 1 || inserts[0].Args[0] != res.ID || inserts[0].Args[8] != "token1" {
+					t.Errorf("INSERT INTO chairs = %+v, want the chair registered with token1", inserts)
+				}
+				if len(f.executed(`^UPDATE chair_register_tokens`)) != 1 {
+					t.Error("used_count should be incremented")
+				}
+			},
+		},
+		{
+			name:       "missing token",
+			req:        newPost(`{"name":"chair","model":"model1"}`),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"chair_register_token"},
+		},
+		{
+			name: "unknown token",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`)
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+		{
+			name: "revoked token",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`, registerToken(func(token *ChairRegisterToken) { token.RevokedAt = &past }))
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+		{
+			name: "expired token",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`, registerToken(func(token *ChairRegisterToken) { token.ExpiresAt = &past }))
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+		{
+			name: "usage limit reached",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`, registerToken(func(token *ChairRegisterToken) {
+					token.MaxUses = &two
+					token.UsedCount = 2
+				}))
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+		{
+			name: "unknown model",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`, registerToken(nil))
+				f.returns(`FROM chair_models WHERE name = \?`)
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeBadRequest,
+		},
+		{
+			name: "retired model",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_register_tokens WHERE token = \? FOR UPDATE`, registerToken(nil))
+				f.returns(`FROM chair_models WHERE name = \?`, ChairModel{Name: "model1", Speed: 3, RetiredAt: &past})
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeBadRequest,
+		},
+	})
+}
+
+func TestChairGetRides(t *testing.T) {
+	chair := &Chair{ID: "chair1", OwnerID: "owner1"}
+	base := time.Date(2024, 11, 1, 10, 0, 0, 0, time.Local)
+	evaluation := 5
+	rides := []any{
+		Ride{ID: "ride3", UserID: "user1", ChairID: sqlNullString(chair.ID), PickupLatitude: 0, PickupLongitude: 0, DestinationLatitude: 10, DestinationLongitude: 10, CreatedAt: base.Add(2 * time.Hour), UpdatedAt: base.Add(2 * time.Hour)},
+		Ride{ID: "ride2", UserID: "user1", ChairID: sqlNullString(chair.ID), DestinationLatitude: 3, Evaluation: &evaluation, CreatedAt: base.Add(time.Hour), UpdatedAt: base.Add(time.Hour)},
+		Ride{ID: "ride1", UserID: "user1", ChairID: sqlNullString(chair.ID), CreatedAt: base, UpdatedAt: base},
+	}
+	statuses := []any{
+		RideStatus{ID: "s1", RideID: "ride3", Status: "ENROUTE", CreatedAt: base.Add(2 * time.Hour)},
+		RideStatus{ID: "s2", RideID: "ride2", Status: "ENROUTE", CreatedAt: base.Add(time.Hour)},
+		RideStatus{ID: "s3", RideID: "ride3", Status: "PICKUP", CreatedAt: base.Add(2*time.Hour + time.Minute)},
+		RideStatus{ID: "s4", RideID: "ride2", Status: "COMPLETED", CreatedAt: base.Add(time.Hour + time.Minute)},
+	}
+
+	runHandlerTests(t, chairGetRides, []handlerTest{
+		{
+			name: "first page",
+			setup: func(f *fakeDB) {
+				// 次のページがあるかを判定するために limit より1件多く読む
+				f.returns(`FROM rides WHERE chair_id = \? ORDER BY id DESC LIMIT \?`, rides...).with(chair.ID, 3)
+				f.returns(`FROM ride_statuses WHERE ride_id IN \(\?, \?\)`, statuses...).with("ride3", "ride2")
+			},
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/rides?limit=2", ""), chair),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[chairGetRidesResponse](t, body)
+				if res.NextCursor != "ride2" || len(res.Rides) != 2 {
+					t.Fatalf("response = %+v, want 2 rides and next_cursor ride2", res)
+				}
+				first, second := res.Rides[0], res.Rides[1]
+				if first.ID != "ride3" || first.Status != "PICKUP" || len(first.Statuses) != 2 || first.Evaluation != nil {
+					t.Errorf("rides[0] = %+v", first)
+				}
+				if first.Fare != calculateSale(rides[0].(Ride)) || first.RequestedAt != base.Add(2*time.Hour).UnixMilli() {
+					t.Errorf("rides[0] fare = %d, requested_at = %d", first.Fare, first.RequestedAt)
+				}
+				if second.ID != "ride2" || second.Status != "COMPLETED" || second.Evaluation == nil || *second.Evaluation != 5 {
+					t.Errorf("rides[1] = %+v", second)
+				}
+			},
+		},
+		{
+			name: "next page",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM rides WHERE chair_id = \? AND id < \? ORDER BY id DESC LIMIT \?`, rides[2]).with(chair.ID, "ride2", chairGetRidesDefaultLimit+1)
+				f.returns(`FROM ride_statuses WHERE ride_id IN \(\?\)`)
+			},
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/rides?cursor=ride2", ""), chair),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[chairGetRidesResponse](t, body)
+				if res.NextCursor != "" || len(res.Rides) != 1 || res.Rides[0].ID != "ride1" || len(res.Rides[0].Statuses) != 0 {
+					t.Errorf("response = %+v, want the last ride without next_cursor", res)
+				}
+			},
+		},
+		{
+			name: "no rides",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM rides WHERE chair_id = \?`)
+			},
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/rides", ""), chair),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				if res := decodeJSON[chairGetRidesResponse](t, body); res.Rides == nil || len(res.Rides) != 0 {
+					t.Errorf("rides = %+v, want an empty list", res.Rides)
+				}
+			},
+		},
+		{
+			name:       "invalid limit",
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/rides?limit=0", ""), chair),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"limit"},
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodGet, "/api/chair/rides", ""),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
+
+func TestChairGetEarnings(t *testing.T) {
+	chair := &Chair{ID: "chair1", OwnerID: "owner1"}
+	day1 := time.Date(2024, 11, 1, 10, 0, 0, 0, time.Local)
+	day2 := time.Date(2024, 11, 2, 9, 0, 0, 0, time.Local)
+	rides := []Ride{
+		{ID: "ride1", DestinationLatitude: 10, CreatedAt: day1, UpdatedAt: day1},
+		{ID: "ride2", DestinationLatitude: 20, CreatedAt: day1, UpdatedAt: day1.Add(time.Hour)},
+		{ID: "ride3", DestinationLongitude: 5, CreatedAt: day2, UpdatedAt: day2},
+	}
+
+	runHandlerTests(t, chairGetEarnings, []handlerTest{
+		{
+			name: "per day",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM rides JOIN ride_statuses .* WHERE chair_id = \? AND status = 'COMPLETED'`, rides[0], rides[1], rides[2])
+			},
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/earnings", ""), chair),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[chairGetEarningsResponse](t, body)
+				sales1 := calculateSale(rides[0]) + calculateSale(rides[1])
+				sales2 := calculateSale(rides[2])
+				if res.TotalRides != 3 || res.TotalSales != sales1+sales2 {
+					t.Errorf("total = %d rides, %d sales, want 3 rides, %d sales", res.TotalRides, res.TotalSales, sales1+sales2)
+				}
+				want := []chairGetEarningsPerDay{
+					{Date: "2024-11-01", Rides: 2, Sales: sales1},
+					{Date: "2024-11-02", Rides: 1, Sales: sales2},
+				}
+				if len(res.Days) != len(want) || res.Days[0] != want[0] || res.Days[1] != want[1] {
+					t.Errorf("days = %+v, want %+v", res.Days, want)
+				}
+			},
+		},
+		{
+			name: "no rides",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM rides JOIN ride_statuses`)
+			},
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/earnings?since=0&until=1000", ""), chair),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				if res := decodeJSON[chairGetEarningsResponse](t, body); res.TotalRides != 0 || res.Days == nil || len(res.Days) != 0 {
+					t.Errorf("response = %+v, want no earnings", res)
+				}
+			},
+		},
+		{
+			name:       "invalid since",
+			req:        asChair(newRequest(http.MethodGet, "/api/chair/earnings?since=yesterday", ""), chair),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"since"},
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodGet, "/api/chair/earnings", ""),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
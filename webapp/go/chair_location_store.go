@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/isuutil"
+)
+
+// chairLocationState は椅子ごとの最新位置と総移動距離
+// write-behind が有効な場合はDBより新しい値を持っているので、こちらを正とする
+type chairLocationState struct {
+	Latest *ChairLocation
+	// HasTotalDistance が false の場合は総移動距離をまだ把握していないので、DBの値を使う
+	HasTotalDistance       bool
+	TotalDistance          int
+	TotalDistanceUpdatedAt time.Time
+}
+
+type chairLocationStore struct {
+	mu     sync.RWMutex
+	states map[string]*chairLocationState
+}
+
+var chairLocations = &chairLocationStore{states: map[string]*chairLocationState{}}
+
+func (s *chairLocationStore) Get(chairID string) (chairLocationState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.states[chairID]
+	if !ok {
+		return chairLocationState{}, false
+	}
+	return *state, true
+}
+
+// SetLatest はDBから読んだ最新位置をキャッシュする
+// すでにより新しい位置を持っている場合は上書きしない
+func (s *chairLocationStore) SetLatest(location *ChairLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[location.ChairID]
+	if !ok {
+		s.states[location.ChairID] = &chairLocationState{Latest: location}
+		return
+	}
+	if state.Latest == nil || state.Latest.CreatedAt.Before(location.CreatedAt) {
+		state.Latest = location
+	}
+}
+
+// Apply は位置情報の投稿結果を反映する
+// 総移動距離を把握していない場合は chair が持っている値を起点にする
+func (s *chairLocationStore) Apply(chair *Chair, latest ChairLocation, movedDistance int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[chair.ID]
+	if !ok {
+		state = &chairLocationState{}
+		s.states[chair.ID] = state
+	}
+	if !state.HasTotalDistance {
+		state.HasTotalDistance = true
+		state.TotalDistance = chair.TotalDistance
+	}
+	state.TotalDistance += movedDistance
+	if latest.CreatedAt.After(state.TotalDistanceUpdatedAt) {
+		state.TotalDistanceUpdatedAt = latest.CreatedAt
+	}
+	if state.Latest == nil || !latest.CreatedAt.Before(state.Latest.CreatedAt) {
+		state.Latest = &latest
+	}
+}
+
+func (s *chairLocationStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states = map[string]*chairLocationState{}
+}
+
+type chairLocationWrite struct {
+	Location      ChairLocation
+	MovedDistance int
+}
+
+// chairLocationWriter が nil の場合は位置情報を同期的に書き込む
+var chairLocationWriter *isuutil.Worker[chairLocationWrite]
+
+// startChairLocationWriter は chair_locations の write-behind を有効にする
+// chair_location.write_behind_interval が書き込みの間隔で、プロセスが落ちた場合に失う位置情報の上限にもなる
+func startChairLocationWriter() {
+	interval := time.Duration(appConfig.ChairLocation.WriteBehindInterval)
+	if interval == 0 {
+		return
+	}
+
+	chairLocationWriter = isuutil.NewWorker[chairLocationWrite](interval)
+	go chairLocationWriter.Run(func(items []chairLocationWrite) {
+		if err := flushChairLocationsWithRetry(context.Background(), items); err != nil {
+			slog.Error("failed to flush chair_locations", "error", err, "dropped", len(items))
+		}
+	})
+}
+
+// flushPendingChairLocations は write-behind で溜まっている位置情報をすべて書き出す
+func flushPendingChairLocations() {
+	if chairLocationWriter == nil {
+		return
+	}
+	chairLocationWriter.Flush()
+}
+
+const chairLocationFlushChunkSize = 1000
+
+// chairLocationFlushRetryDelays の間隔で書き出しを再試行し、すべて失敗したときだけ捨てる
+// DBが一時的に使えなくても、失う位置情報は書き込み間隔と再試行の時間ぶんまでに収まる
+var chairLocationFlushRetryDelays = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second}
+
+// flushChairLocationsWithRetry は失敗した書き出しをやり直す。失敗したトランザクションはロールバックされるので同じ items で再実行できる
+func flushChairLocationsWithRetry(ctx context.Context, items []chairLocationWrite) error {
+	err := flushChairLocations(ctx, items)
+	for _, delay := range chairLocationFlushRetryDelays {
+		if err == nil {
+			return nil
+		}
+		slog.Warn("failed to flush chair_locations, retrying", "error", err, "retry_after", delay)
+		time.Sleep(delay)
+		err = flushChairLocations(ctx, items)
+	}
+	return err
+}
+
+func flushChairLocations(ctx context.Context, items []chairLocationWrite) error {
+	type distanceDelta struct {
+		distance  int
+		updatedAt time.Time
+	}
+	deltas := map[string]*distanceDelta{}
+	locations := make([]ChairLocation, 0, len(items))
+	for _, item := range items {
+		locations = append(locations, item.Location)
+		d, ok := deltas[item.Location.ChairID]
+		if !ok {
+			d = &distanceDelta{}
+			deltas[item.Location.ChairID] = d
+		}
+		d.distance += item.MovedDistance
+		if d.updatedAt.Before(item.Location.CreatedAt) {
+			d.updatedAt = item.Location.CreatedAt
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < len(locations); i += chairLocationFlushChunkSize {
+		chunk := locations[i:min(i+chairLocationFlushChunkSize, len(locations))]
+		if _, err := tx.NamedExecContext(
+			ctx,
+			`INSERT INTO chair_locations (id, chair_id, latitude, longitude, created_at) VALUES (:id, :chair_id, :latitude, :longitude, :created_at)`,
+			chunk,
+		); err != nil {
+			return err
+		}
+	}
+
+	// 書き込みが並行することがあるので、総移動距離は加算で、更新日時は新しい方を残す
+	for chairID, d := range deltas {
+		if _, err := tx.ExecContext(
+			ctx,
+			`UPDATE chairs
+			SET
+				total_distance = total_distance + ?,
+				total_distance_updated_at = GREATEST(COALESCE(total_distance_updated_at, ?), ?)
+			WHERE id = ?`,
+			d.distance, d.updatedAt, d.updatedAt, chairID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getChairTotalDistance は総移動距離を返す。まだDBに書き出していない分も含む
+func getChairTotalDistance(chair *Chair) (int, sql.NullTime) {
+	if state, ok := chairLocations.Get(chair.ID); ok && state.HasTotalDistance {
+		return state.TotalDistance, sql.NullTime{Time: state.TotalDistanceUpdatedAt, Valid: true}
+	}
+	return chair.TotalDistance, chair.TotalDistanceUpdatedAt
+}
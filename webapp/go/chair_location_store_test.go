@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFlushChairLocationsWithRetry(t *testing.T) {
+	saved := chairLocationFlushRetryDelays
+	defer func() { chairLocationFlushRetryDelays = saved }()
+	chairLocationFlushRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+
+	items := []chairLocationWrite{{
+		Location:      ChairLocation{ID: "loc1", ChairID: "chair1", Latitude: 1, Longitude: 2, CreatedAt: time.Now()},
+		MovedDistance: 3,
+	}}
+
+	t.Run("retried", func(t *testing.T) {
+		f := newFakeDB(t)
+		// 1回目の書き出しは失敗し、2回目で成功する
+		f.fail(`^INSERT INTO chair_locations`, errors.New("connection refused")).once()
+		f.exec(`^INSERT INTO chair_locations`, 1)
+		f.exec(`UPDATE chairs`, 1)
+
+		if err := flushChairLocationsWithRetry(context.Background(), items); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(f.executed(`^INSERT INTO chair_locations`)); got != 2 {
+			t.Errorf("INSERT executed %d times, want 2", got)
+		}
+		if got := len(f.executed(`UPDATE chairs`)); got != 1 {
+			t.Errorf("UPDATE executed %d times, want 1", got)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		f := newFakeDB(t)
+		f.fail(`^INSERT INTO chair_locations`, errors.New("connection refused"))
+
+		if err := flushChairLocationsWithRetry(context.Background(), items); err == nil {
+			t.Fatal("flushChairLocationsWithRetry() should fail when every attempt fails")
+		}
+		if got := len(f.executed(`^INSERT INTO chair_locations`)); got != 3 {
+			t.Errorf("INSERT executed %d times, want 3", got)
+		}
+	})
+}
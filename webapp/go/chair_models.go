@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+var (
+	errUnknownChairModel = errors.New("unknown chair model")
+	errRetiredChairModel = errors.New("chair model has been retired")
+)
+
+// getChairModel は椅子モデルを取得する
+// 速度の変更が即座にマッチングやETAに反映されるよう、キャッシュせずに毎回DBから引く
+func getChairModel(ctx context.Context, tx executableGet, name string) (*ChairModel, error) {
+	model := &ChairModel{}
+	if err := tx.GetContext(ctx, model, "SELECT * FROM chair_models WHERE name = ?", name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errUnknownChairModel
+		}
+		return nil, err
+	}
+	return model, nil
+}
+
+// validateChairModel は新しく椅子に設定できるモデルかどうかを検証する
+// 廃止済みのモデルは既存の椅子では引き続き使えるが、新規登録や変更先には指定できない
+func validateChairModel(ctx context.Context, tx executableGet, name string) error {
+	model, err := getChairModel(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if model.RetiredAt != nil {
+		return errRetiredChairModel
+	}
+	return nil
+}
+
+type getChairModelsResponse struct {
+	Models []getChairModelsResponseModel `json:"models"`
+}
+
+type getChairModelsResponseModel struct {
+	Name  string `json:"name"`
+	Speed int    `json:"speed"`
+}
+
+func getChairModels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	models := []ChairModel{}
+	if err := db.SelectContext(ctx, &models, "SELECT * FROM chair_models WHERE retired_at IS NULL ORDER BY name"); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := getChairModelsResponse{Models: []getChairModelsResponseModel{}}
+	for _, model := range models {
+		res.Models = append(res.Models, getChairModelsResponseModel{
+			Name:  model.Name,
+			Speed: model.Speed,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetChairModels(t *testing.T) {
+	runHandlerTests(t, getChairModels, []handlerTest{
+		{
+			name: "active models",
+			setup: func(f *fakeDB) {
+				// 廃止済みのモデルはクエリで除く
+				f.returns(`FROM chair_models WHERE retired_at IS NULL`, ChairModel{Name: "a", Speed: 2}, ChairModel{Name: "b", Speed: 5})
+			},
+			req:        newRequest(http.MethodGet, "/api/chair-models", ""),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[getChairModelsResponse](t, body)
+				if len(res.Models) != 2 || res.Models[1].Name != "b" || res.Models[1].Speed != 5 {
+					t.Errorf("models = %+v", res.Models)
+				}
+			},
+		},
+		{
+			name: "empty",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chair_models WHERE retired_at IS NULL`)
+			},
+			req:        newRequest(http.MethodGet, "/api/chair-models", ""),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				if res := decodeJSON[getChairModelsResponse](t, body); res.Models == nil || len(res.Models) != 0 {
+					t.Errorf("models = %+v, want an empty list", res.Models)
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/isuutil"
+	"github.com/isucon/isucon14/webapp/go/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// config は起動時に読み込む設定。デフォルト < YAML ファイル < 環境変数 < フラグ の順に上書きする
+// 各項目の yaml, json タグがファイルと /api/internal/config での名前、env が環境変数名、flag がフラグ名になる
+// secret タグのある項目は /api/internal/config で伏せる
+type config struct {
+	Server   serverConfig   `yaml:"server" json:"server"`
+	DB       dbConfig       `yaml:"db" json:"db"`
+	Pprotein pproteinConfig `yaml:"pprotein" json:"pprotein"`
+	Trace    traceConfig    `yaml:"trace" json:"trace"`
+	Admin    adminConfig    `yaml:"admin" json:"admin"`
+
+	Ride          rideConfig          `yaml:"ride" json:"ride"`
+	Session       sessionConfig       `yaml:"session" json:"session"`
+	TokenCache    tokenCacheConfig    `yaml:"token_cache" json:"token_cache"`
+	RateLimit     rateLimitConfig     `yaml:"rate_limit" json:"rate_limit"`
+	ChairLocation chairLocationConfig `yaml:"chair_location" json:"chair_location"`
+	Shutdown      shutdownConfig      `yaml:"shutdown" json:"shutdown"`
+}
+
+type serverConfig struct {
+	Addr string `yaml:"addr" json:"addr" env:"ISUCON_ADDR" flag:"addr" validate:"required"`
+}
+
+type dbConfig struct {
+	Host            string         `yaml:"host" json:"host" env:"ISUCON_DB_HOST" flag:"db-host" validate:"required"`
+	Port            int            `yaml:"port" json:"port" env:"ISUCON_DB_PORT" flag:"db-port" validate:"min=1,max=65535"`
+	User            string         `yaml:"user" json:"user" env:"ISUCON_DB_USER" flag:"db-user" validate:"required"`
+	Password        string         `yaml:"password" json:"password" env:"ISUCON_DB_PASSWORD" secret:"true"`
+	Name            string         `yaml:"name" json:"name" env:"ISUCON_DB_NAME" flag:"db-name" validate:"required"`
+	MaxOpenConns    int            `yaml:"max_open_conns" json:"max_open_conns" env:"ISUCON_DB_MAX_OPEN_CONNS" flag:"db-max-open-conns" validate:"min=0"`
+	MaxIdleConns    int            `yaml:"max_idle_conns" json:"max_idle_conns" env:"ISUCON_DB_MAX_IDLE_CONNS" flag:"db-max-idle-conns" validate:"min=0"`
+	ConnMaxLifetime configDuration `yaml:"conn_max_lifetime" json:"conn_max_lifetime" env:"ISUCON_DB_CONN_MAX_LIFETIME" flag:"db-conn-max-lifetime" validate:"min=0"`
+}
+
+type pproteinConfig struct {
+	// AgentAddr は pprotein がプロファイルを取りに来るアドレス。空にすると起動しない
+	AgentAddr string `yaml:"agent_addr" json:"agent_addr" env:"ISUCON_PPROTEIN_AGENT_ADDR" flag:"pprotein-agent-addr"`
+	// CollectURL は POST /api/initialize で叩く Collect API。空にすると叩かない
+	CollectURL string `yaml:"collect_url" json:"collect_url" env:"ISUCON_PPROTEIN_COLLECT_URL" flag:"pprotein-collect-url"`
+}
+
+type traceConfig struct {
+	Enabled       bool    `yaml:"enabled" json:"enabled" env:"ISUCON_TRACE_ENABLED" flag:"trace"`
+	Endpoint      string  `yaml:"endpoint" json:"endpoint" env:"ISUCON_TRACE_ENDPOINT" flag:"trace-endpoint" validate:"required"`
+	SamplingRatio float64 `yaml:"sampling_ratio" json:"sampling_ratio" env:"ISUCON_TRACE_SAMPLING_RATIO" flag:"trace-sampling-ratio" validate:"min=0,max=1"`
+	ServiceName   string  `yaml:"service_name" json:"service_name" env:"ISUCON_TRACE_SERVICE_NAME" flag:"trace-service-name" validate:"required"`
+}
+
+type adminConfig struct {
+	// Token を Authorization: Bearer で渡した場合のみ管理APIを使える。空にすると管理APIを無効にする
+	Token string `yaml:"token" json:"token" env:"ISUCON_ADMIN_TOKEN" secret:"true"`
+}
+
+type rideConfig struct {
+	// ArrivalRadius 以内(マンハッタン距離)に近づいたら配車位置・目的地に到着したとみなす。0 の場合は座標がぴったり一致したときだけ
+	ArrivalRadius int `yaml:"arrival_radius" json:"arrival_radius" env:"ISUCON_ARRIVAL_RADIUS" validate:"min=0"`
+	// ChairMoveInterval ごとに椅子が速度ぶん移動するものとして到着予定を見積もる
+	ChairMoveInterval configDuration `yaml:"chair_move_interval" json:"chair_move_interval" env:"ISUCON_CHAIR_MOVE_INTERVAL" validate:"min=1"`
+	// LowRatedUserEvaluation 未満の平均評価のユーザーはマッチングで後回しにする
+	LowRatedUserEvaluation float64 `yaml:"low_rated_user_evaluation" json:"low_rated_user_evaluation" env:"ISUCON_LOW_RATED_USER_EVALUATION" validate:"min=0,max=5"`
+	// ReservationLeadTime だけ予約時刻より前にライドを作成してマッチングに回す
+	ReservationLeadTime configDuration `yaml:"reservation_lead_time" json:"reservation_lead_time" env:"ISUCON_RIDE_RESERVATION_LEAD_TIME" validate:"min=0"`
+	ReservationInterval configDuration `yaml:"reservation_interval" json:"reservation_interval" env:"ISUCON_RIDE_RESERVATION_INTERVAL" validate:"min=1"`
+	// AutoCompleteAfter だけ ARRIVED のままのライドを評価なしで完了して決済する
+	AutoCompleteAfter    configDuration `yaml:"auto_complete_after" json:"auto_complete_after" env:"ISUCON_RIDE_AUTO_COMPLETE_AFTER" validate:"min=1"`
+	AutoCompleteInterval configDuration `yaml:"auto_complete_interval" json:"auto_complete_interval" env:"ISUCON_RIDE_AUTO_COMPLETE_INTERVAL" validate:"min=1"`
+	// EvaluationWindow は完了したライドを後から評価できる期間
+	EvaluationWindow configDuration `yaml:"evaluation_window" json:"evaluation_window" env:"ISUCON_RIDE_EVALUATION_WINDOW" validate:"min=0"`
+}
+
+type sessionConfig struct {
+	// TTL は最後に使われてからセッションが切れるまでの時間
+	TTL configDuration `yaml:"ttl" json:"ttl" env:"ISUCON_SESSION_TTL" validate:"min=1"`
+	// TouchInterval ごとに last_seen_at と expires_at をDBに書き込む。それまではメモリ上でだけ延長する
+	TouchInterval  configDuration `yaml:"touch_interval" json:"touch_interval" env:"ISUCON_SESSION_TOUCH_INTERVAL" validate:"min=0"`
+	CookieSecure   bool           `yaml:"cookie_secure" json:"cookie_secure" env:"ISUCON_COOKIE_SECURE"`
+	CookieSameSite string         `yaml:"cookie_samesite" json:"cookie_samesite" env:"ISUCON_COOKIE_SAMESITE" validate:"required,oneof=lax strict none"`
+}
+
+type tokenCacheConfig struct {
+	// NegativeTTL だけ、DBに存在しなかったトークンを覚えておく
+	NegativeTTL configDuration `yaml:"negative_ttl" json:"negative_ttl" env:"ISUCON_TOKEN_CACHE_NEGATIVE_TTL" validate:"min=0"`
+	// Peers は同じDBを使う他のサーバー(カンマ区切り)。キャッシュを更新したら無効化を通知する
+	Peers string `yaml:"peers" json:"peers" env:"ISUCON_TOKEN_CACHE_PEERS"`
+	// InvalidationToken は無効化の通知に Authorization: Bearer で付ける共有トークン。Peers を設定する場合は必須
+	InvalidationToken string `yaml:"invalidation_token" json:"invalidation_token" env:"ISUCON_TOKEN_CACHE_INVALIDATION_TOKEN" secret:"true"`
+}
+
+type rateLimitConfig struct {
+	// Limits は "chair=50:100;POST /api/chair/coordinate=20:40" の形式で、デフォルトの制限を上書きする
+	Limits string `yaml:"limits" json:"limits" env:"ISUCON_RATE_LIMITS"`
+	// Server はバケットを持つサーバー。空ならバケットを共有しない
+	Server string `yaml:"server" json:"server" env:"ISUCON_RATE_LIMIT_SERVER"`
+	// Token はサーバー間のリクエストに Authorization: Bearer で付ける共有トークン。Server を設定する場合は必須
+	Token string `yaml:"token" json:"token" env:"ISUCON_RATE_LIMIT_TOKEN" secret:"true"`
+}
+
+type chairLocationConfig struct {
+	// Retention より古い位置情報を間引く。0 の場合は間引かない
+	Retention configDuration `yaml:"retention" json:"retention" env:"ISUCON_CHAIR_LOCATION_RETENTION" validate:"min=0"`
+	// RetentionMode は downsample(間引いて削除) か archive(アーカイブテーブルへ移動)
+	RetentionMode string `yaml:"retention_mode" json:"retention_mode" env:"ISUCON_CHAIR_LOCATION_RETENTION_MODE" validate:"required,oneof=downsample archive"`
+	// DownsampleInterval ごとに椅子1台あたり1点だけ残す。1秒以上
+	DownsampleInterval configDuration `yaml:"downsample_interval" json:"downsample_interval" env:"ISUCON_CHAIR_LOCATION_DOWNSAMPLE_INTERVAL" validate:"min=1000000000"`
+	RetentionInterval  configDuration `yaml:"retention_interval" json:"retention_interval" env:"ISUCON_CHAIR_LOCATION_RETENTION_INTERVAL" validate:"min=1"`
+	// WriteBehindInterval ごとにまとめて書き込む。0 の場合は同期的に書き込む
+	// プロセスが落ちた場合に失う位置情報の上限にもなる
+	WriteBehindInterval configDuration `yaml:"write_behind_interval" json:"write_behind_interval" env:"ISUCON_CHAIR_LOCATION_WRITE_BEHIND_INTERVAL" validate:"min=0"`
+	// FlushOnShutdown が true の場合、シャットダウン時に溜まっている位置情報を書き出してから終了する
+	FlushOnShutdown bool `yaml:"flush_on_shutdown" json:"flush_on_shutdown" env:"ISUCON_CHAIR_LOCATION_FLUSH_ON_SHUTDOWN"`
+}
+
+type shutdownConfig struct {
+	// DrainDelay はシャットダウン開始から新しい接続を受け付けなくなるまでの時間
+	// この間 /readyz は 503 を返すので、その間にロードバランサーに外してもらう
+	DrainDelay configDuration `yaml:"drain_delay" json:"drain_delay" env:"ISUCON_SHUTDOWN_DRAIN_DELAY" validate:"min=0"`
+	// Timeout は処理中のリクエストとジョブを待つ時間の上限
+	Timeout configDuration `yaml:"timeout" json:"timeout" env:"ISUCON_SHUTDOWN_TIMEOUT" validate:"min=1"`
+}
+
+// configDuration は "5m" のような文字列で読み書きする time.Duration
+type configDuration time.Duration
+
+func (d configDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *configDuration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = configDuration(v)
+	return nil
+}
+
+func defaultConfig() config {
+	return config{
+		Server: serverConfig{Addr: ":8080"},
+		DB: dbConfig{
+			Host:            "127.0.0.1",
+			Port:            3306,
+			User:            "isucon",
+			Password:        "isucon",
+			Name:            "isuride",
+			MaxOpenConns:    isuutil.DefaultDBPoolConfig.MaxOpenConns,
+			MaxIdleConns:    isuutil.DefaultDBPoolConfig.MaxIdleConns,
+			ConnMaxLifetime: configDuration(isuutil.DefaultDBPoolConfig.ConnMaxLifetime),
+		},
+		Pprotein: pproteinConfig{
+			AgentAddr:  ":19001",
+			CollectURL: isuutil.DefaultPproteinCollectEndpoint,
+		},
+		Trace: traceConfig{
+			Endpoint:      isuutil.DefaultTraceConfig.Endpoint,
+			SamplingRatio: isuutil.DefaultTraceConfig.SamplingRatio,
+			ServiceName:   isuutil.DefaultTraceConfig.ServiceName,
+		},
+		Ride: rideConfig{
+			ChairMoveInterval:      configDuration(time.Second),
+			LowRatedUserEvaluation: 2.0,
+			ReservationLeadTime:    configDuration(10 * time.Minute),
+			ReservationInterval:    configDuration(time.Second),
+			AutoCompleteAfter:      configDuration(5 * time.Minute),
+			AutoCompleteInterval:   configDuration(time.Second),
+			EvaluationWindow:       configDuration(24 * time.Hour),
+		},
+		Session: sessionConfig{
+			TTL:            configDuration(24 * time.Hour),
+			TouchInterval:  configDuration(time.Minute),
+			CookieSecure:   true,
+			CookieSameSite: "lax",
+		},
+		TokenCache: tokenCacheConfig{
+			NegativeTTL: configDuration(time.Second),
+		},
+		ChairLocation: chairLocationConfig{
+			RetentionMode:      chairLocationRetentionModeDownsample,
+			DownsampleInterval: configDuration(time.Minute),
+			RetentionInterval:  configDuration(time.Minute),
+			FlushOnShutdown:    true,
+		},
+		Shutdown: shutdownConfig{
+			Timeout: configDuration(30 * time.Second),
+		},
+	}
+}
+
+var appConfig = defaultConfig()
+
+// loadConfig は args のフラグと getenv の環境変数から設定を読み込んで検証する。フラグの後に残った引数も返す
+// 設定ファイルは -config か ISUCON_CONFIG_FILE で指定する
+func loadConfig(name string, args []string, getenv func(string) string) (config, []string, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", getenv("ISUCON_CONFIG_FILE"), "YAML config file (env ISUCON_CONFIG_FILE)")
+	type flagValue struct {
+		field reflect.Value
+		value string
+	}
+	flagValues := []flagValue{}
+	walkConfig(reflect.ValueOf(&cfg).Elem(), "", func(field reflect.StructField, v reflect.Value, path string) {
+		name := field.Tag.Get("flag")
+		if name == "" {
+			return
+		}
+		usage := path
+		if env := field.Tag.Get("env"); env != "" {
+			usage += fmt.Sprintf(" (env %s)", env)
+		}
+		set := func(s string) error {
+			flagValues = append(flagValues, flagValue{field: v, value: s})
+			return nil
+		}
+		if v.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage, set)
+		} else {
+			fs.Func(name, usage, set)
+		}
+	})
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *path != "" {
+		if err := loadConfigFile(*path, &cfg); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	var errs []error
+	walkConfig(reflect.ValueOf(&cfg).Elem(), "", func(field reflect.StructField, v reflect.Value, path string) {
+		env := field.Tag.Get("env")
+		if env == "" {
+			return
+		}
+		if s := getenv(env); s != "" {
+			if err := setConfigValue(v, s); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %s: %w", env, s, err))
+			}
+		}
+	})
+	// フラグの値は設定ファイルと環境変数を読んだ後で反映する
+	for _, fv := range flagValues {
+		if err := setConfigValue(fv.field, fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid flag value %s: %w", fv.value, err))
+		}
+	}
+	if len(errs) > 0 {
+		return cfg, nil, errors.Join(errs...)
+	}
+
+	if verr := validation.Struct(&cfg); verr != nil {
+		return cfg, nil, fmt.Errorf("invalid config: %w", verr)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, fs.Args(), nil
+}
+
+// validate は validate タグで書けない、複数の項目にまたがる検証をする
+func (c config) validate() error {
+	var errs []error
+	if c.TokenCache.Peers != "" && c.TokenCache.InvalidationToken == "" {
+		errs = append(errs, errors.New("token_cache.invalidation_token is required when token_cache.peers is set"))
+	}
+	if c.RateLimit.Server != "" && c.RateLimit.Token == "" {
+		errs = append(errs, errors.New("rate_limit.token is required when rate_limit.server is set"))
+	}
+	if _, err := c.RateLimit.rules(); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.limits: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func loadConfigFile(path string, cfg *config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// walkConfig は設定の構造体でない項目ごとに fn を呼ぶ。path は yaml タグをドットでつないだもの
+func walkConfig(v reflect.Value, path string, fn func(field reflect.StructField, v reflect.Value, path string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := field.Tag.Get("yaml")
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		if field.Type.Kind() == reflect.Struct {
+			walkConfig(v.Field(i), fieldPath, fn)
+			continue
+		}
+		fn(field, v.Field(i), fieldPath)
+	}
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %s", v.Type()))
+	}
+	return nil
+}
+
+// redacted は secret タグのある項目を伏せたコピーを返す。未設定の項目は未設定とわかるよう空のままにする
+func (c config) redacted() config {
+	walkConfig(reflect.ValueOf(&c).Elem(), "", func(field reflect.StructField, v reflect.Value, path string) {
+		if field.Tag.Get("secret") == "true" && v.String() != "" {
+			v.SetString("[REDACTED]")
+		}
+	})
+	return c
+}
+
+func (c config) dbPoolConfig() isuutil.DBPoolConfig {
+	return isuutil.DBPoolConfig{
+		MaxOpenConns:    c.DB.MaxOpenConns,
+		MaxIdleConns:    c.DB.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(c.DB.ConnMaxLifetime),
+	}
+}
+
+func (c config) traceConfig() isuutil.TraceConfig {
+	return isuutil.TraceConfig{
+		Endpoint:      c.Trace.Endpoint,
+		SamplingRatio: c.Trace.SamplingRatio,
+		ServiceName:   c.Trace.ServiceName,
+	}
+}
+
+func (c sessionConfig) sameSite() http.SameSite {
+	switch c.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// peers は Peers を末尾の / を除いたURLのリストにする
+func (c tokenCacheConfig) peers() []string {
+	peers := []string{}
+	for _, peer := range strings.Split(c.Peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, strings.TrimSuffix(peer, "/"))
+		}
+	}
+	return peers
+}
+
+func internalGetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, appConfig.redacted())
+}
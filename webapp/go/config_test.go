@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+server:
+  addr: ":8000"
+db:
+  host: db.internal
+  port: 3307
+  conn_max_lifetime: 1m
+trace:
+  sampling_ratio: 0.5
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"ISUCON_CONFIG_FILE": path,
+		"ISUCON_DB_HOST":     "db.env",
+		"ISUCON_DB_PASSWORD": "secret",
+		"ISUCON_DB_PORT":     "3308",
+
+		"ISUCON_SESSION_TTL":                    "1h",
+		"ISUCON_TOKEN_CACHE_PEERS":              "http://192.168.0.12:8080/, http://192.168.0.13:8080",
+		"ISUCON_TOKEN_CACHE_INVALIDATION_TOKEN": "secret",
+	}
+
+	cfg, rest, err := loadConfig("isuride", []string{"-db-port", "3309", "-trace", "migrate", "status"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 || rest[0] != "migrate" {
+		t.Errorf("rest = %v, want subcommand", rest)
+	}
+	// デフォルト < ファイル < 環境変数 < フラグ
+	if cfg.Server.Addr != ":8000" {
+		t.Errorf("Server.Addr = %q, want file value", cfg.Server.Addr)
+	}
+	if cfg.DB.Host != "db.env" {
+		t.Errorf("DB.Host = %q, want env value", cfg.DB.Host)
+	}
+	if cfg.DB.Port != 3309 {
+		t.Errorf("DB.Port = %d, want flag value", cfg.DB.Port)
+	}
+	if cfg.DB.User != "isucon" {
+		t.Errorf("DB.User = %q, want default", cfg.DB.User)
+	}
+	if time.Duration(cfg.DB.ConnMaxLifetime) != time.Minute || cfg.Trace.SamplingRatio != 0.5 || !cfg.Trace.Enabled {
+		t.Errorf("cfg = %+v", cfg)
+	}
+	if time.Duration(cfg.Session.TTL) != time.Hour || cfg.Session.sameSite() != http.SameSiteLaxMode {
+		t.Errorf("Session = %+v", cfg.Session)
+	}
+	if peers := cfg.TokenCache.peers(); len(peers) != 2 || peers[0] != "http://192.168.0.12:8080" {
+		t.Errorf("peers() = %v", peers)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "invalid env", env: map[string]string{"ISUCON_DB_PORT": "abc"}, want: "ISUCON_DB_PORT"},
+		{name: "out of range", args: []string{"-db-port", "0"}, want: "db.port"},
+		{name: "out of range ratio", env: map[string]string{"ISUCON_TRACE_SAMPLING_RATIO": "2"}, want: "trace.sampling_ratio"},
+		{name: "unknown flag", args: []string{"-unknown"}, want: "unknown"},
+		{name: "missing file", args: []string{"-config", "/nonexistent.yaml"}, want: "config file"},
+		{name: "invalid duration", env: map[string]string{"ISUCON_SESSION_TTL": "1d"}, want: "ISUCON_SESSION_TTL"},
+		{name: "zero interval", env: map[string]string{"ISUCON_RIDE_AUTO_COMPLETE_INTERVAL": "0s"}, want: "ride.auto_complete_interval"},
+		{name: "unknown samesite", env: map[string]string{"ISUCON_COOKIE_SAMESITE": "loose"}, want: "session.cookie_samesite"},
+		{name: "peers without token", env: map[string]string{"ISUCON_TOKEN_CACHE_PEERS": "http://192.168.0.12:8080"}, want: "token_cache.invalidation_token"},
+		{name: "rate limit server without token", env: map[string]string{"ISUCON_RATE_LIMIT_SERVER": "http://192.168.0.11:8080"}, want: "rate_limit.token"},
+		{name: "invalid rate limits", env: map[string]string{"ISUCON_RATE_LIMITS": "chair=10"}, want: "rate_limit.limits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadConfig("isuride", tt.args, func(k string) string { return tt.env[k] })
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("db:\n  hots: typo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfig("isuride", []string{"-config", path}, func(string) string { return "" }); err == nil {
+		t.Error("loadConfig() should fail for unknown field")
+	}
+}
+
+func TestInternalGetConfigRedactsSecrets(t *testing.T) {
+	saved := appConfig
+	defer func() { appConfig = saved }()
+	appConfig = defaultConfig()
+	appConfig.DB.Password = "db-password"
+	appConfig.Admin.Token = "admin-token"
+	appConfig.TokenCache.InvalidationToken = "invalidation-token"
+	appConfig.RateLimit.Token = "rate-limit-token"
+
+	rec := httptest.NewRecorder()
+	internalGetConfig(rec, httptest.NewRequest(http.MethodGet, "/api/internal/config", nil))
+	for _, secret := range []string{"db-password", "admin-token", "invalidation-token", "rate-limit-token"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Fatalf("secrets are not redacted: %s", rec.Body.String())
+		}
+	}
+	res := config{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.DB.Password != "[REDACTED]" || res.DB.Host != appConfig.DB.Host || res.DB.ConnMaxLifetime != appConfig.DB.ConnMaxLifetime {
+		t.Errorf("config = %+v", res)
+	}
+	if res.RateLimit.Token != "[REDACTED]" || res.Session.TTL != appConfig.Session.TTL {
+		t.Errorf("config = %+v", res)
+	}
+	if appConfig.DB.Password != "db-password" {
+		t.Error("redacted() must not modify the original config")
+	}
+}
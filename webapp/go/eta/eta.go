@@ -0,0 +1,53 @@
+// Package eta は椅子の到着予定時刻を見積もる。
+// 距離の計算方法には依存せず、距離(マス)と椅子モデルの速度から所要時間を求める。
+package eta
+
+import (
+	"time"
+)
+
+// Calculator は椅子の所要時間を見積もる。
+// 椅子は MoveInterval ごとに最大で速度ぶんのマスを移動するものとして計算する。
+type Calculator struct {
+	MoveInterval time.Duration
+}
+
+func NewCalculator(moveInterval time.Duration) *Calculator {
+	return &Calculator{MoveInterval: moveInterval}
+}
+
+// Duration は distance マスを speed の椅子で移動するのにかかる時間を返します。
+// speed が0以下の場合は移動できないので ok=false を返します。
+func (c *Calculator) Duration(distance, speed int) (time.Duration, bool) {
+	if speed <= 0 {
+		return 0, false
+	}
+	if distance <= 0 {
+		return 0, true
+	}
+	// 端数のマスも1回の移動が必要なので切り上げる
+	moves := (distance + speed - 1) / speed
+	return time.Duration(moves) * c.MoveInterval, true
+}
+
+// Estimate はライドの到着予定を表します。
+type Estimate struct {
+	// Pickup は現在から配車位置に着くまでの時間
+	Pickup time.Duration
+	// Trip は現在から目的地に着くまでの時間
+	Trip time.Duration
+}
+
+// Estimate は椅子が配車位置まで toPickup マス、配車位置から目的地まで toDestination マス移動する場合の到着予定を返します。
+// すでに配車位置を出発している場合は toPickup を0にし、toDestination に現在地から目的地までの距離を渡してください。
+func (c *Calculator) Estimate(toPickup, toDestination, speed int) (Estimate, bool) {
+	pickup, ok := c.Duration(toPickup, speed)
+	if !ok {
+		return Estimate{}, false
+	}
+	trip, ok := c.Duration(toDestination, speed)
+	if !ok {
+		return Estimate{}, false
+	}
+	return Estimate{Pickup: pickup, Trip: pickup + trip}, true
+}
@@ -0,0 +1,35 @@
+package eta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculator_Estimate(t *testing.T) {
+	c := NewCalculator(time.Second)
+
+	tests := []struct {
+		name          string
+		toPickup      int
+		toDestination int
+		speed         int
+		want          Estimate
+		wantOK        bool
+	}{
+		{name: "divisible", toPickup: 10, toDestination: 20, speed: 5, want: Estimate{Pickup: 2 * time.Second, Trip: 6 * time.Second}, wantOK: true},
+		{name: "rounds up partial moves", toPickup: 3, toDestination: 4, speed: 2, want: Estimate{Pickup: 2 * time.Second, Trip: 4 * time.Second}, wantOK: true},
+		{name: "already picked up", toPickup: 0, toDestination: 6, speed: 3, want: Estimate{Pickup: 0, Trip: 2 * time.Second}, wantOK: true},
+		{name: "invalid speed", toPickup: 1, toDestination: 1, speed: 0, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.Estimate(tt.toPickup, tt.toDestination, tt.speed)
+			if ok != tt.wantOK {
+				t.Fatalf("Estimate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("Estimate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
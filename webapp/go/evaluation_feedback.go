@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// evaluationTags は評価につけられるタグの一覧
+var evaluationTags = map[string]bool{
+	"clean":       true,
+	"punctual":    true,
+	"comfortable": true,
+	"friendly":    true,
+	"safe":        true,
+	"quiet":       true,
+}
+
+const evaluationCommentMaxLength = 200
+
+// evaluationCommentBannedWords を含むコメントは受け付けない
+var evaluationCommentBannedWords = []string{
+	"fuck",
+	"shit",
+	"死ね",
+	"殺す",
+}
+
+// normalizeEvaluationComment はコメントの前後の空白を取り除き、長さと内容を検証する
+func normalizeEvaluationComment(comment string) (string, error) {
+	comment = strings.TrimSpace(comment)
+	if utf8.RuneCountInString(comment) > evaluationCommentMaxLength {
+		return "", newFieldError("comment", "max", fmt.Sprintf("comment must be at most %d characters", evaluationCommentMaxLength))
+	}
+	for _, r := range comment {
+		if unicode.IsControl(r) && r != '\n' {
+			return "", newFieldError("comment", "printable", "comment must not contain control characters")
+		}
+	}
+	lower := strings.ToLower(comment)
+	if strings.Contains(lower, "http://") || strings.Contains(lower, "https://") {
+		return "", newFieldError("comment", "no_url", "comment must not contain URLs")
+	}
+	for _, word := range evaluationCommentBannedWords {
+		if strings.Contains(lower, word) {
+			return "", newFieldError("comment", "banned_words", "comment contains inappropriate words")
+		}
+	}
+	return comment, nil
+}
+
+func validateEvaluationTags(tags []string) error {
+	seen := map[string]bool{}
+	for i, tag := range tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		if !evaluationTags[tag] {
+			return newFieldError(field, "oneof", fmt.Sprintf("unknown tag %q", tag))
+		}
+		if seen[tag] {
+			return newFieldError(field, "unique", fmt.Sprintf("duplicated tag %q", tag))
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+// saveEvaluationFeedback はライドの評価コメントとタグを保存する。すでにあれば置き換える
+func saveEvaluationFeedback(ctx context.Context, tx *sqlx.Tx, rideID, comment string, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ride_evaluation_comments WHERE ride_id = ?`, rideID); err != nil {
+		return err
+	}
+	if comment != "" {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO ride_evaluation_comments (ride_id, comment) VALUES (?, ?)`, rideID, comment); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ride_evaluation_tags WHERE ride_id = ?`, rideID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO ride_evaluation_tags (ride_id, tag) VALUES (?, ?)`, rideID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getEvaluationTagCounts はオーナーの椅子ごとに、評価でつけられたタグの件数を返す
+func getEvaluationTagCounts(ctx context.Context, ownerID string) (map[string]map[string]int, error) {
+	rows := []struct {
+		ChairID string `db:"chair_id"`
+		Tag     string `db:"tag"`
+		Count   int    `db:"count"`
+	}{}
+	if err := db.SelectContext(
+		ctx,
+		&rows,
+		`SELECT rides.chair_id, ride_evaluation_tags.tag, COUNT(*) AS count
+		FROM ride_evaluation_tags
+		INNER JOIN rides ON rides.id = ride_evaluation_tags.ride_id
+		INNER JOIN chairs ON chairs.id = rides.chair_id
+		WHERE chairs.owner_id = ?
+		GROUP BY rides.chair_id, ride_evaluation_tags.tag`,
+		ownerID,
+	); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]map[string]int{}
+	for _, row := range rows {
+		if counts[row.ChairID] == nil {
+			counts[row.ChairID] = map[string]int{}
+		}
+		counts[row.ChairID][row.Tag] = row.Count
+	}
+	return counts, nil
+}
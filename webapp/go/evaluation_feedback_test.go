@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEvaluationComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    string
+		// wantRule が空でなければ comment のエラーになる
+		wantRule string
+	}{
+		{name: "empty", comment: "", want: ""},
+		{name: "trimmed", comment: "  とても快適でした \n", want: "とても快適でした"},
+		{name: "multiline", comment: "clean\nand quiet", want: "clean\nand quiet"},
+		{name: "max length in runes", comment: strings.Repeat("あ", evaluationCommentMaxLength), want: strings.Repeat("あ", evaluationCommentMaxLength)},
+		{name: "too long", comment: strings.Repeat("あ", evaluationCommentMaxLength+1), wantRule: "max"},
+		{name: "control character", comment: "bad\x00comment", wantRule: "printable"},
+		{name: "url", comment: "see HTTPS://example.com", wantRule: "no_url"},
+		{name: "banned word", comment: "Shit chair", wantRule: "banned_words"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeEvaluationComment(tt.comment)
+			if tt.wantRule != "" {
+				assertFieldError(t, err, "comment", tt.wantRule)
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeEvaluationComment() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeEvaluationComment() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEvaluationTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      []string
+		wantField string
+		wantRule  string
+	}{
+		{name: "nil", tags: nil},
+		{name: "known tags", tags: []string{"clean", "punctual", "comfortable"}},
+		{name: "unknown tag", tags: []string{"clean", "fast"}, wantField: "tags[1]", wantRule: "oneof"},
+		{name: "duplicated tag", tags: []string{"clean", "clean"}, wantField: "tags[1]", wantRule: "unique"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEvaluationTags(tt.tags)
+			if tt.wantRule != "" {
+				assertFieldError(t, err, tt.wantField, tt.wantRule)
+				return
+			}
+			if err != nil {
+				t.Errorf("validateEvaluationTags() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeDB は MySQL なしでハンドラを動かすためのテスト用のドライバ
+// クエリを登録順に正規表現で照合し、最初に合ったルールの結果を返す。どのルールにも合わないクエリはテストを失敗させる
+type fakeDB struct {
+	t     *testing.T
+	mu    sync.Mutex
+	rules []*fakeRule
+	execs []fakeExec
+}
+
+type fakeRule struct {
+	pattern  *regexp.Regexp
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+	// args が nil でなければ、引数が一致するクエリだけに使う
+	args []driver.Value
+	// times が0より大きい場合は、その回数だけ使ったら次のルールに譲る
+	times int
+	used  int
+}
+
+type fakeExec struct {
+	Query string
+	Args  []driver.Value
+}
+
+// newFakeDB は db を fakeDB に差し替え、テストの終わりに元に戻す
+func newFakeDB(t *testing.T) *fakeDB {
+	t.Helper()
+	f := &fakeDB{t: t}
+	saved := db
+	db = sqlx.NewDb(sql.OpenDB(f), "mysql")
+	t.Cleanup(func() {
+		db.Close()
+		db = saved
+	})
+	return f
+}
+
+// query は pattern に合うクエリに columns と rows を返すルールを足す。rows がなければ sql.ErrNoRows になる
+func (f *fakeDB) query(pattern string, columns []string, rows ...[]any) *fakeRule {
+	r := &fakeRule{pattern: regexp.MustCompile(pattern), columns: columns}
+	for _, row := range rows {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			if n, ok := v.(int); ok {
+				v = int64(n)
+			}
+			values[i] = v
+		}
+		r.rows = append(r.rows, values)
+	}
+	return f.add(r)
+}
+
+// returns は pattern に合うクエリに values を行として返すルールを足す
+// 構造体は db タグをカラム名にし、それ以外の値は1カラムの行にする
+func (f *fakeDB) returns(pattern string, values ...any) *fakeRule {
+	r := &fakeRule{pattern: regexp.MustCompile(pattern)}
+	for _, v := range values {
+		columns, row := fakeRow(v)
+		r.columns = columns
+		r.rows = append(r.rows, row)
+	}
+	return f.add(r)
+}
+
+func fakeRow(v any) ([]string, []driver.Value) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() == reflect.TypeOf(time.Time{}) {
+		return []string{"value"}, []driver.Value{fakeValue(v)}
+	}
+	var columns []string
+	var row []driver.Value
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			c, r := fakeRow(rv.Field(i).Interface())
+			columns = append(columns, c...)
+			row = append(row, r...)
+			continue
+		}
+		name := field.Tag.Get("db")
+		if name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, name)
+		row = append(row, fakeValue(rv.Field(i).Interface()))
+	}
+	return columns, row
+}
+
+func fakeValue(v any) driver.Value {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// exec は pattern に合う更新系のクエリに affected 行を更新したと返すルールを足す
+func (f *fakeDB) exec(pattern string, affected int64) *fakeRule {
+	return f.add(&fakeRule{pattern: regexp.MustCompile(pattern), affected: affected})
+}
+
+// fail は pattern に合うクエリに err を返すルールを足す
+func (f *fakeDB) fail(pattern string, err error) *fakeRule {
+	return f.add(&fakeRule{pattern: regexp.MustCompile(pattern), err: err})
+}
+
+func (f *fakeDB) add(r *fakeRule) *fakeRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, r)
+	return r
+}
+
+// with はルールを引数が args と一致するクエリだけに使うようにする
+func (r *fakeRule) with(args ...any) *fakeRule {
+	r.args = make([]driver.Value, len(args))
+	for i, v := range args {
+		if n, ok := v.(int); ok {
+			v = int64(n)
+		}
+		r.args[i] = v
+	}
+	return r
+}
+
+// once はルールを1回だけ使うようにする
+func (r *fakeRule) once() *fakeRule {
+	r.times = 1
+	return r
+}
+
+// executed は pattern に合う実行済みの更新系のクエリを返す
+func (f *fakeDB) executed(pattern string) []fakeExec {
+	re := regexp.MustCompile(pattern)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var execs []fakeExec
+	for _, e := range f.execs {
+		if re.MatchString(e.Query) {
+			execs = append(execs, e)
+		}
+	}
+	return execs
+}
+
+func (f *fakeDB) match(query string, args []driver.NamedValue) (*fakeRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.rules {
+		if r.times > 0 && r.used >= r.times {
+			continue
+		}
+		if r.args != nil && !fakeArgsEqual(r.args, args) {
+			continue
+		}
+		if r.pattern.MatchString(query) {
+			r.used++
+			return r, nil
+		}
+	}
+	f.t.Errorf("fakeDB: unexpected query: %s", query)
+	return nil, fmt.Errorf("fakeDB: unexpected query: %s", query)
+}
+
+func fakeArgsEqual(want []driver.Value, args []driver.NamedValue) bool {
+	if len(want) != len(args) {
+		return false
+	}
+	for i, arg := range args {
+		if fmt.Sprint(arg.Value) != fmt.Sprint(want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeDB: use sql.OpenDB")
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakeDB: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.db.match(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeRows{columns: r.columns, rows: r.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r, err := c.db.match(query, args)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.db.mu.Lock()
+	c.db.execs = append(c.db.execs, fakeExec{Query: query, Args: values})
+	c.db.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return driver.RowsAffected(r.affected), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	i       int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/isucon/isucon14/webapp/go/auth"
+)
+
+// newRequest は body を JSON として送るリクエストを作る。pathValues は "name", "value" の順に並べる
+func newRequest(method, target, body string, pathValues ...string) *http.Request {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		req.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	return req
+}
+
+// asUser, asOwner, asChair は認証ミドルウェアを通した後のリクエストにする
+func asUser(r *http.Request, user *User) *http.Request {
+	return r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleUser, ID: user.ID}, user))
+}
+
+func asOwner(r *http.Request, owner *Owner) *http.Request {
+	return r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleOwner, ID: owner.ID}, owner))
+}
+
+func asChair(r *http.Request, chair *Chair) *http.Request {
+	return r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleChair, ID: chair.ID}, chair))
+}
+
+// handlerTest はハンドラのテーブルテストの1ケース
+type handlerTest struct {
+	name string
+	// setup で fakeDB に返す結果を登録する
+	setup      func(f *fakeDB)
+	req        *http.Request
+	wantStatus int
+	// wantCode が空でなければエラーレスポンスの code を確かめる
+	wantCode string
+	// wantFields はエラーレスポンスの details に含まれるフィールド
+	wantFields []string
+	// check でレスポンスボディやDBへの書き込みを確かめる
+	check func(t *testing.T, f *fakeDB, body []byte)
+}
+
+func runHandlerTests(t *testing.T, handler http.HandlerFunc, tests []handlerTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDB(t)
+			if tt.setup != nil {
+				tt.setup(f)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, tt.req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" || len(tt.wantFields) > 0 {
+				assertErrorResponse(t, rec.Body.Bytes(), tt.wantCode, tt.wantFields...)
+			}
+			if tt.check != nil {
+				tt.check(t, f, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+// assertErrorResponse はエラーレスポンスの code と details のフィールドを確かめる
+func assertErrorResponse(t *testing.T, body []byte, wantCode string, wantFields ...string) {
+	t.Helper()
+	res := errorResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != wantCode {
+		t.Errorf("code = %q, want %q: %s", res.Code, wantCode, body)
+	}
+	if len(res.Details) != len(wantFields) {
+		t.Fatalf("details = %+v, want fields %v", res.Details, wantFields)
+	}
+	for i, field := range wantFields {
+		if res.Details[i].Field != field {
+			t.Errorf("details[%d].field = %q, want %q", i, res.Details[i].Field, field)
+		}
+	}
+}
+
+func decodeJSON[T any](t *testing.T, body []byte) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("failed to decode %s: %v", body, err)
+	}
+	return v
+}
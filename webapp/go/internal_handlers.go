@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"sort"
 )
 
 // このAPIをインスタンス内から一定間隔で叩かせることで、椅子とライドをマッチングさせる
@@ -36,37 +37,77 @@ func internalGetMatching(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 有効な chairs を取得
-	chairs := []*Chair{}
-	query := `
-		SELECT chairs.* 
-		FROM chairs
-		INNER JOIN chair_models cm ON cm.name = chairs.model
-		WHERE chairs.is_active = TRUE
-		AND NOT EXISTS (
-			SELECT 1
-			FROM ride_statuses rs
-			INNER JOIN rides r ON r.id = rs.ride_id
-			WHERE r.chair_id = chairs.id
-			GROUP BY rs.ride_id
-			HAVING COUNT(rs.chair_sent_at) != 6
-		)
-		ORDER BY cm.speed DESC
-		LIMIT 30;
-	`
-	if err := db.SelectContext(ctx, &chairs, query); err != nil {
-		if errors.Is(err, sql.ErrNoRows) || len(chairs) == 0 {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+	chairs, err := getAvailableChairs(ctx, db, 30)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if len(chairs) == 0 {
+		storeAvailableChairs(chairs)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 評価の低いユーザーのライドは、他のライドに椅子を割り当てた後に回す
+	userIDs := make([]string, 0, len(rides))
+	for _, ride := range rides {
+		userIDs = append(userIDs, ride.UserID)
+	}
+	evaluations, err := getUserEvaluationStats(ctx, db, userIDs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sort.SliceStable(rides, func(i, j int) bool {
+		return !isLowRatedUser(evaluations[rides[i].UserID]) && isLowRatedUser(evaluations[rides[j].UserID])
+	})
+
+	// 古いライドから順に、配車位置に最も早く着ける椅子を割り当てる
+	// 位置情報がまだない椅子は到着予定を出せないので、他に椅子がない場合だけ割り当てる
+	locations := map[string]*ChairLocation{}
+	for _, chair := range chairs {
+		location, err := getChairLocation(ctx, db, chair.ID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		locations[chair.ID] = location
+	}
+
+	for _, ride := range rides {
+		if len(chairs) == 0 {
+			break
+		}
+
+		best := 0
+		var bestETA *rideETA
+		for i, chair := range chairs {
+			location, ok := locations[chair.ID]
+			if !ok {
+				continue
+			}
+			estimate := estimateRideETA(
+				"MATCHING",
+				Coordinate{Latitude: ride.PickupLatitude, Longitude: ride.PickupLongitude},
+				Coordinate{Latitude: ride.DestinationLatitude, Longitude: ride.DestinationLongitude},
+				Coordinate{Latitude: location.Latitude, Longitude: location.Longitude},
+				chair.Speed,
+			)
+			if estimate != nil && (bestETA == nil || estimate.PickupMs < bestETA.PickupMs) {
+				best = i
+				bestETA = estimate
+			}
+		}
+		chair := chairs[best]
+		chairs = append(chairs[:best], chairs[best+1:]...)
 
-	for i := 0; i < len(rides) && i < len(chairs); i++ {
 		if _, err := tx.ExecContext(ctx, `
 			UPDATE rides 
 			SET chair_id = ? 
-			WHERE id = ?`, chairs[i].ID, rides[i].ID); err != nil {
+			WHERE id = ?`, chair.ID, ride.ID); err != nil {
 			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -77,6 +118,8 @@ func internalGetMatching(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	// 割り当てずに残った椅子は、次のマッチングまで料金見積もりの到着予定に使う
+	storeAvailableChairs(chairs)
 
 	w.WriteHeader(http.StatusNoContent)
 }
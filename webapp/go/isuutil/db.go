@@ -13,11 +13,31 @@ const (
 	driverName = "mysql"
 )
 
+// DBPoolConfig はコネクションプールの設定
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// DefaultDBPoolConfig はコネクションプールのデフォルト。
+// コネクション数はデフォルトでは無制限になっているので、数十から数百くらいで要調整。
+var DefaultDBPoolConfig = DBPoolConfig{
+	MaxOpenConns:    100,
+	MaxIdleConns:    100,
+	ConnMaxLifetime: 5 * time.Minute,
+}
+
 // NewIsuconDB はISUCON用にカスタマイズされたsqlxのDBクライアントを返します。
 // 再起動試験対策済み。
 func NewIsuconDB(config *mysql.Config) (*sqlx.DB, error) {
 
-	return newIsuconDB(config)
+	return newIsuconDB(config, DefaultDBPoolConfig)
+}
+
+// NewIsuconDBWithPool は pool のコネクションプールの設定で NewIsuconDB と同じことをします。
+func NewIsuconDBWithPool(config *mysql.Config, pool DBPoolConfig) (*sqlx.DB, error) {
+	return newIsuconDB(config, pool)
 }
 
 // NewIsuconDBFromDSN はISUCON用にカスタマイズされたsqlxのDBクライアントを返します。
@@ -27,10 +47,10 @@ func NewIsuconDBFromDSN(dsn string) (*sqlx.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse DSN: %w", err)
 	}
-	return newIsuconDB(config)
+	return newIsuconDB(config, DefaultDBPoolConfig)
 }
 
-func newIsuconDB(config *mysql.Config) (*sqlx.DB, error) {
+func newIsuconDB(config *mysql.Config, pool DBPoolConfig) (*sqlx.DB, error) {
 	// ISUCONにおける必須の設定項目たち
 	config.ParseTime = true
 	config.InterpolateParams = true
@@ -45,11 +65,9 @@ func newIsuconDB(config *mysql.Config) (*sqlx.DB, error) {
 
 	dbx := sqlx.NewDb(stdDb, driverName)
 
-	// コネクション数はデフォルトでは無制限になっている。
-	// 数十から数百くらいで要調整。
-	dbx.SetMaxOpenConns(100)
-	dbx.SetMaxIdleConns(100)
-	dbx.SetConnMaxLifetime(5 * time.Minute)
+	dbx.SetMaxOpenConns(pool.MaxOpenConns)
+	dbx.SetMaxIdleConns(pool.MaxIdleConns)
+	dbx.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
 	// 再起動試験対策
 	// Pingして接続が確立するまで待つ
@@ -5,14 +5,21 @@ import (
 	"net/http"
 )
 
-const pproteinCollectEndpoint = "http://isucon-o11y:9000/api/group/collect"
+// DefaultPproteinCollectEndpoint は isucon-o11y にホストされている PProtein の Collect API
+const DefaultPproteinCollectEndpoint = "http://isucon-o11y:9000/api/group/collect"
 
 // KickPproteinCollect は PProteinのCollectを開始するAPIを呼び出します。
 // この関数をinitializeで呼び出すことで自動でPProteinのCollectが開始されます。
 func KickPproteinCollect() error {
-	_, err := http.DefaultClient.Get(pproteinCollectEndpoint)
+	return KickPproteinCollectTo(DefaultPproteinCollectEndpoint)
+}
+
+// KickPproteinCollectTo は endpoint の PProtein の Collect を開始します。
+func KickPproteinCollectTo(endpoint string) error {
+	res, err := http.DefaultClient.Get(endpoint)
 	if err != nil {
 		return fmt.Errorf("failed to kick pprotein collect: %w", err)
 	}
+	res.Body.Close()
 	return nil
 }
@@ -13,39 +13,53 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 )
 
-const (
-	// traceIDRatioBased はトレースのサンプリングレート
-	// Jaegerが一度に表示できるトレース数が1500なので、パフォーマンス改善に応じて調整する
-	traceIDRatioBased = 0.01
-	// 基本的にisucon-o11yにホストされているJaegerにTraceを送る
-	endpoint = "isucon-o11y:4317"
-
-	serviceName = "webapp"
-)
+const serviceName = "webapp"
+
+// TraceConfig はトレースの送信先とサンプリングの設定
+type TraceConfig struct {
+	// Endpoint は OTLP(gRPC) でトレースを受け取るコレクターのアドレス
+	Endpoint string
+	// SamplingRatio はトレースのサンプリングレート
+	SamplingRatio float64
+	ServiceName   string
+}
+
+// DefaultTraceConfig は isucon-o11y にホストされている Jaeger に送る設定。
+// Jaegerが一度に表示できるトレース数が1500なので、SamplingRatio はパフォーマンス改善に応じて調整する
+var DefaultTraceConfig = TraceConfig{
+	Endpoint:      "isucon-o11y:4317",
+	SamplingRatio: 0.01,
+	ServiceName:   serviceName,
+}
 
 // InitializeTracerProvider はmain関数で呼び出されることを想定。
 // 設定したTracerは otel.GetTracerProvider().Tracer("") で呼び出し可能。
 func InitializeTracerProvider() (*sdktrace.TracerProvider, error) {
+	return InitializeTracerProviderWithConfig(DefaultTraceConfig)
+}
+
+// InitializeTracerProviderWithConfig は cfg の設定で InitializeTracerProvider と同じことをします。
+func InitializeTracerProviderWithConfig(cfg TraceConfig) (*sdktrace.TracerProvider, error) {
 	res, err := resource.New(context.Background(),
 		resource.WithTelemetrySDK(),
 	)
 	resAttr := resource.NewWithAttributes(
 		semconv.SchemaURL,
-		semconv.ServiceNameKey.String(serviceName),
+		semconv.ServiceNameKey.String(cfg.ServiceName),
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("faield to create resource: %w", err)
 	}
 
-	client := otlptracegrpc.NewClient(otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	client := otlptracegrpc.NewClient(otlptracegrpc.WithEndpoint(cfg.Endpoint), otlptracegrpc.WithInsecure())
 	exp, err := otlptrace.New(context.Background(), client)
 	if err != nil {
 		return nil, err
 	}
 
 	tracerProviderOptions := []sdktrace.TracerProviderOption{
-		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(traceIDRatioBased))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SamplingRatio))),
 		sdktrace.WithResource(res),
 		sdktrace.WithResource(resAttr),
 		sdktrace.WithBatcher(exp),
@@ -1,6 +1,7 @@
 package isuutil
 
 import (
+	"sync"
 	"time"
 )
 
@@ -8,7 +9,9 @@ import (
 // 処理を一定間隔で非同期に実行したいときに使う。
 type Worker[T any] struct {
 	ch       chan T
+	flushCh  chan chan struct{}
 	interval time.Duration
+	wg       sync.WaitGroup
 }
 
 func NewWorker[T any](interval time.Duration) *Worker[T] {
@@ -16,6 +19,7 @@ func NewWorker[T any](interval time.Duration) *Worker[T] {
 		// sizeをめちゃくちゃでかくしといて、channelへの送信がブロックされないようにする
 		// ISUCONなら良いが、業務ではあまりやらないほうが良い
 		ch:       make(chan T, 100000),
+		flushCh:  make(chan chan struct{}),
 		interval: interval,
 	}
 }
@@ -25,6 +29,15 @@ func (w *Worker[T]) Send(item T) {
 	w.ch <- item
 }
 
+// Flush はそれまでにSendされたitemをすべて処理し、実行中の処理も含めて終わるまで待ちます。
+// シャットダウン時や、DBを初期化する前に溜まっているitemを書き出したいときに使う。
+// Run が動いていないと返ってこないので注意。
+func (w *Worker[T]) Flush() {
+	done := make(chan struct{})
+	w.flushCh <- done
+	<-done
+}
+
 // Run はworkerを起動します。
 // Run 関数はgoroutineで動くことが想定されています。
 // main関数で一度実行すると良いでしょう。
@@ -40,7 +53,9 @@ func (w *Worker[T]) Run(fun func([]T)) {
 				break
 			}
 
+			w.wg.Add(1)
 			go func(items []T) {
+				defer w.wg.Done()
 				// ここで定期的に何かの処理をする
 				// channelからの受信をブロックしても良いなら、goroutineで実行せずにそのまま実行してもよい
 				fun(items)
@@ -50,6 +65,23 @@ func (w *Worker[T]) Run(fun func([]T)) {
 			items = []T{}
 		case item := <-w.ch:
 			items = append(items, item)
+		case done := <-w.flushCh:
+			// channelに残っているitemも取り出してから同期的に処理する
+		drain:
+			for {
+				select {
+				case item := <-w.ch:
+					items = append(items, item)
+				default:
+					break drain
+				}
+			}
+			if len(items) > 0 {
+				fun(items)
+				items = []T{}
+			}
+			w.wg.Wait()
+			close(done)
 		}
 	}
 }
@@ -23,3 +23,25 @@ func TestWorker_Run(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestWorker_Flush(t *testing.T) {
+	exampleWorker := NewWorker[int](1 * time.Hour)
+	mu := sync.Mutex{}
+	got := []int{}
+	go exampleWorker.Run(func(items []int) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, items...)
+	})
+
+	for i := 0; i < 10; i++ {
+		exampleWorker.Send(i)
+	}
+	exampleWorker.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 10 {
+		t.Fatalf("expected 10 items to be flushed, got %d", len(got))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// cacheWarmedUp は initCache が成功してから次に初期化が始まるまで true
+	cacheWarmedUp atomic.Bool
+	shuttingDown  atomic.Bool
+)
+
+var (
+	backgroundCtx, stopBackgroundJobs = context.WithCancel(context.Background())
+	backgroundJobs                    sync.WaitGroup
+)
+
+// runPeriodically は f を interval ごとに実行する
+// シャットダウン時は実行中の f が終わるのを待ってから止まるので、f には途中でキャンセルされない ctx を渡す
+func runPeriodically(interval time.Duration, f func(ctx context.Context)) {
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+				f(context.WithoutCancel(backgroundCtx))
+			}
+		}
+	}()
+}
+
+// waitBackgroundJobs は実行中のジョブが終わるまで待つ。ctx が先に終わった場合はエラーを返す
+func waitBackgroundJobs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		backgroundJobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown は新しいリクエストの受け付けを止め、処理中のリクエストとジョブを待ってからDBを閉じる
+func shutdown(server *http.Server) {
+	shuttingDown.Store(true)
+	slog.Info("shutting down", "drain_delay", time.Duration(appConfig.Shutdown.DrainDelay))
+	time.Sleep(time.Duration(appConfig.Shutdown.DrainDelay))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(appConfig.Shutdown.Timeout))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to wait for in-flight requests", "error", err)
+	}
+	stopBackgroundJobs()
+	if err := waitBackgroundJobs(ctx); err != nil {
+		slog.Error("failed to wait for background jobs", "error", err)
+	}
+	if appConfig.ChairLocation.FlushOnShutdown {
+		flushPendingChairLocations()
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("failed to close db", "error", err)
+	}
+	slog.Info("shutdown completed")
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// getHealthz はプロセスが応答できるかだけを返す。DBが落ちていても再起動では直らないので見ない
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// getReadyz はリクエストを受けてよいかを返す。シャットダウン中、キャッシュの準備中、DBに繋がらない場合は 503
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		writeError(w, http.StatusServiceUnavailable, errors.New("shutting down"))
+		return
+	}
+	if !cacheWarmedUp.Load() {
+		writeError(w, http.StatusServiceUnavailable, errors.New("cache is warming up"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("db is unavailable: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
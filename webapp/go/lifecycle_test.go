@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetReadyz(t *testing.T) {
+	defer cacheWarmedUp.Store(cacheWarmedUp.Load())
+	defer shuttingDown.Store(shuttingDown.Load())
+
+	tests := []struct {
+		name         string
+		warmedUp     bool
+		shuttingDown bool
+		wantMessage  string
+	}{
+		{name: "warming up", warmedUp: false, wantMessage: "cache is warming up"},
+		{name: "shutting down", warmedUp: true, shuttingDown: true, wantMessage: "shutting down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheWarmedUp.Store(tt.warmedUp)
+			shuttingDown.Store(tt.shuttingDown)
+
+			rec := httptest.NewRecorder()
+			getReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+			res := errorResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Message != tt.wantMessage || res.Code != errCodeUnavailable {
+				t.Errorf("response = %+v, want message %q", res, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestWaitBackgroundJobs(t *testing.T) {
+	running := make(chan struct{})
+	release := make(chan struct{})
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		close(running)
+		<-release
+	}()
+	<-running
+
+	// 実行中のジョブがあればタイムアウトする
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := waitBackgroundJobs(ctx); err == nil {
+		t.Fatal("waitBackgroundJobs() should time out while a job is running")
+	}
+
+	close(release)
+	if err := waitBackgroundJobs(context.Background()); err != nil {
+		t.Fatalf("waitBackgroundJobs() = %v", err)
+	}
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
+	"context"
 	crand "crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-sql-driver/mysql"
+	"github.com/isucon/isucon14/webapp/go/eta"
 	"github.com/isucon/isucon14/webapp/go/isuutil"
+	"github.com/isucon/isucon14/webapp/go/ratelimit"
+	"github.com/isucon/isucon14/webapp/go/validation"
 	"github.com/jmoiron/sqlx"
 	"github.com/kaz/pprotein/integration/standalone"
 )
@@ -23,45 +32,63 @@ import (
 var db *sqlx.DB
 
 func main() {
-	mux := setup()
-	slog.Info("Listening on :8080")
-	go standalone.Integrate(":19001")
-	chairLocationCache.Clear()
-	http.ListenAndServe(":8080", mux)
-}
-
-func setup() http.Handler {
-	host := os.Getenv("ISUCON_DB_HOST")
-	if host == "" {
-		host = "127.0.0.1"
-	}
-	port := os.Getenv("ISUCON_DB_PORT")
-	if port == "" {
-		port = "3306"
-	}
-	_, err := strconv.Atoi(port)
+	cfg, args, err := loadConfig(os.Args[0], os.Args[1:], os.Getenv)
 	if err != nil {
-		panic(fmt.Sprintf("failed to convert DB port number from ISUCON_DB_PORT environment variable into int: %v", err))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	user := os.Getenv("ISUCON_DB_USER")
-	if user == "" {
-		user = "isucon"
+	appConfig = cfg
+
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+			os.Exit(2)
+		}
+		os.Exit(runMigrateCommand(args[1:]))
 	}
-	password := os.Getenv("ISUCON_DB_PASSWORD")
-	if password == "" {
-		password = "isucon"
+
+	if appConfig.Trace.Enabled {
+		tp, err := isuutil.InitializeTracerProviderWithConfig(appConfig.traceConfig())
+		if err != nil {
+			panic(err)
+		}
+		defer tp.Shutdown(context.Background())
 	}
-	dbname := os.Getenv("ISUCON_DB_NAME")
-	if dbname == "" {
-		dbname = "isuride"
+
+	mux := setup()
+	if appConfig.Pprotein.AgentAddr != "" {
+		go standalone.Integrate(appConfig.Pprotein.AgentAddr)
 	}
+	chairLocations.Clear()
+	startChairLocationWriter()
+	startChairLocationRetention()
+	startRideReservationScheduler()
+	startRideAutoCompletion()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
+	server := &http.Server{Addr: appConfig.Server.Addr, Handler: mux}
+	go func() {
+		slog.Info("Listening on " + appConfig.Server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("failed to listen", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdown(server)
+}
+
+func connectDB() {
 	dbConfig := mysql.NewConfig()
-	dbConfig.User = user
-	dbConfig.Passwd = password
-	dbConfig.Addr = net.JoinHostPort(host, port)
+	dbConfig.User = appConfig.DB.User
+	dbConfig.Passwd = appConfig.DB.Password
+	dbConfig.Addr = net.JoinHostPort(appConfig.DB.Host, strconv.Itoa(appConfig.DB.Port))
 	dbConfig.Net = "tcp"
-	dbConfig.DBName = dbname
+	dbConfig.DBName = appConfig.DB.Name
 	dbConfig.ParseTime = true
 
 	// _db, err := sqlx.Connect("mysql", dbConfig.FormatDSN())
@@ -70,55 +97,126 @@ func setup() http.Handler {
 	// }
 	// db = _db
 
-	db, err = isuutil.NewIsuconDB(dbConfig)
+	var err error
+	db, err = isuutil.NewIsuconDBWithPool(dbConfig, appConfig.dbPoolConfig())
 	if err != nil {
 		panic(err)
 	}
+}
+
+func setup() http.Handler {
+	connectDB()
+
+	// 失敗しても起動はして、POST /api/initialize で作り直せるようにする。それまで /readyz は 503 を返す
+	if err := initCache(); err != nil {
+		slog.Error("failed to warm up cache", "error", err)
+	}
+	etaCalculator = eta.NewCalculator(time.Duration(appConfig.Ride.ChairMoveInterval))
+	tokenCachePeers = appConfig.TokenCache.peers()
+	setupRateLimits()
 
-	initCache()
+	return newRouter()
+}
 
+// newRouter はすべてのハンドラを登録したルーターを返す
+func newRouter() *chi.Mux {
 	mux := chi.NewRouter()
 	mux.Use(middleware.Logger)
 	mux.Use(middleware.Recoverer)
 	mux.HandleFunc("POST /api/initialize", postInitialize)
+	mux.HandleFunc("GET /healthz", getHealthz)
+	mux.HandleFunc("GET /readyz", getReadyz)
 
 	// app handlers
 	{
 		mux.HandleFunc("POST /api/app/users", appPostUsers)
+		mux.HandleFunc("POST /api/app/sessions", postSessions(sessionRoleApp))
 
-		authedMux := mux.With(appAuthMiddleware)
+		authedMux := mux.With(appAuthMiddleware, rateLimitMiddleware)
 		authedMux.HandleFunc("POST /api/app/payment-methods", appPostPaymentMethods)
 		authedMux.HandleFunc("GET /api/app/rides", appGetRides)
 		authedMux.HandleFunc("POST /api/app/rides", appPostRides)
 		authedMux.HandleFunc("POST /api/app/rides/estimated-fare", appPostRidesEstimatedFare)
 		authedMux.HandleFunc("POST /api/app/rides/{ride_id}/evaluation", appPostRideEvaluatation)
+		authedMux.HandleFunc("GET /api/app/places", appGetPlaces)
+		authedMux.HandleFunc("POST /api/app/places", appPostPlaces)
+		authedMux.HandleFunc("DELETE /api/app/places/{place_id}", appDeletePlace)
+		authedMux.HandleFunc("GET /api/app/places/recent", appGetRecentPlaces)
+		authedMux.HandleFunc("GET /api/app/reservations", appGetRideReservations)
+		authedMux.HandleFunc("DELETE /api/app/reservations/{reservation_id}", appDeleteRideReservation)
 		authedMux.HandleFunc("GET /api/app/notification", appGetNotification)
 		authedMux.HandleFunc("GET /api/app/nearby-chairs", appGetNearbyChairs)
+		authedMux.HandleFunc("POST /api/app/logout", postLogout(sessionRoleApp))
 	}
 
 	// owner handlers
 	{
 		mux.HandleFunc("POST /api/owner/owners", ownerPostOwners)
-
-		authedMux := mux.With(ownerAuthMiddleware)
-		authedMux.HandleFunc("GET /api/owner/sales", ownerGetSales)
-		authedMux.HandleFunc("GET /api/owner/chairs", ownerGetChairs)
+		mux.HandleFunc("POST /api/owner/sessions", postSessions(sessionRoleOwner))
+
+		authedMux := mux.With(ownerAuthMiddleware, rateLimitMiddleware)
+		authedMux.HandleFunc("GET /api/owner/api-keys", ownerGetAPIKeys)
+		authedMux.HandleFunc("POST /api/owner/api-keys", ownerPostAPIKeys)
+		authedMux.HandleFunc("DELETE /api/owner/api-keys/{api_key_id}", ownerDeleteAPIKey)
+		authedMux.HandleFunc("POST /api/owner/logout", postLogout(sessionRoleOwner))
+
+		// 以下はセッションに加えて、スコープを持つAPIキーでも呼び出せる
+		salesMux := mux.With(ownerAPIKeyAuthMiddleware(ownerAPIKeyScopeSalesRead), rateLimitMiddleware)
+		salesMux.HandleFunc("GET /api/owner/sales", ownerGetSales)
+
+		fleetMux := mux.With(ownerAPIKeyAuthMiddleware(ownerAPIKeyScopeFleet), rateLimitMiddleware)
+		fleetMux.HandleFunc("GET /api/owner/chairs", ownerGetChairs)
+		fleetMux.HandleFunc("PATCH /api/owner/chairs/{chair_id}", ownerPatchChair)
+		fleetMux.HandleFunc("GET /api/owner/chairs/{chair_id}/trail", ownerGetChairTrail)
+		fleetMux.HandleFunc("GET /api/owner/chair-register-tokens", ownerGetChairRegisterTokens)
+		fleetMux.HandleFunc("POST /api/owner/chair-register-tokens", ownerPostChairRegisterTokens)
+		fleetMux.HandleFunc("DELETE /api/owner/chair-register-tokens/{token_id}", ownerDeleteChairRegisterToken)
 	}
 
 	// chair handlers
 	{
 		mux.HandleFunc("POST /api/chair/chairs", chairPostChairs)
+		mux.HandleFunc("POST /api/chair/sessions", postSessions(sessionRoleChair))
 
-		authedMux := mux.With(chairAuthMiddleware)
+		authedMux := mux.With(chairAuthMiddleware, rateLimitMiddleware)
 		authedMux.HandleFunc("POST /api/chair/activity", chairPostActivity)
 		authedMux.HandleFunc("POST /api/chair/coordinate", chairPostCoordinate)
+		authedMux.HandleFunc("POST /api/chair/coordinates", chairPostCoordinates)
 		authedMux.HandleFunc("GET /api/chair/notification", chairGetNotification)
 		authedMux.HandleFunc("POST /api/chair/rides/{ride_id}/status", chairPostRideStatus)
+		authedMux.HandleFunc("POST /api/chair/rides/{ride_id}/evaluation", chairPostRideEvaluation)
+		authedMux.HandleFunc("GET /api/chair/rides", chairGetRides)
+		authedMux.HandleFunc("GET /api/chair/earnings", chairGetEarnings)
+		authedMux.HandleFunc("POST /api/chair/logout", postLogout(sessionRoleChair))
+	}
+
+	// chair model handlers
+	{
+		mux.HandleFunc("GET /api/chair-models", getChairModels)
+	}
+
+	// admin handlers
+	{
+		authedMux := mux.With(adminAuthMiddleware)
+		authedMux.HandleFunc("GET /api/admin/chair-models", adminGetChairModels)
+		authedMux.HandleFunc("POST /api/admin/chair-models", adminPostChairModels)
+		authedMux.HandleFunc("PATCH /api/admin/chair-models/{name}", adminPatchChairModel)
+		authedMux.HandleFunc("GET /api/admin/sessions", adminGetSessions)
+		authedMux.HandleFunc("POST /api/admin/sessions/revoke", adminPostSessionsRevoke)
 	}
 
 	// internal handlers
 	{
 		mux.HandleFunc("GET /api/internal/matching", internalGetMatching)
+		mux.With(adminAuthMiddleware).HandleFunc("GET /api/internal/config", internalGetConfig)
+		// 他のサーバーとキャッシュを共有しない場合は、無効化の通知を受け付けない
+		if appConfig.TokenCache.Peers != "" {
+			mux.HandleFunc("POST /api/internal/token-cache/invalidate", internalPostTokenCacheInvalidate)
+		}
+		// バケットを共有しない場合は、他のサーバーからのリクエストを受け付けない
+		if appConfig.RateLimit.Server != "" {
+			mux.Handle("POST /api/internal/rate-limit/take", ratelimit.Handler(localRateLimitStore, appConfig.RateLimit.Token, lookupRateLimit))
+		}
 	}
 
 	return mux
@@ -145,30 +243,10 @@ func dbInitialize() error {
 	// 	db.Exec(sql)
 	// }
 
-	indexsqls := []string{
-		"alter table chairs add index access_token_idx(access_token);",
-		"alter table ride_statuses add index ride_id_create_at_idx(ride_id, created_at DESC);",
-		"alter table chair_locations add index chair_id_create_at_idx(chair_id, created_at DESC);",
-		"alter table rides add index chair_id_updated_at_idx(chair_id, updated_at DESC);",
-		"alter table rides add index user_id_created_at_idx(user_id, created_at DESC);",
-		"alter table coupons add index used_by_idx(used_by);",
-	}
-
-	for _, sql := range indexsqls {
-		if err := isuutil.CreateIndexIfNotExists(db, sql); err != nil {
-			return err
-		}
+	if err := migrateUp(context.Background()); err != nil {
+		return err
 	}
 
-	columnsqls := []string{
-		"ALTER TABLE chairs ADD total_distance INT DEFAULT 0",
-		"ALTER TABLE chairs ADD total_distance_updated_at DATETIME(6)",
-	}
-	for _, sql := range columnsqls {
-		if _, err := db.Exec(sql); err != nil {
-			return err
-		}
-	}
 	var chairs []Chair
 	query := "SELECT * FROM chairs"
 	if err := db.Select(&chairs, query); err != nil {
@@ -195,6 +273,12 @@ func dbInitialize() error {
 }
 
 func initCache() error {
+	cacheWarmedUp.Store(false)
+	sessionCache.Clear()
+	ownerAPIKeyCache.Clear()
+	latestAvailableChairs.Store(nil)
+	rideCompletionRetries.Clear()
+
 	userTokenCache.Clear()
 	query := "SELECT * FROM users"
 	var users []User
@@ -202,7 +286,7 @@ func initCache() error {
 		return err
 	}
 	for _, user := range users {
-		userTokenCache.Store(user.AccessToken, user)
+		userTokenCache.Store(user.ID, user)
 	}
 
 	ownerTokenCache.Clear()
@@ -212,7 +296,7 @@ func initCache() error {
 		return err
 	}
 	for _, owner := range owners {
-		ownerTokenCache.Store(owner.AccessToken, owner)
+		ownerTokenCache.Store(owner.ID, owner)
 	}
 
 	chairTokenCache.Clear()
@@ -222,9 +306,10 @@ func initCache() error {
 		return err
 	}
 	for _, chair := range chairs {
-		chairTokenCache.Store(chair.AccessToken, chair)
+		chairTokenCache.Store(chair.ID, chair)
 	}
 
+	cacheWarmedUp.Store(true)
 	return nil
 }
 
@@ -236,6 +321,11 @@ func postInitialize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 初期化が終わるまではキャッシュが古いので、リクエストを振り分けないでもらう
+	cacheWarmedUp.Store(false)
+	// 初期化後のテーブルに古い位置情報が書き込まれないよう、先に書き出しておく
+	flushPendingChairLocations()
+
 	if out, err := exec.Command("../sql/init.sh").CombinedOutput(); err != nil {
 		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to initialize: %s: %w", string(out), err))
 		return
@@ -247,16 +337,19 @@ func postInitialize(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 	rideStatusCache.Clear()
+	chairLocations.Clear()
 
 	if err := initCache(); err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
-	go func() {
-		if _, err := http.Get("http://isucon-o11y:9000/api/group/collect"); err != nil {
-			log.Printf("failed to communicate with pprotein: %v", err)
-		}
-	}()
+	if appConfig.Pprotein.CollectURL != "" {
+		go func() {
+			if err := isuutil.KickPproteinCollectTo(appConfig.Pprotein.CollectURL); err != nil {
+				log.Printf("failed to communicate with pprotein: %v", err)
+			}
+		}()
+	}
 
 	if _, err := db.ExecContext(ctx, "UPDATE settings SET value = ? WHERE name = 'payment_gateway_url'", req.PaymentServer); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
@@ -266,13 +359,27 @@ func postInitialize(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, postInitializeResponse{Language: "go"})
 }
 
+// Coordinate の範囲は実際の地図よりも十分に広くとっている
 type Coordinate struct {
-	Latitude  int `json:"latitude"`
-	Longitude int `json:"longitude"`
+	Latitude  int `json:"latitude" validate:"min=-100000,max=100000"`
+	Longitude int `json:"longitude" validate:"min=-100000,max=100000"`
 }
 
+// bindJSON はリクエストボディを v にデコードし、v の validate タグで検証する
+// 未知のフィールドや maxRequestBodyBytes を超えるボディはエラーにする
 func bindJSON(r *http.Request, v interface{}) error {
-	return json.NewDecoder(r.Body).Decode(v)
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return toBindError(err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return &apiError{Code: errCodeInvalidJSON, Message: "request body must contain a single JSON value"}
+	}
+	if errs := validation.Struct(v); errs != nil {
+		return &apiError{Code: errCodeValidationFailed, Message: errs.Error(), Details: errs, err: errs}
+	}
+	return nil
 }
 
 func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
@@ -286,10 +393,12 @@ func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
 	w.Write(buf)
 }
 
+// writeError はエラーを errorResponse の形式で返す。err が apiError の場合は code と details も含める
 func writeError(w http.ResponseWriter, statusCode int, err error) {
+	statusCode, res := toErrorResponse(statusCode, err)
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
 	w.WriteHeader(statusCode)
-	buf, marshalError := json.Marshal(map[string]string{"message": err.Error()})
+	buf, marshalError := json.Marshal(res)
 	if marshalError != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{"error":"marshaling error failed"}`))
@@ -297,7 +406,7 @@ func writeError(w http.ResponseWriter, statusCode int, err error) {
 	}
 	w.Write(buf)
 
-	slog.Error("error response wrote", err)
+	slog.Error("error response wrote", "error", err)
 }
 
 func secureRandomStr(b int) string {
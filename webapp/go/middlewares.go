@@ -2,51 +2,85 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
-	"sync"
+	"strings"
+
+	"github.com/isucon/isucon14/webapp/go/auth"
 )
 
-var userTokenCache = sync.Map{}
-var ownerTokenCache = sync.Map{}
-var chairTokenCache = sync.Map{}
+// 各キャッシュのキーは利用者のID。トークンは authenticateSession でセッションに対して検証する
+var userTokenCache = newTokenCache(func(ctx context.Context, id string) (User, error) {
+	user := User{}
+	err := db.GetContext(ctx, &user, "SELECT * FROM users WHERE id = ?", id)
+	return user, err
+})
+var ownerTokenCache = newTokenCache(func(ctx context.Context, id string) (Owner, error) {
+	owner := Owner{}
+	err := db.GetContext(ctx, &owner, "SELECT * FROM owners WHERE id = ?", id)
+	return owner, err
+})
+var chairTokenCache = newTokenCache(func(ctx context.Context, id string) (Chair, error) {
+	chair := Chair{}
+	err := db.GetContext(ctx, &chair, "SELECT * FROM chairs WHERE id = ?", id)
+	return chair, err
+})
+
+func getUserByID(ctx context.Context, id string) (User, bool, error) {
+	return userTokenCache.Get(ctx, id)
+}
+func getOwnerByID(ctx context.Context, id string) (Owner, bool, error) {
+	return ownerTokenCache.Get(ctx, id)
+}
+func getChairByID(ctx context.Context, id string) (Chair, bool, error) {
+	return chairTokenCache.Get(ctx, id)
+}
+
+// errUnauthenticated は認証ミドルウェアを通っていないリクエストに返すエラー
+var errUnauthenticated = errors.New("unauthenticated")
+
+// UserFrom は appAuthMiddleware で認証したユーザーを返す
+func UserFrom(ctx context.Context) (*User, bool) {
+	return auth.From[User](ctx)
+}
 
-func getUserFromToken(token string) (User, bool) {
-	if item, ok := userTokenCache.Load(token); ok {
-		return item.(User), true
-	}
-	return User{}, false
+// OwnerFrom は ownerAuthMiddleware で認証したオーナーを返す
+func OwnerFrom(ctx context.Context) (*Owner, bool) {
+	return auth.From[Owner](ctx)
 }
-func getOwnerFromToken(token string) (Owner, bool) {
-	if item, ok := ownerTokenCache.Load(token); ok {
-		return item.(Owner), true
-	}
-	return Owner{}, false
+
+// ChairFrom は chairAuthMiddleware で認証した椅子を返す
+func ChairFrom(ctx context.Context) (*Chair, bool) {
+	return auth.From[Chair](ctx)
 }
-func getChairFromToken(token string) (Chair, bool) {
-	if item, ok := chairTokenCache.Load(token); ok {
-		return item.(Chair), true
-	}
-	return Chair{}, false
+
+// SessionFrom は認証に使ったセッションを返す
+func SessionFrom(ctx context.Context) (*Session, bool) {
+	return auth.From[Session](ctx)
 }
 
 func appAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		c, err := r.Cookie("app_session")
-		if errors.Is(err, http.ErrNoCookie) || c.Value == "" {
-			writeError(w, http.StatusUnauthorized, errors.New("app_session cookie is required"))
+		session, status, err := authenticateSession(w, r, sessionRoleApp)
+		if err != nil {
+			writeError(w, status, err)
 			return
 		}
-		accessToken := c.Value
 
-		user, exist := getUserFromToken(accessToken)
+		user, exist, err := getUserByID(ctx, session.PrincipalID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
 		if !exist {
 			writeError(w, http.StatusUnauthorized, errors.New("invalid access token"))
 			return
 		}
 
-		ctx = context.WithValue(ctx, "user", &user)
+		ctx = auth.WithPrincipal(ctx, auth.Principal{Role: auth.RoleUser, ID: user.ID, SessionID: session.ID}, &user)
+		ctx = auth.With(ctx, session)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -54,19 +88,24 @@ func appAuthMiddleware(next http.Handler) http.Handler {
 func ownerAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		c, err := r.Cookie("owner_session")
-		if errors.Is(err, http.ErrNoCookie) || c.Value == "" {
-			writeError(w, http.StatusUnauthorized, errors.New("owner_session cookie is required"))
+		session, status, err := authenticateSession(w, r, sessionRoleOwner)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+
+		owner, exist, err := getOwnerByID(ctx, session.PrincipalID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
-		accessToken := c.Value
-		owner, exist := getOwnerFromToken(accessToken)
 		if !exist {
 			writeError(w, http.StatusUnauthorized, errors.New("invalid access token"))
 			return
 		}
 
-		ctx = context.WithValue(ctx, "owner", &owner)
+		ctx = auth.WithPrincipal(ctx, auth.Principal{Role: auth.RoleOwner, ID: owner.ID, SessionID: session.ID}, &owner)
+		ctx = auth.With(ctx, session)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -74,19 +113,48 @@ func ownerAuthMiddleware(next http.Handler) http.Handler {
 func chairAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		c, err := r.Cookie("chair_session")
-		if errors.Is(err, http.ErrNoCookie) || c.Value == "" {
-			writeError(w, http.StatusUnauthorized, errors.New("chair_session cookie is required"))
+		session, status, err := authenticateSession(w, r, sessionRoleChair)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+
+		chair, exist, err := getChairByID(ctx, session.PrincipalID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
-		accessToken := c.Value
-		chair, exist := getChairFromToken(accessToken)
 		if !exist {
 			writeError(w, http.StatusUnauthorized, errors.New("invalid access token"))
 			return
 		}
 
-		ctx = context.WithValue(ctx, "chair", &chair)
+		ctx = auth.WithPrincipal(ctx, auth.Principal{Role: auth.RoleChair, ID: chair.ID, SessionID: session.ID}, &chair)
+		ctx = auth.With(ctx, session)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// bearerToken は Authorization: Bearer で渡されたトークンを返す
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// adminAuthMiddleware は設定の admin.token (ISUCON_ADMIN_TOKEN) に設定したトークンを
+// Authorization: Bearer で渡された場合のみ通す。未設定の場合は管理APIを無効にする
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminToken := appConfig.Admin.Token
+		if adminToken == "" {
+			writeError(w, http.StatusForbidden, errors.New("admin api is disabled"))
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
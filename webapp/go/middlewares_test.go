@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// 認証ミドルウェアを通さずにハンドラを呼んでも panic せず 401 を返すこと
+func TestHandlersWithoutAuthMiddleware(t *testing.T) {
+	// ルーターから認証ミドルウェア付きのルートを集めるので、ルートを足してもテストの修正は要らない
+	authMiddlewares := map[uintptr]bool{}
+	for _, mw := range []func(http.Handler) http.Handler{
+		appAuthMiddleware,
+		ownerAuthMiddleware,
+		chairAuthMiddleware,
+		ownerAPIKeyAuthMiddleware(ownerAPIKeyScopeSalesRead),
+	} {
+		authMiddlewares[reflect.ValueOf(mw).Pointer()] = true
+	}
+
+	count := 0
+	err := chi.Walk(newRouter(), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !slices.ContainsFunc(middlewares, func(mw func(http.Handler) http.Handler) bool {
+			return authMiddlewares[reflect.ValueOf(mw).Pointer()]
+		}) {
+			return nil
+		}
+		count++
+		t.Run(method+" "+route, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("no authenticated routes found")
+	}
+}
+
+func TestOwnerAPIKeyAuthMiddleware(t *testing.T) {
+	const key = ownerAPIKeyPrefix + "test"
+	ownerAPIKeyCache.Store(hashOwnerAPIKey(key), &ownerAPIKeyEntry{
+		key:   OwnerAPIKey{ID: "key1", OwnerID: "owner1", Scopes: ownerAPIKeyScopeSalesRead},
+		owner: Owner{ID: "owner1"},
+	})
+	defer ownerAPIKeyCache.Delete(hashOwnerAPIKey(key))
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		owner, ok := OwnerFrom(r.Context())
+		if !ok || owner.ID != "owner1" {
+			t.Errorf("OwnerFrom() = %v, %v", owner, ok)
+		}
+		if apiKey, ok := APIKeyFrom(r.Context()); !ok || apiKey.ID != "key1" {
+			t.Errorf("APIKeyFrom() = %v, %v", apiKey, ok)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	tests := []struct {
+		name  string
+		scope string
+		key   string
+		want  int
+	}{
+		{name: "allowed scope", scope: ownerAPIKeyScopeSalesRead, key: key, want: http.StatusNoContent},
+		{name: "missing scope", scope: ownerAPIKeyScopeFleet, key: key, want: http.StatusForbidden},
+		{name: "unknown key", scope: ownerAPIKeyScopeSalesRead, key: ownerAPIKeyPrefix + "unknown", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.key != key {
+				// DBを読みに行かないように否定キャッシュに載せておく
+				ownerAPIKeyCache.entries.Store(hashOwnerAPIKey(tt.key), tokenCacheEntry[*ownerAPIKeyEntry]{expiresAt: time.Now().Add(time.Minute)})
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/owner/sales", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			rec := httptest.NewRecorder()
+			ownerAPIKeyAuthMiddleware(tt.scope)(http.HandlerFunc(handler)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
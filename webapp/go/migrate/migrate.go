@@ -0,0 +1,283 @@
+// Package migrate はバージョン付きのスキーママイグレーションを適用・ロールバックする。
+//
+// 適用済みのマイグレーションは schema_migrations テーブルに、バージョン・名前・チェックサムとともに記録する。
+// 適用済みのマイグレーションの Up を書き換えるとチェックサムが合わなくなり、Up は何も適用せずにエラーを返す。
+// MySQL の DDL は暗黙にコミットされるので、1つのマイグレーションの途中で失敗した場合は手で戻す必要がある。
+// 複数のサーバーから同時に実行しても、GET_LOCK で1台ずつ順に適用する。
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	tableName   = "schema_migrations"
+	lockName    = "schema_migrations"
+	lockTimeout = 30 // 秒
+)
+
+var (
+	// ErrChecksumMismatch は適用済みのマイグレーションの内容が変わっている場合に返す
+	ErrChecksumMismatch = errors.New("migrate: checksum mismatch")
+	// ErrUnknownVersion はコードにないバージョンが適用済みになっている場合に返す
+	ErrUnknownVersion = errors.New("migrate: unknown applied version")
+	// ErrIrreversible は Down を持たないマイグレーションをロールバックしようとした場合に返す
+	ErrIrreversible = errors.New("migrate: migration is irreversible")
+)
+
+// Statement はマイグレーションで実行する1つのSQL
+// チェックサムには Query だけを使うので、実行時に決まる値は Args で渡す
+type Statement struct {
+	Query string
+	Args  []any
+}
+
+// SQL は Statement を作る
+func SQL(query string, args ...any) Statement {
+	return Statement{Query: query, Args: args}
+}
+
+type Migration struct {
+	// Version は適用する順番。一度使ったバージョンは変えないこと
+	Version int64
+	Name    string
+	Up      []Statement
+	// Down が空のマイグレーションはロールバックできない
+	Down []Statement
+}
+
+// Reversible は Down でロールバックできるかを返す
+func (m Migration) Reversible() bool {
+	return len(m.Down) > 0
+}
+
+// Checksum は Up のSQLから計算する
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	for _, s := range m.Up {
+		h.Write([]byte(strings.TrimSpace(s.Query)))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Record は schema_migrations の1行
+type Record struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Status はマイグレーションごとの適用状況
+type Status struct {
+	Migration Migration
+	// Applied が nil の場合は未適用
+	Applied *Record
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+// New は migrations をバージョン順に並べた Migrator を返す。バージョンが重複している場合はエラー
+func New(db *sqlx.DB, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migrate: version must be positive: %d", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migrate: duplicate version %d", m.Version)
+		}
+	}
+	return &Migrator{db: db, migrations: sorted}, nil
+}
+
+// Up は未適用のマイグレーションをすべて適用し、適用したものを返す
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+		pending, err := plan(m.migrations, records)
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			if err := run(ctx, conn, migration.Up); err != nil {
+				return fmt.Errorf("migrate: failed to apply %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := conn.ExecContext(
+				ctx,
+				`INSERT INTO `+tableName+` (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+				migration.Version, migration.Name, migration.Checksum(), time.Now(),
+			); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down は適用済みのマイグレーションを新しい順に steps 個ロールバックし、ロールバックしたものを返す
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+		targets, err := planDown(m.migrations, records, steps)
+		if err != nil {
+			return err
+		}
+		for _, migration := range targets {
+			if err := run(ctx, conn, migration.Down); err != nil {
+				return fmt.Errorf("migrate: failed to roll back %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := conn.ExecContext(ctx, `DELETE FROM `+tableName+` WHERE version = ?`, migration.Version); err != nil {
+				return err
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status はすべてのマイグレーションの適用状況をバージョン順に返す
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+		byVersion := map[int64]Record{}
+		for _, r := range records {
+			byVersion[r.Version] = r
+		}
+		for _, migration := range m.migrations {
+			s := Status{Migration: migration}
+			if r, ok := byVersion[migration.Version]; ok {
+				s.Applied = &r
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sqlx.Conn) error) error {
+	// GET_LOCK はコネクションごとのロックなので、すべて同じコネクションで実行する
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.GetContext(ctx, &locked, `SELECT GET_LOCK(?, ?)`, lockName, lockTimeout); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return errors.New("migrate: failed to acquire lock")
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), `SELECT RELEASE_LOCK(?)`, lockName)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+tableName+` (
+		version    BIGINT       NOT NULL,
+		name       VARCHAR(255) NOT NULL,
+		checksum   CHAR(64)     NOT NULL,
+		applied_at DATETIME(6)  NOT NULL,
+		PRIMARY KEY (version)
+	)`); err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+func (m *Migrator) records(ctx context.Context, conn *sqlx.Conn) ([]Record, error) {
+	records := []Record{}
+	if err := conn.SelectContext(ctx, &records, `SELECT * FROM `+tableName+` ORDER BY version`); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func run(ctx context.Context, conn *sqlx.Conn, statements []Statement) error {
+	for _, s := range statements {
+		if _, err := conn.ExecContext(ctx, s.Query, s.Args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plan は適用済みの records を検証し、未適用のマイグレーションを返す
+// 適用済みより古いバージョンが未適用で残っている場合も、バージョン順に適用する
+func plan(migrations []Migration, records []Record) ([]Migration, error) {
+	known := map[int64]Migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+	applied := map[int64]bool{}
+	for _, r := range records {
+		m, ok := known[r.Version]
+		if !ok {
+			return nil, fmt.Errorf("%w: %d_%s", ErrUnknownVersion, r.Version, r.Name)
+		}
+		if m.Checksum() != r.Checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, r.Version, r.Name)
+		}
+		applied[r.Version] = true
+	}
+
+	pending := []Migration{}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// planDown はロールバックするマイグレーションを新しい順に返す
+func planDown(migrations []Migration, records []Record, steps int) ([]Migration, error) {
+	if _, err := plan(migrations, records); err != nil {
+		return nil, err
+	}
+	known := map[int64]Migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+
+	targets := []Migration{}
+	for i := len(records) - 1; i >= 0 && len(targets) < steps; i-- {
+		// 途中までロールバックして止まらないよう、何も実行する前にすべて確かめる
+		m := known[records[i].Version]
+		if !m.Reversible() {
+			return nil, fmt.Errorf("%w: %d_%s has no down migration, restore the database from a backup instead", ErrIrreversible, m.Version, m.Name)
+		}
+		targets = append(targets, m)
+	}
+	return targets, nil
+}
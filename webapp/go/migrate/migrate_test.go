@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "create_users", Up: []Statement{SQL("CREATE TABLE users (id INT)")}, Down: []Statement{SQL("DROP TABLE users")}},
+	{Version: 2, Name: "add_name", Up: []Statement{SQL("ALTER TABLE users ADD name TEXT")}, Down: []Statement{SQL("ALTER TABLE users DROP name")}},
+	{Version: 3, Name: "backfill", Up: []Statement{SQL("UPDATE users SET name = ?", "x")}},
+}
+
+func record(m Migration) Record {
+	return Record{Version: m.Version, Name: m.Name, Checksum: m.Checksum()}
+}
+
+func versions(migrations []Migration) []int64 {
+	vs := []int64{}
+	for _, m := range migrations {
+		vs = append(vs, m.Version)
+	}
+	return vs
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(nil, []Migration{testMigrations[2], testMigrations[0], testMigrations[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(m.migrations); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("versions = %v, want sorted", got)
+	}
+
+	if _, err := New(nil, []Migration{testMigrations[0], testMigrations[0]}); err == nil {
+		t.Error("New() should fail for duplicate versions")
+	}
+	if _, err := New(nil, []Migration{{Version: 0}}); err == nil {
+		t.Error("New() should fail for non-positive version")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	m := testMigrations[2]
+	withOtherArgs := Migration{Version: 3, Up: []Statement{SQL("UPDATE users SET name = ?", "y")}}
+	if m.Checksum() != withOtherArgs.Checksum() {
+		t.Error("Checksum() should not depend on args")
+	}
+	changed := Migration{Version: 3, Up: []Statement{SQL("UPDATE users SET name = ''")}}
+	if m.Checksum() == changed.Checksum() {
+		t.Error("Checksum() should change when query changes")
+	}
+	split := Migration{Up: []Statement{SQL("CREATE TABLE a"), SQL("(id INT)")}}
+	joined := Migration{Up: []Statement{SQL("CREATE TABLE a(id INT)")}}
+	if split.Checksum() == joined.Checksum() {
+		t.Error("Checksum() should distinguish statement boundaries")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	pending, err := plan(testMigrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("pending = %v", got)
+	}
+
+	// 2回目は何も適用しない
+	all := []Record{record(testMigrations[0]), record(testMigrations[1]), record(testMigrations[2])}
+	if pending, err := plan(testMigrations, all); err != nil || len(pending) != 0 {
+		t.Errorf("plan() = %v, %v, want nothing", versions(pending), err)
+	}
+
+	// 古いバージョンが抜けていても適用する
+	pending, err = plan(testMigrations, []Record{record(testMigrations[1])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !reflect.DeepEqual(got, []int64{1, 3}) {
+		t.Errorf("pending = %v", got)
+	}
+
+	tampered := record(testMigrations[0])
+	tampered.Checksum = "changed"
+	if _, err := plan(testMigrations, []Record{tampered}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("plan() = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := plan(testMigrations, []Record{{Version: 99, Name: "future"}}); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("plan() = %v, want ErrUnknownVersion", err)
+	}
+}
+
+func TestPlanDown(t *testing.T) {
+	records := []Record{record(testMigrations[0]), record(testMigrations[1])}
+	targets, err := planDown(testMigrations, records, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(targets); !reflect.DeepEqual(got, []int64{2, 1}) {
+		t.Errorf("targets = %v, want newest first", got)
+	}
+
+	records = append(records, record(testMigrations[2]))
+	if _, err := planDown(testMigrations, records, 1); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("planDown() = %v, want ErrIrreversible", err)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/migrate"
+)
+
+// schemaMigrations は 1-schema.sql からの差分。追加するときは末尾に新しいバージョンで足し、適用済みのものは書き換えないこと
+// 1-schema.sql は schema_migrations も作り直すので、POST /api/initialize のたびに最初から適用される
+func schemaMigrations() []migrate.Migration {
+	return []migrate.Migration{
+		{
+			Version: 1,
+			Name:    "add_indexes",
+			Up: []migrate.Statement{
+				migrate.SQL("ALTER TABLE chairs ADD INDEX access_token_idx(access_token)"),
+				migrate.SQL("ALTER TABLE ride_statuses ADD INDEX ride_id_create_at_idx(ride_id, created_at DESC)"),
+				migrate.SQL("ALTER TABLE chair_locations ADD INDEX chair_id_create_at_idx(chair_id, created_at DESC)"),
+				migrate.SQL("ALTER TABLE rides ADD INDEX chair_id_updated_at_idx(chair_id, updated_at DESC)"),
+				migrate.SQL("ALTER TABLE rides ADD INDEX user_id_created_at_idx(user_id, created_at DESC)"),
+				migrate.SQL("ALTER TABLE coupons ADD INDEX used_by_idx(used_by)"),
+				migrate.SQL("ALTER TABLE ride_statuses ADD INDEX status_created_at_idx(status, created_at)"),
+			},
+			Down: []migrate.Statement{
+				migrate.SQL("ALTER TABLE chairs DROP INDEX access_token_idx"),
+				migrate.SQL("ALTER TABLE ride_statuses DROP INDEX ride_id_create_at_idx"),
+				migrate.SQL("ALTER TABLE chair_locations DROP INDEX chair_id_create_at_idx"),
+				migrate.SQL("ALTER TABLE rides DROP INDEX chair_id_updated_at_idx"),
+				migrate.SQL("ALTER TABLE rides DROP INDEX user_id_created_at_idx"),
+				migrate.SQL("ALTER TABLE coupons DROP INDEX used_by_idx"),
+				migrate.SQL("ALTER TABLE ride_statuses DROP INDEX status_created_at_idx"),
+			},
+		},
+		{
+			Version: 2,
+			Name:    "add_chairs_total_distance",
+			Up: []migrate.Statement{
+				migrate.SQL("ALTER TABLE chairs ADD total_distance INT DEFAULT 0"),
+				migrate.SQL("ALTER TABLE chairs ADD total_distance_updated_at DATETIME(6)"),
+			},
+			Down: []migrate.Statement{
+				migrate.SQL("ALTER TABLE chairs DROP total_distance"),
+				migrate.SQL("ALTER TABLE chairs DROP total_distance_updated_at"),
+			},
+		},
+		{
+			// 既存オーナーの chair_register_token をデフォルトの登録トークンとして移行する
+			// デフォルトトークンのIDにはオーナーIDをそのまま使う
+			Version: 3,
+			Name:    "add_chair_register_tokens",
+			Up: []migrate.Statement{
+				migrate.SQL("ALTER TABLE chairs ADD register_token_id VARCHAR(26) NULL"),
+				migrate.SQL(`INSERT INTO chair_register_tokens (id, owner_id, name, token, used_count, created_at)
+				 SELECT o.id, o.id, 'default', o.chair_register_token, (SELECT COUNT(*) FROM chairs c WHERE c.owner_id = o.id), o.created_at
+				 FROM owners o`),
+				migrate.SQL("UPDATE chairs SET register_token_id = owner_id WHERE register_token_id IS NULL"),
+			},
+			Down: []migrate.Statement{
+				migrate.SQL("DELETE FROM chair_register_tokens WHERE id = owner_id"),
+				migrate.SQL("ALTER TABLE chairs DROP register_token_id"),
+			},
+		},
+		{
+			Version: 4,
+			Name:    "add_rides_route_distance",
+			Up:      []migrate.Statement{migrate.SQL("ALTER TABLE rides ADD route_distance INT NULL")},
+			Down:    []migrate.Statement{migrate.SQL("ALTER TABLE rides DROP route_distance")},
+		},
+		{
+			Version: 5,
+			Name:    "add_rides_user_evaluation",
+			Up:      []migrate.Statement{migrate.SQL("ALTER TABLE rides ADD user_evaluation INT NULL")},
+			Down:    []migrate.Statement{migrate.SQL("ALTER TABLE rides DROP user_evaluation")},
+		},
+		{
+			// 経由地への到着は status を CARRYING のまま waypoint_id 付きで記録する
+			Version: 6,
+			Name:    "add_ride_statuses_waypoint_id",
+			Up:      []migrate.Statement{migrate.SQL("ALTER TABLE ride_statuses ADD waypoint_id VARCHAR(26) NULL")},
+			Down:    []migrate.Statement{migrate.SQL("ALTER TABLE ride_statuses DROP waypoint_id")},
+		},
+		{
+			// 既存のアクセストークンをそのままセッションとして移行する。セッションIDには利用者のIDを使う
+			Version: 7,
+			Name:    "migrate_access_tokens_to_sessions",
+			Up: []migrate.Statement{
+				migrate.SQL(`INSERT INTO sessions (id, token, role, principal_id, created_at, last_seen_at, expires_at)
+				 SELECT id, access_token, 'app', id, created_at, NOW(6), NOW(6) + INTERVAL ? MICROSECOND FROM users`, time.Duration(appConfig.Session.TTL).Microseconds()),
+				migrate.SQL(`INSERT INTO sessions (id, token, role, principal_id, created_at, last_seen_at, expires_at)
+				 SELECT id, access_token, 'owner', id, created_at, NOW(6), NOW(6) + INTERVAL ? MICROSECOND FROM owners`, time.Duration(appConfig.Session.TTL).Microseconds()),
+				migrate.SQL(`INSERT INTO sessions (id, token, role, principal_id, created_at, last_seen_at, expires_at)
+				 SELECT id, access_token, 'chair', id, created_at, NOW(6), NOW(6) + INTERVAL ? MICROSECOND FROM chairs`, time.Duration(appConfig.Session.TTL).Microseconds()),
+			},
+			Down: []migrate.Statement{
+				migrate.SQL("DELETE FROM sessions WHERE id = principal_id"),
+			},
+		},
+		{
+			// 平文で保存されているアクセストークンをハッシュ化する。クライアントが持っている平文のトークンはそのまま使える
+			// MySQL の SHA2 は hashAccessToken と同じく小文字の16進数を返す。updated_at は変えない
+			// ハッシュは元に戻せないので Down はない。migrate down はここまで戻ろうとすると何もせずにエラーになる
+			Version: 8,
+			Name:    "hash_access_tokens",
+			Up: []migrate.Statement{
+				migrate.SQL(`UPDATE users SET access_token = CONCAT(?, SHA2(access_token, 256)), updated_at = updated_at WHERE access_token NOT LIKE ?`, accessTokenHashPrefix, accessTokenHashPrefix+"%"),
+				migrate.SQL(`UPDATE owners SET access_token = CONCAT(?, SHA2(access_token, 256)), updated_at = updated_at WHERE access_token NOT LIKE ?`, accessTokenHashPrefix, accessTokenHashPrefix+"%"),
+				migrate.SQL(`UPDATE chairs SET access_token = CONCAT(?, SHA2(access_token, 256)), updated_at = updated_at WHERE access_token NOT LIKE ?`, accessTokenHashPrefix, accessTokenHashPrefix+"%"),
+				migrate.SQL(`UPDATE sessions SET token = CONCAT(?, SHA2(token, 256)) WHERE token NOT LIKE ?`, accessTokenHashPrefix, accessTokenHashPrefix+"%"),
+			},
+		},
+		{
+			// 既存の利用者は、今のアクセストークンを再ログイン用の資格情報にする。ハッシュ化した後に実行すること
+			// 後から登録した利用者の資格情報と区別できないので Down はない。migrate down はここまで戻ろうとすると何もせずにエラーになる
+			Version: 9,
+			Name:    "migrate_access_tokens_to_credentials",
+			Up: []migrate.Statement{
+				migrate.SQL(`INSERT INTO principal_credentials (role, principal_id, token, created_at) SELECT 'app', id, access_token, created_at FROM users`),
+				migrate.SQL(`INSERT INTO principal_credentials (role, principal_id, token, created_at) SELECT 'owner', id, access_token, created_at FROM owners`),
+				migrate.SQL(`INSERT INTO principal_credentials (role, principal_id, token, created_at) SELECT 'chair', id, access_token, created_at FROM chairs`),
+			},
+		},
+		{
+			// ハッシュをそのまま検索に使わないよう、先頭部分を別のカラムに持って候補の行を引く。ハッシュ化した後に実行すること
+			Version: 10,
+			Name:    "add_token_prefixes",
+			Up: []migrate.Statement{
+				migrate.SQL("ALTER TABLE sessions ADD token_prefix VARCHAR(16) NOT NULL DEFAULT '' AFTER token, ADD INDEX token_prefix_idx(token_prefix)"),
+				migrate.SQL("UPDATE sessions SET token_prefix = SUBSTRING(token, ?, ?)", len(accessTokenHashPrefix)+1, accessTokenLookupPrefixLength),
+				migrate.SQL("ALTER TABLE principal_credentials ADD token_prefix VARCHAR(16) NOT NULL DEFAULT '' AFTER principal_id, ADD INDEX token_prefix_idx(token_prefix)"),
+				migrate.SQL("UPDATE principal_credentials SET token_prefix = SUBSTRING(token, ?, ?)", len(accessTokenHashPrefix)+1, accessTokenLookupPrefixLength),
+			},
+			Down: []migrate.Statement{
+				migrate.SQL("ALTER TABLE sessions DROP INDEX token_prefix_idx, DROP token_prefix"),
+				migrate.SQL("ALTER TABLE principal_credentials DROP INDEX token_prefix_idx, DROP token_prefix"),
+			},
+		},
+	}
+}
+
+// migrateUp は未適用のマイグレーションを適用する。すべて適用済みなら何もしない
+func migrateUp(ctx context.Context) error {
+	migrator, err := migrate.New(db, schemaMigrations())
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		slog.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	return err
+}
+
+// runMigrateCommand は isuride migrate up|down [N]|status を実行し、終了コードを返す
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: isuride [flags] migrate up|down [N]|status")
+		return 2
+	}
+	ctx := context.Background()
+	connectDB()
+
+	migrator, err := migrate.New(db, schemaMigrations())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of steps: %s\n", args[1])
+				return 2
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied != nil {
+				state = "applied at " + s.Applied.AppliedAt.Format("2006-01-02 15:04:05")
+				if s.Applied.Checksum != s.Migration.Checksum() {
+					state += " (checksum mismatch)"
+				}
+			}
+			if !s.Migration.Reversible() {
+				state += " (irreversible)"
+			}
+			fmt.Printf("%d_%s\t%s\n", s.Migration.Version, s.Migration.Name, state)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command: %s\n", args[0])
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/migrate"
+)
+
+func TestSchemaMigrations(t *testing.T) {
+	migrations := schemaMigrations()
+	if _, err := migrate.New(nil, migrations); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		// 順番に足していくので、バージョンは1からの連番になる
+		if m.Version != int64(i+1) {
+			t.Errorf("migrations[%d].Version = %d, want %d", i, m.Version, i+1)
+		}
+		if m.Name == "" || len(m.Up) == 0 {
+			t.Errorf("migrations[%d] = %+v, want name and up", i, m)
+		}
+	}
+
+	// 実行時の設定で変わる値は Args で渡すので、チェックサムは設定によらない
+	saved := appConfig.Session.TTL
+	defer func() { appConfig.Session.TTL = saved }()
+	checksum := migrations[6].Checksum()
+	appConfig.Session.TTL *= 2
+	if got := schemaMigrations()[6].Checksum(); got != checksum {
+		t.Error("checksum should not depend on session TTL")
+	}
+}
+
+func TestSchemaMigrationsDownStopsAtIrreversible(t *testing.T) {
+	migrations := schemaMigrations()
+	for _, m := range migrations {
+		if m.Version == 8 || m.Version == 9 {
+			if m.Reversible() {
+				t.Errorf("%d_%s should be irreversible", m.Version, m.Name)
+			}
+		} else if !m.Reversible() {
+			t.Errorf("%d_%s should have a down migration", m.Version, m.Name)
+		}
+	}
+
+	applied := func(f *fakeDB) {
+		f.returns(`SELECT GET_LOCK`, 1)
+		f.exec(`SELECT RELEASE_LOCK`, 0)
+		f.exec(`CREATE TABLE IF NOT EXISTS schema_migrations`, 0)
+		records := []any{}
+		for _, m := range migrations {
+			records = append(records, migrate.Record{Version: m.Version, Name: m.Name, Checksum: m.Checksum(), AppliedAt: time.Now()})
+		}
+		f.returns(`SELECT \* FROM schema_migrations`, records...)
+	}
+
+	t.Run("reversible", func(t *testing.T) {
+		f := newFakeDB(t)
+		applied(f)
+		f.exec(`^ALTER TABLE`, 0)
+		f.exec(`^DELETE FROM schema_migrations`, 1).with(10)
+
+		migrator, err := migrate.New(db, migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rolledBack, err := migrator.Down(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rolledBack) != 1 || rolledBack[0].Version != 10 {
+			t.Errorf("rolled back %+v, want 10", rolledBack)
+		}
+	})
+
+	t.Run("irreversible", func(t *testing.T) {
+		f := newFakeDB(t)
+		applied(f)
+
+		migrator, err := migrate.New(db, migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 10 は戻せるが 9 は戻せないので、10 も戻さずにエラーにする
+		rolledBack, err := migrator.Down(context.Background(), 2)
+		if !errors.Is(err, migrate.ErrIrreversible) {
+			t.Fatalf("Down() = %v, want ErrIrreversible", err)
+		}
+		if !strings.Contains(err.Error(), "9_migrate_access_tokens_to_credentials") {
+			t.Errorf("error %q should name the irreversible migration", err)
+		}
+		if len(rolledBack) != 0 || len(f.executed(`^ALTER TABLE|^DELETE`)) != 0 {
+			t.Errorf("Down() should not roll back anything, rolled back %+v", rolledBack)
+		}
+	})
+}
@@ -6,21 +6,23 @@ import (
 )
 
 type Chair struct {
-	ID                     string       `db:"id"`
-	OwnerID                string       `db:"owner_id"`
-	Name                   string       `db:"name"`
-	Model                  string       `db:"model"`
-	IsActive               bool         `db:"is_active"`
-	AccessToken            string       `db:"access_token"`
-	CreatedAt              time.Time    `db:"created_at"`
-	UpdatedAt              time.Time    `db:"updated_at"`
-	TotalDistance          int          `db:"total_distance"`
-	TotalDistanceUpdatedAt sql.NullTime `db:"total_distance_updated_at"`
+	ID                     string         `db:"id"`
+	OwnerID                string         `db:"owner_id"`
+	Name                   string         `db:"name"`
+	Model                  string         `db:"model"`
+	IsActive               bool           `db:"is_active"`
+	AccessToken            string         `db:"access_token"`
+	CreatedAt              time.Time      `db:"created_at"`
+	UpdatedAt              time.Time      `db:"updated_at"`
+	TotalDistance          int            `db:"total_distance"`
+	TotalDistanceUpdatedAt sql.NullTime   `db:"total_distance_updated_at"`
+	RegisterTokenID        sql.NullString `db:"register_token_id"`
 }
 
 type ChairModel struct {
-	Name  string `db:"name"`
-	Speed int    `db:"speed"`
+	Name      string     `db:"name"`
+	Speed     int        `db:"speed"`
+	RetiredAt *time.Time `db:"retired_at"`
 }
 
 type ChairLocation struct {
@@ -60,6 +62,10 @@ type Ride struct {
 	Evaluation           *int           `db:"evaluation"`
 	CreatedAt            time.Time      `db:"created_at"`
 	UpdatedAt            time.Time      `db:"updated_at"`
+	// RouteDistance は経由地がある場合の走行距離。経由地がなければ NULL
+	RouteDistance *int `db:"route_distance"`
+	// UserEvaluation は椅子からユーザーへの評価
+	UserEvaluation *int `db:"user_evaluation"`
 }
 
 type RideStatus struct {
@@ -69,6 +75,8 @@ type RideStatus struct {
 	CreatedAt   time.Time  `db:"created_at"`
 	AppSentAt   *time.Time `db:"app_sent_at"`
 	ChairSentAt *time.Time `db:"chair_sent_at"`
+	// WaypointID は経由地への到着の記録の場合に入る
+	WaypointID *string `db:"waypoint_id"`
 }
 
 type Owner struct {
@@ -80,6 +88,18 @@ type Owner struct {
 	UpdatedAt          time.Time `db:"updated_at"`
 }
 
+type ChairRegisterToken struct {
+	ID        string     `db:"id"`
+	OwnerID   string     `db:"owner_id"`
+	Name      string     `db:"name"`
+	Token     string     `db:"token"`
+	MaxUses   *int       `db:"max_uses"`
+	UsedCount int        `db:"used_count"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
 type Coupon struct {
 	UserID    string    `db:"user_id"`
 	Code      string    `db:"code"`
@@ -87,3 +107,70 @@ type Coupon struct {
 	CreatedAt time.Time `db:"created_at"`
 	UsedBy    *string   `db:"used_by"`
 }
+
+type RideReservation struct {
+	ID                   string     `db:"id"`
+	UserID               string     `db:"user_id"`
+	PickupLatitude       int        `db:"pickup_latitude"`
+	PickupLongitude      int        `db:"pickup_longitude"`
+	DestinationLatitude  int        `db:"destination_latitude"`
+	DestinationLongitude int        `db:"destination_longitude"`
+	ScheduledAt          time.Time  `db:"scheduled_at"`
+	RouteDistance        *int       `db:"route_distance"`
+	Status               string     `db:"status"`
+	ReleasedAt           *time.Time `db:"released_at"`
+	RideID               *string    `db:"ride_id"`
+	CreatedAt            time.Time  `db:"created_at"`
+	UpdatedAt            time.Time  `db:"updated_at"`
+}
+
+type RideWaypoint struct {
+	ID        string     `db:"id"`
+	RideID    string     `db:"ride_id"`
+	Position  int        `db:"position"`
+	Latitude  int        `db:"latitude"`
+	Longitude int        `db:"longitude"`
+	ArrivedAt *time.Time `db:"arrived_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+type UserPlace struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	Name      string    `db:"name"`
+	Latitude  int       `db:"latitude"`
+	Longitude int       `db:"longitude"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type Session struct {
+	ID    string `db:"id"`
+	Token string `db:"token"`
+	// TokenPrefix は Token の検索に使う先頭部分
+	TokenPrefix string     `db:"token_prefix"`
+	Role        string     `db:"role"`
+	PrincipalID string     `db:"principal_id"`
+	CreatedAt   time.Time  `db:"created_at"`
+	LastSeenAt  time.Time  `db:"last_seen_at"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+	RevokedAt   *time.Time `db:"revoked_at"`
+}
+
+type PrincipalCredential struct {
+	Role        string    `db:"role"`
+	PrincipalID string    `db:"principal_id"`
+	TokenPrefix string    `db:"token_prefix"`
+	Token       string    `db:"token"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+type OwnerAPIKey struct {
+	ID        string     `db:"id"`
+	OwnerID   string     `db:"owner_id"`
+	Name      string     `db:"name"`
+	KeyHash   string     `db:"key_hash"`
+	KeyPrefix string     `db:"key_prefix"`
+	Scopes    string     `db:"scopes"`
+	CreatedAt time.Time  `db:"created_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/auth"
+	"github.com/oklog/ulid/v2"
+)
+
+// オーナーのAPIキーの先頭につける文字列。セッションのトークンと区別するのに使う
+const ownerAPIKeyPrefix = "isk_"
+
+const (
+	// ownerAPIKeyScopeSalesRead は売上の参照だけを許可する
+	ownerAPIKeyScopeSalesRead = "sales:read"
+	// ownerAPIKeyScopeFleet は椅子の参照・更新と椅子登録トークンの管理を許可する
+	ownerAPIKeyScopeFleet = "fleet"
+)
+
+var ownerAPIKeyScopes = map[string]bool{
+	ownerAPIKeyScopeSalesRead: true,
+	ownerAPIKeyScopeFleet:     true,
+}
+
+type ownerAPIKeyEntry struct {
+	key   OwnerAPIKey
+	owner Owner
+}
+
+// ownerAPIKeyCache はAPIキーのハッシュからキーとオーナーを引くキャッシュ
+var ownerAPIKeyCache = newTokenCache(func(ctx context.Context, keyHash string) (*ownerAPIKeyEntry, error) {
+	entry := &ownerAPIKeyEntry{}
+	if err := db.GetContext(ctx, &entry.key, `SELECT * FROM owner_api_keys WHERE key_hash = ? AND revoked_at IS NULL`, keyHash); err != nil {
+		return nil, err
+	}
+	if err := db.GetContext(ctx, &entry.owner, `SELECT * FROM owners WHERE id = ?`, entry.key.OwnerID); err != nil {
+		return nil, err
+	}
+	return entry, nil
+})
+
+// hashOwnerAPIKey はDBに保存するAPIキーのハッシュを返す。キーは十分にランダムなのでソルトは使わない
+func hashOwnerAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (k *OwnerAPIKey) scopes() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// APIKeyFrom はAPIキーで認証した場合にそのキーを返す
+func APIKeyFrom(ctx context.Context) (*OwnerAPIKey, bool) {
+	return auth.From[OwnerAPIKey](ctx)
+}
+
+// ownerAPIKeyAuthMiddleware はセッションに加えて、scope を持つAPIキーでの認証も受け付ける
+// APIキーは Authorization: Bearer で渡す
+func ownerAPIKeyAuthMiddleware(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		sessionAuthed := ownerAuthMiddleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := bearerToken(r)
+			if !ok || !strings.HasPrefix(key, ownerAPIKeyPrefix) {
+				sessionAuthed.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			entry, found, err := ownerAPIKeyCache.Get(ctx, hashOwnerAPIKey(key))
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if !found {
+				writeError(w, http.StatusUnauthorized, errors.New("invalid api key"))
+				return
+			}
+			if !slices.Contains(entry.key.scopes(), scope) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("api key does not have the %s scope", scope))
+				return
+			}
+
+			owner := entry.owner
+			apiKey := entry.key
+			ctx = auth.WithPrincipal(ctx, auth.Principal{Role: auth.RoleOwner, ID: owner.ID}, &owner)
+			ctx = auth.With(ctx, &apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type ownerAPIKeyResponse struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	KeyPrefix string   `json:"key_prefix"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt int64    `json:"created_at"`
+	RevokedAt *int64   `json:"revoked_at,omitempty"`
+}
+
+func toOwnerAPIKeyResponse(key OwnerAPIKey) ownerAPIKeyResponse {
+	res := ownerAPIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		KeyPrefix: key.KeyPrefix,
+		Scopes:    key.scopes(),
+		CreatedAt: key.CreatedAt.UnixMilli(),
+	}
+	if key.RevokedAt != nil {
+		t := key.RevokedAt.UnixMilli()
+		res.RevokedAt = &t
+	}
+	return res
+}
+
+type ownerGetAPIKeysResponse struct {
+	APIKeys []ownerAPIKeyResponse `json:"api_keys"`
+}
+
+func ownerGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	keys := []OwnerAPIKey{}
+	if err := db.SelectContext(ctx, &keys, `SELECT * FROM owner_api_keys WHERE owner_id = ? ORDER BY created_at`, owner.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := ownerGetAPIKeysResponse{APIKeys: []ownerAPIKeyResponse{}}
+	for _, key := range keys {
+		res.APIKeys = append(res.APIKeys, toOwnerAPIKeyResponse(key))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type ownerPostAPIKeysRequest struct {
+	Name   string   `json:"name" validate:"required,max=30"`
+	Scopes []string `json:"scopes" validate:"required"`
+}
+
+type ownerPostAPIKeysResponse struct {
+	ownerAPIKeyResponse
+	// Key は発行時にだけ返す。DBにはハッシュしか保存しない
+	Key string `json:"key"`
+}
+
+func ownerPostAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	req := &ownerPostAPIKeysRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !ownerAPIKeyScopes[scope] {
+			writeError(w, http.StatusBadRequest, newFieldError("scopes", "oneof", fmt.Sprintf("unknown scope %q", scope)))
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	plainKey := ownerAPIKeyPrefix + secureRandomStr(32)
+	key := OwnerAPIKey{
+		ID:        ulid.Make().String(),
+		OwnerID:   owner.ID,
+		Name:      req.Name,
+		KeyHash:   hashOwnerAPIKey(plainKey),
+		KeyPrefix: plainKey[:len(ownerAPIKeyPrefix)+8],
+		Scopes:    strings.Join(scopes, ","),
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.NamedExecContext(
+		ctx,
+		`INSERT INTO owner_api_keys (id, owner_id, name, key_hash, key_prefix, scopes, created_at) VALUES (:id, :owner_id, :name, :key_hash, :key_prefix, :scopes, :created_at)`,
+		key,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &ownerPostAPIKeysResponse{
+		ownerAPIKeyResponse: toOwnerAPIKeyResponse(key),
+		Key:                 plainKey,
+	})
+}
+
+func ownerDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	keyID := r.PathValue("api_key_id")
+
+	key := OwnerAPIKey{}
+	if err := db.GetContext(ctx, &key, `SELECT * FROM owner_api_keys WHERE id = ? AND owner_id = ? AND revoked_at IS NULL`, keyID, owner.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("api key not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE owner_api_keys SET revoked_at = ? WHERE id = ?`, time.Now(), key.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	invalidateTokenCache("api_key", key.KeyHash)
+
+	w.WriteHeader(http.StatusNoContent)
+}
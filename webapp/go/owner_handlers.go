@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/oklog/ulid/v2"
@@ -16,7 +15,7 @@ const (
 )
 
 type ownerPostOwnersRequest struct {
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required,max=30"`
 }
 
 type ownerPostOwnersResponse struct {
@@ -31,40 +30,67 @@ func ownerPostOwners(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	if req.Name == "" {
-		writeError(w, http.StatusBadRequest, errors.New("some of required fields(name) are empty"))
-		return
-	}
 
 	ownerID := ulid.Make().String()
 	accessToken := secureRandomStr(32)
+	hashedToken := hashAccessToken(accessToken)
 	chairRegisterToken := secureRandomStr(32)
 
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
 	now := time.Now()
-	_, err := db.ExecContext(
+	_, err = tx.ExecContext(
 		ctx,
 		"INSERT INTO owners (id, name, access_token, chair_register_token, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		ownerID, req.Name, accessToken, chairRegisterToken, now, now,
+		ownerID, req.Name, hashedToken, chairRegisterToken, now, now,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 登録時に発行するトークンはデフォルトの椅子登録トークンとして扱う
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO chair_register_tokens (id, owner_id, name, token, created_at) VALUES (?, ?, ?, ?, ?)",
+		ownerID, ownerID, "default", chairRegisterToken, now,
 	)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+
+	session, err := createSession(ctx, tx, sessionRoleOwner, ownerID, hashedToken, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := createPrincipalCredential(ctx, tx, sessionRoleOwner, ownerID, hashedToken, now); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
 	newOwner := Owner{
 		ID:                 ownerID,
 		Name:               req.Name,
-		AccessToken:        accessToken,
+		AccessToken:        hashedToken,
 		ChairRegisterToken: chairRegisterToken,
 		CreatedAt:          now,
 		UpdatedAt:          now,
 	}
-	ownerTokenCache.Store(accessToken, newOwner)
+	ownerTokenCache.Store(newOwner.ID, newOwner)
+	cacheSession(session)
 
-	http.SetCookie(w, &http.Cookie{
-		Path:  "/",
-		Name:  "owner_session",
-		Value: accessToken,
-	})
+	setSessionCookie(w, sessionRoleOwner, accessToken)
 
 	writeJSON(w, http.StatusCreated, &ownerPostOwnersResponse{
 		ID:                 ownerID,
@@ -91,26 +117,22 @@ type ownerGetSalesResponse struct {
 
 func ownerGetSales(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	since := time.Unix(0, 0)
-	until := time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
-	if r.URL.Query().Get("since") != "" {
-		parsed, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
-			return
-		}
-		since = time.UnixMilli(parsed)
-	}
-	if r.URL.Query().Get("until") != "" {
-		parsed, err := strconv.ParseInt(r.URL.Query().Get("until"), 10, 64)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
-			return
-		}
-		until = time.UnixMilli(parsed)
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
 	}
 
-	owner := r.Context().Value("owner").(*Owner)
+	since, err := parseQueryUnixMilli(r, "since", time.Unix(0, 0))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	until, err := parseQueryUnixMilli(r, "until", time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	tx, err := db.Beginx()
 	if err != nil {
@@ -170,7 +192,7 @@ func sumSales(rides []Ride) int {
 }
 
 func calculateSale(ride Ride) int {
-	return calculateFare(ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
+	return calculateFareByDistance(rideDistance(&ride), 0)
 }
 
 type chairWithDetail struct {
@@ -198,6 +220,8 @@ type ownerGetChairResponseChair struct {
 	RegisteredAt           int64  `json:"registered_at"`
 	TotalDistance          int    `json:"total_distance"`
 	TotalDistanceUpdatedAt *int64 `json:"total_distance_updated_at,omitempty"`
+	// EvaluationTags は評価でつけられたタグごとの件数
+	EvaluationTags map[string]int `json:"evaluation_tags"`
 }
 
 type TotalDistanceInfo struct {
@@ -206,6 +230,8 @@ type TotalDistanceInfo struct {
 	TotalDistanceUpdatedAt sql.NullTime `db:"total_distance_updated_at"`
 }
 
+// getTotalDistance は chair_locations から総移動距離を集計する
+// chair_locations が間引かれる前提のない initialize 時の再計算にだけ使うこと
 func getTotalDistance(id string) (TotalDistanceInfo, error) {
 	query := `
 				WITH location_distances AS (
@@ -239,7 +265,11 @@ func getTotalDistance(id string) (TotalDistanceInfo, error) {
 
 func ownerGetChairs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	owner := ctx.Value("owner").(*Owner)
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
 
 	var chairs []Chair
 	query := "SELECT * FROM chairs WHERE owner_id = ?"
@@ -247,27 +277,271 @@ func ownerGetChairs(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	tagCounts, err := getEvaluationTagCounts(ctx, owner.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	res := ownerGetChairResponse{}
 
+	// chair_locations は保持期間を過ぎると間引かれるので、総移動距離は chairs に積算した値を使う
 	for _, chair := range chairs {
-		distanceInfo, err := getTotalDistance(chair.ID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, err)
-			return
-		}
+		totalDistance, totalDistanceUpdatedAt := getChairTotalDistance(&chair)
 		c := ownerGetChairResponseChair{
-			ID:            chair.ID,
-			Name:          chair.Name,
-			Model:         chair.Model,
-			Active:        chair.IsActive,
-			RegisteredAt:  chair.CreatedAt.UnixMilli(),
-			TotalDistance: distanceInfo.TotalDistance,
+			ID:             chair.ID,
+			Name:           chair.Name,
+			Model:          chair.Model,
+			Active:         chair.IsActive,
+			RegisteredAt:   chair.CreatedAt.UnixMilli(),
+			TotalDistance:  totalDistance,
+			EvaluationTags: map[string]int{},
 		}
-		if distanceInfo.TotalDistanceUpdatedAt.Valid {
-			t := distanceInfo.TotalDistanceUpdatedAt.Time.UnixMilli()
+		if counts, ok := tagCounts[chair.ID]; ok {
+			c.EvaluationTags = counts
+		}
+		if totalDistanceUpdatedAt.Valid {
+			t := totalDistanceUpdatedAt.Time.UnixMilli()
 			c.TotalDistanceUpdatedAt = &t
 		}
 		res.Chairs = append(res.Chairs, c)
 	}
 	writeJSON(w, http.StatusOK, res)
 }
+
+type ownerGetChairRegisterTokensResponse struct {
+	Tokens []ownerChairRegisterToken `json:"tokens"`
+}
+
+type ownerChairRegisterToken struct {
+	ID        string                         `json:"id"`
+	Name      string                         `json:"name"`
+	Token     string                         `json:"token"`
+	MaxUses   *int                           `json:"max_uses,omitempty"`
+	UsedCount int                            `json:"used_count"`
+	ExpiresAt *int64                         `json:"expires_at,omitempty"`
+	RevokedAt *int64                         `json:"revoked_at,omitempty"`
+	CreatedAt int64                          `json:"created_at"`
+	Chairs    []ownerChairRegisterTokenChair `json:"chairs"`
+}
+
+type ownerChairRegisterTokenChair struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	RegisteredAt int64  `json:"registered_at"`
+}
+
+func toOwnerChairRegisterToken(token ChairRegisterToken) ownerChairRegisterToken {
+	res := ownerChairRegisterToken{
+		ID:        token.ID,
+		Name:      token.Name,
+		Token:     token.Token,
+		MaxUses:   token.MaxUses,
+		UsedCount: token.UsedCount,
+		CreatedAt: token.CreatedAt.UnixMilli(),
+		Chairs:    []ownerChairRegisterTokenChair{},
+	}
+	if token.ExpiresAt != nil {
+		t := token.ExpiresAt.UnixMilli()
+		res.ExpiresAt = &t
+	}
+	if token.RevokedAt != nil {
+		t := token.RevokedAt.UnixMilli()
+		res.RevokedAt = &t
+	}
+	return res
+}
+
+func ownerGetChairRegisterTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	tokens := []ChairRegisterToken{}
+	if err := db.SelectContext(ctx, &tokens, "SELECT * FROM chair_register_tokens WHERE owner_id = ? ORDER BY created_at", owner.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	chairs := []Chair{}
+	if err := db.SelectContext(ctx, &chairs, "SELECT * FROM chairs WHERE owner_id = ? ORDER BY created_at", owner.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	chairsByTokenID := map[string][]ownerChairRegisterTokenChair{}
+	for _, chair := range chairs {
+		if !chair.RegisterTokenID.Valid {
+			continue
+		}
+		chairsByTokenID[chair.RegisterTokenID.String] = append(chairsByTokenID[chair.RegisterTokenID.String], ownerChairRegisterTokenChair{
+			ID:           chair.ID,
+			Name:         chair.Name,
+			RegisteredAt: chair.CreatedAt.UnixMilli(),
+		})
+	}
+
+	res := ownerGetChairRegisterTokensResponse{Tokens: []ownerChairRegisterToken{}}
+	for _, token := range tokens {
+		t := toOwnerChairRegisterToken(token)
+		if c, ok := chairsByTokenID[token.ID]; ok {
+			t.Chairs = c
+		}
+		res.Tokens = append(res.Tokens, t)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type ownerPostChairRegisterTokensRequest struct {
+	Name      string `json:"name" validate:"required,max=30"`
+	MaxUses   *int   `json:"max_uses" validate:"min=1"`
+	ExpiresAt *int64 `json:"expires_at"`
+}
+
+func ownerPostChairRegisterTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	req := &ownerPostChairRegisterTokensRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := time.UnixMilli(*req.ExpiresAt)
+		if !t.After(now) {
+			writeError(w, http.StatusBadRequest, newFieldError("expires_at", "future", "expires_at must be in the future"))
+			return
+		}
+		expiresAt = &t
+	}
+
+	token := ChairRegisterToken{
+		ID:        ulid.Make().String(),
+		OwnerID:   owner.ID,
+		Name:      req.Name,
+		Token:     secureRandomStr(32),
+		MaxUses:   req.MaxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if _, err := db.ExecContext(
+		ctx,
+		"INSERT INTO chair_register_tokens (id, owner_id, name, token, max_uses, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		token.ID, token.OwnerID, token.Name, token.Token, token.MaxUses, token.ExpiresAt, token.CreatedAt,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toOwnerChairRegisterToken(token))
+}
+
+func ownerDeleteChairRegisterToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	tokenID := r.PathValue("token_id")
+
+	result, err := db.ExecContext(
+		ctx,
+		"UPDATE chair_register_tokens SET revoked_at = ? WHERE id = ? AND owner_id = ? AND revoked_at IS NULL",
+		time.Now(), tokenID, owner.ID,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	} else if count == 0 {
+		writeError(w, http.StatusNotFound, errors.New("chair register token not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ownerPatchChairRequest struct {
+	Name  *string `json:"name" validate:"min=1,max=30"`
+	Model *string `json:"model" validate:"min=1"`
+}
+
+func ownerPatchChair(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	chairID := r.PathValue("chair_id")
+
+	req := &ownerPatchChairRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	chair := &Chair{}
+	if err := tx.GetContext(ctx, chair, "SELECT * FROM chairs WHERE id = ? AND owner_id = ? FOR UPDATE", chairID, owner.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("chair not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if req.Name != nil {
+		chair.Name = *req.Name
+	}
+	if req.Model != nil && *req.Model != chair.Model {
+		if err := validateChairModel(ctx, tx, *req.Model); err != nil {
+			if errors.Is(err, errUnknownChairModel) || errors.Is(err, errRetiredChairModel) {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		chair.Model = *req.Model
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE chairs SET name = ?, model = ? WHERE id = ?", chair.Name, chair.Model, chair.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 認証キャッシュに保持している椅子情報は消して、次のリクエストでDBから読み直させる
+	invalidateTokenCache("chair", chair.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
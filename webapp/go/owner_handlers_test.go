@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOwnerChairRegisterTokens(t *testing.T) {
+	owner := &Owner{ID: "owner1", Name: "owner"}
+	now := time.Now()
+
+	t.Run("list", func(t *testing.T) {
+		runHandlerTests(t, ownerGetChairRegisterTokens, []handlerTest{
+			{
+				name: "with chairs",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM chair_register_tokens WHERE owner_id = \?`,
+						ChairRegisterToken{ID: "token1", OwnerID: owner.ID, Name: "default", Token: "t1", UsedCount: 1, CreatedAt: now},
+						ChairRegisterToken{ID: "token2", OwnerID: owner.ID, Name: "spare", Token: "t2", RevokedAt: &now, CreatedAt: now},
+					).with(owner.ID)
+					f.returns(`FROM chairs WHERE owner_id = \?`, Chair{ID: "chair1", OwnerID: owner.ID, Name: "chair", Model: "m", CreatedAt: now, RegisterTokenID: sqlNullString("token1")})
+				},
+				req:        asOwner(newRequest(http.MethodGet, "/api/owner/chair-register-tokens", ""), owner),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					res := decodeJSON[ownerGetChairRegisterTokensResponse](t, body)
+					if len(res.Tokens) != 2 {
+						t.Fatalf("tokens = %+v, want 2", res.Tokens)
+					}
+					if len(res.Tokens[0].Chairs) != 1 || res.Tokens[0].Chairs[0].ID != "chair1" {
+						t.Errorf("tokens[0].chairs = %+v, want chair1", res.Tokens[0].Chairs)
+					}
+					if res.Tokens[1].RevokedAt == nil || len(res.Tokens[1].Chairs) != 0 {
+						t.Errorf("tokens[1] = %+v, want revoked without chairs", res.Tokens[1])
+					}
+				},
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodGet, "/api/owner/chair-register-tokens", ""),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+
+	t.Run("create", func(t *testing.T) {
+		newPost := func(body string) *http.Request {
+			return asOwner(newRequest(http.MethodPost, "/api/owner/chair-register-tokens", body), owner)
+		}
+		runHandlerTests(t, ownerPostChairRegisterTokens, []handlerTest{
+			{
+				name: "created",
+				setup: func(f *fakeDB) {
+					f.exec(`^INSERT INTO chair_register_tokens`, 1)
+				},
+				req:        newPost(`{"name":"spare","max_uses":3}`),
+				wantStatus: http.StatusCreated,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					res := decodeJSON[ownerChairRegisterToken](t, body)
+					if res.Name != "spare" || res.Token == "" || res.MaxUses == nil || *res.MaxUses != 3 || res.ExpiresAt != nil {
+						t.Errorf("response = %+v", res)
+					}
+					inserts := f.executed(`^INSERT INTO chair_register_tokens`)
+					if len(inserts) != 1 || inserts[0].Args[1] != owner.ID || inserts[0].Args[3] != res.Token {
+						t.Errorf("INSERT INTO chair_register_tokens = %+v", inserts)
+					}
+				},
+			},
+			{
+				name:       "missing name",
+				req:        newPost(`{}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"name"},
+			},
+			{
+				name:       "zero max_uses",
+				req:        newPost(`{"name":"spare","max_uses":0}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"max_uses"},
+			},
+			{
+				name:       "expires in the past",
+				req:        newPost(`{"name":"spare","expires_at":1}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"expires_at"},
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodPost, "/api/owner/chair-register-tokens", `{"name":"spare"}`),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		newDelete := func(tokenID string) *http.Request {
+			return asOwner(newRequest(http.MethodDelete, "/api/owner/chair-register-tokens/"+tokenID, "", "token_id", tokenID), owner)
+		}
+		runHandlerTests(t, ownerDeleteChairRegisterToken, []handlerTest{
+			{
+				name: "revoked",
+				setup: func(f *fakeDB) {
+					f.exec(`^UPDATE chair_register_tokens SET revoked_at`, 1)
+				},
+				req:        newDelete("token1"),
+				wantStatus: http.StatusNoContent,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					// 他のオーナーのトークンは失効させない
+					updates := f.executed(`^UPDATE chair_register_tokens SET revoked_at`)
+					if len(updates) != 1 || updates[0].Args[1] != "token1" || updates[0].Args[2] != owner.ID {
+						t.Errorf("UPDATE chair_register_tokens = %+v", updates)
+					}
+				},
+			},
+			{
+				name: "not found or already revoked",
+				setup: func(f *fakeDB) {
+					f.exec(`^UPDATE chair_register_tokens SET revoked_at`, 0)
+				},
+				req:        newDelete("token1"),
+				wantStatus: http.StatusNotFound,
+				wantCode:   errCodeNotFound,
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodDelete, "/api/owner/chair-register-tokens/token1", "", "token_id", "token1"),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+}
+
+func sqlNullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: true}
+}
+
+func TestOwnerPatchChair(t *testing.T) {
+	t.Cleanup(chairTokenCache.Clear)
+	owner := &Owner{ID: "owner1", Name: "owner"}
+	retiredAt := time.Now().Add(-time.Hour)
+	chair := Chair{ID: "chair1", OwnerID: owner.ID, Name: "chair", Model: "old", CreatedAt: retiredAt, UpdatedAt: retiredAt}
+	newPatch := func(body string) *http.Request {
+		return asOwner(newRequest(http.MethodPatch, "/api/owner/chairs/chair1", body, "chair_id", chair.ID), owner)
+	}
+	selectChair := func(f *fakeDB) {
+		f.returns(`FROM chairs WHERE id = \? AND owner_id = \? FOR UPDATE`, chair).with(chair.ID, owner.ID)
+	}
+
+	runHandlerTests(t, ownerPatchChair, []handlerTest{
+		{
+			name: "change model",
+			setup: func(f *fakeDB) {
+				selectChair(f)
+				f.returns(`FROM chair_models WHERE name = \?`, ChairModel{Name: "new", Speed: 4}).with("new")
+				f.exec(`^UPDATE chairs SET name = \?, model = \?`, 1).with("renamed", "new", chair.ID)
+			},
+			req:        newPatch(`{"name":"renamed","model":"new"}`),
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			// 廃止済みのモデルでも、今のモデルのままなら名前は変えられる
+			name: "keep retired model",
+			setup: func(f *fakeDB) {
+				selectChair(f)
+				f.exec(`^UPDATE chairs SET name = \?, model = \?`, 1).with("renamed", "old", chair.ID)
+			},
+			req:        newPatch(`{"name":"renamed","model":"old"}`),
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name: "retired model",
+			setup: func(f *fakeDB) {
+				selectChair(f)
+				f.returns(`FROM chair_models WHERE name = \?`, ChairModel{Name: "gone", Speed: 4, RetiredAt: &retiredAt})
+			},
+			req:        newPatch(`{"model":"gone"}`),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeBadRequest,
+		},
+		{
+			name: "unknown model",
+			setup: func(f *fakeDB) {
+				selectChair(f)
+				f.returns(`FROM chair_models WHERE name = \?`)
+			},
+			req:        newPatch(`{"model":"missing"}`),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeBadRequest,
+		},
+		{
+			name:       "empty name",
+			req:        newPatch(`{"name":""}`),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"name"},
+		},
+		{
+			name: "other owner's chair",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM chairs WHERE id = \? AND owner_id = \? FOR UPDATE`)
+			},
+			req:        newPatch(`{"name":"renamed"}`),
+			wantStatus: http.StatusNotFound,
+			wantCode:   errCodeNotFound,
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodPatch, "/api/owner/chairs/chair1", `{"name":"renamed"}`, "chair_id", chair.ID),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/oklog/ulid/v2"
+)
+
+var errInvalidPlace = errors.New("invalid place")
+
+// resolveDestinationPlace は place_id が指定されていれば登録地点の座標を目的地として返す
+// place_id が空なら destination をそのまま返す
+func resolveDestinationPlace(ctx context.Context, userID, placeID string, destination *Coordinate) (*Coordinate, error) {
+	if placeID == "" {
+		return destination, nil
+	}
+	if destination != nil {
+		return nil, fmt.Errorf("%w: destination_coordinate and place_id cannot be specified together", errInvalidPlace)
+	}
+
+	place := &UserPlace{}
+	if err := db.GetContext(ctx, place, `SELECT * FROM user_places WHERE id = ? AND user_id = ?`, placeID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: place not found", errInvalidPlace)
+		}
+		return nil, err
+	}
+	return &Coordinate{Latitude: place.Latitude, Longitude: place.Longitude}, nil
+}
+
+type appPlace struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Coordinate Coordinate `json:"coordinate"`
+	CreatedAt  int64      `json:"created_at"`
+}
+
+type appGetPlacesResponse struct {
+	Places []appPlace `json:"places"`
+}
+
+func appGetPlaces(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	places := []UserPlace{}
+	if err := db.SelectContext(ctx, &places, `SELECT * FROM user_places WHERE user_id = ? ORDER BY created_at`, user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := &appGetPlacesResponse{Places: make([]appPlace, 0, len(places))}
+	for _, place := range places {
+		res.Places = append(res.Places, appPlace{
+			ID:         place.ID,
+			Name:       place.Name,
+			Coordinate: Coordinate{Latitude: place.Latitude, Longitude: place.Longitude},
+			CreatedAt:  place.CreatedAt.UnixMilli(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type appPostPlacesRequest struct {
+	Name       string      `json:"name" validate:"required,max=30"`
+	Coordinate *Coordinate `json:"coordinate" validate:"required"`
+}
+
+type appPostPlacesResponse struct {
+	ID string `json:"id"`
+}
+
+func appPostPlaces(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	req := &appPostPlacesRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	placeID := ulid.Make().String()
+
+	if _, err := db.ExecContext(
+		ctx,
+		`INSERT INTO user_places (id, user_id, name, latitude, longitude) VALUES (?, ?, ?, ?, ?)`,
+		placeID, user.ID, req.Name, req.Coordinate.Latitude, req.Coordinate.Longitude,
+	); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			writeError(w, http.StatusConflict, errors.New("place with the same name already exists"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &appPostPlacesResponse{ID: placeID})
+}
+
+func appDeletePlace(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	placeID := r.PathValue("place_id")
+
+	result, err := db.ExecContext(ctx, `DELETE FROM user_places WHERE id = ? AND user_id = ?`, placeID, user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	} else if count == 0 {
+		writeError(w, http.StatusNotFound, errors.New("place not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type appGetRecentPlacesResponse struct {
+	Destinations []appRecentDestination `json:"destinations"`
+}
+
+type appRecentDestination struct {
+	Coordinate Coordinate `json:"coordinate"`
+	// RideCount はこの目的地に向かったライドの回数
+	RideCount  int   `json:"ride_count"`
+	LastUsedAt int64 `json:"last_used_at"`
+}
+
+const (
+	appRecentPlacesDefaultLimit = 10
+	appRecentPlacesMaxLimit     = 50
+)
+
+// appGetRecentPlaces は過去のライドの目的地を、同じ座標をまとめて最後に使った順に返す
+func appGetRecentPlaces(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	limit, err := parseQueryLimit(r, "limit", appRecentPlacesDefaultLimit, appRecentPlacesMaxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	type recentDestination struct {
+		Latitude   int       `db:"destination_latitude"`
+		Longitude  int       `db:"destination_longitude"`
+		RideCount  int       `db:"ride_count"`
+		LastUsedAt time.Time `db:"last_used_at"`
+	}
+	destinations := []recentDestination{}
+	if err := db.SelectContext(
+		ctx,
+		&destinations,
+		`SELECT destination_latitude, destination_longitude, COUNT(*) AS ride_count, MAX(created_at) AS last_used_at
+		FROM rides
+		WHERE user_id = ?
+		GROUP BY destination_latitude, destination_longitude
+		ORDER BY last_used_at DESC
+		LIMIT ?`,
+		user.ID, limit,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := &appGetRecentPlacesResponse{Destinations: make([]appRecentDestination, 0, len(destinations))}
+	for _, d := range destinations {
+		res.Destinations = append(res.Destinations, appRecentDestination{
+			Coordinate: Coordinate{Latitude: d.Latitude, Longitude: d.Longitude},
+			RideCount:  d.RideCount,
+			LastUsedAt: d.LastUsedAt.UnixMilli(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
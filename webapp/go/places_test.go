@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestAppPlaces(t *testing.T) {
+	user := &User{ID: "user1"}
+	now := time.Now()
+
+	t.Run("list", func(t *testing.T) {
+		runHandlerTests(t, appGetPlaces, []handlerTest{
+			{
+				name: "places",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM user_places WHERE user_id = \?`,
+						UserPlace{ID: "place1", UserID: user.ID, Name: "home", Latitude: 1, Longitude: 2, CreatedAt: now},
+						UserPlace{ID: "place2", UserID: user.ID, Name: "work", Latitude: 3, Longitude: 4, CreatedAt: now},
+					).with(user.ID)
+				},
+				req:        asUser(newRequest(http.MethodGet, "/api/app/places", ""), user),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					res := decodeJSON[appGetPlacesResponse](t, body)
+					want := appPlace{ID: "place2", Name: "work", Coordinate: Coordinate{Latitude: 3, Longitude: 4}, CreatedAt: now.UnixMilli()}
+					if len(res.Places) != 2 || res.Places[1] != want {
+						t.Errorf("places = %+v", res.Places)
+					}
+				},
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodGet, "/api/app/places", ""),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+
+	t.Run("create", func(t *testing.T) {
+		newPost := func(body string) *http.Request {
+			return asUser(newRequest(http.MethodPost, "/api/app/places", body), user)
+		}
+		runHandlerTests(t, appPostPlaces, []handlerTest{
+			{
+				name: "created",
+				setup: func(f *fakeDB) {
+					f.exec(`^INSERT INTO user_places`, 1)
+				},
+				req:        newPost(`{"name":"home","coordinate":{"latitude":1,"longitude":2}}`),
+				wantStatus: http.StatusCreated,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					res := decodeJSON[appPostPlacesResponse](t, body)
+					inserts := f.executed(`^INSERT INTO user_places`)
+					if len(inserts) != 1 || inserts[0].Args[0] != res.ID || inserts[0].Args[1] != user.ID || inserts[0].Args[2] != "home" {
+						t.Errorf("INSERT INTO user_places = %+v, response = %+v", inserts, res)
+					}
+				},
+			},
+			{
+				name:       "missing coordinate",
+				req:        newPost(`{"name":"home"}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"coordinate"},
+			},
+			{
+				name: "duplicated name",
+				setup: func(f *fakeDB) {
+					f.fail(`^INSERT INTO user_places`, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+				},
+				req:        newPost(`{"name":"home","coordinate":{"latitude":1,"longitude":2}}`),
+				wantStatus: http.StatusConflict,
+				wantCode:   errCodeConflict,
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodPost, "/api/app/places", `{"name":"home","coordinate":{"latitude":1,"longitude":2}}`),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		newDelete := func() *http.Request {
+			return asUser(newRequest(http.MethodDelete, "/api/app/places/place1", "", "place_id", "place1"), user)
+		}
+		runHandlerTests(t, appDeletePlace, []handlerTest{
+			{
+				name: "deleted",
+				setup: func(f *fakeDB) {
+					f.exec(`^DELETE FROM user_places WHERE id = \? AND user_id = \?`, 1).with("place1", user.ID)
+				},
+				req:        newDelete(),
+				wantStatus: http.StatusNoContent,
+			},
+			{
+				name: "other user's place",
+				setup: func(f *fakeDB) {
+					f.exec(`^DELETE FROM user_places`, 0)
+				},
+				req:        newDelete(),
+				wantStatus: http.StatusNotFound,
+				wantCode:   errCodeNotFound,
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodDelete, "/api/app/places/place1", "", "place_id", "place1"),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+
+	t.Run("recent", func(t *testing.T) {
+		columns := []string{"destination_latitude", "destination_longitude", "ride_count", "last_used_at"}
+		runHandlerTests(t, appGetRecentPlaces, []handlerTest{
+			{
+				name: "destinations",
+				setup: func(f *fakeDB) {
+					f.query(`GROUP BY destination_latitude, destination_longitude`, columns,
+						[]any{10, 20, 3, now},
+						[]any{5, 5, 1, now.Add(-time.Hour)},
+					).with(user.ID, appRecentPlacesMaxLimit)
+				},
+				req:        asUser(newRequest(http.MethodGet, "/api/app/places/recent?limit=1000", ""), user),
+				wantStatus: http.StatusOK,
+				check: func(t *testing.T, f *fakeDB, body []byte) {
+					res := decodeJSON[appGetRecentPlacesResponse](t, body)
+					want := appRecentDestination{Coordinate: Coordinate{Latitude: 10, Longitude: 20}, RideCount: 3, LastUsedAt: now.UnixMilli()}
+					if len(res.Destinations) != 2 || res.Destinations[0] != want {
+						t.Errorf("destinations = %+v", res.Destinations)
+					}
+				},
+			},
+			{
+				name:       "invalid limit",
+				req:        asUser(newRequest(http.MethodGet, "/api/app/places/recent?limit=0", ""), user),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeValidationFailed,
+				wantFields: []string{"limit"},
+			},
+			{
+				name:       "unauthenticated",
+				req:        newRequest(http.MethodGet, "/api/app/places/recent", ""),
+				wantStatus: http.StatusUnauthorized,
+				wantCode:   errCodeUnauthorized,
+			},
+		})
+	})
+
+	t.Run("ride to place", func(t *testing.T) {
+		newPost := func(body string) *http.Request {
+			return asUser(newRequest(http.MethodPost, "/api/app/rides", body), user)
+		}
+		runHandlerTests(t, appPostRides, []handlerTest{
+			{
+				name: "unknown place",
+				setup: func(f *fakeDB) {
+					f.returns(`FROM user_places WHERE id = \? AND user_id = \?`).with("missing", user.ID)
+				},
+				req:        newPost(`{"pickup_coordinate":{"latitude":0,"longitude":0},"place_id":"missing"}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeBadRequest,
+			},
+			{
+				name:       "place and destination",
+				req:        newPost(`{"pickup_coordinate":{"latitude":0,"longitude":0},"destination_coordinate":{"latitude":1,"longitude":1},"place_id":"place1"}`),
+				wantStatus: http.StatusBadRequest,
+				wantCode:   errCodeBadRequest,
+			},
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isucon/isucon14/webapp/go/auth"
+	"github.com/isucon/isucon14/webapp/go/ratelimit"
+)
+
+type rateLimitRules struct {
+	// Roles はロールごとの制限。ルートごとの設定がないリクエストは、利用者ごとに1つのバケットを共有する
+	Roles map[auth.Role]ratelimit.Limit
+	// Routes はルートごとの制限。"POST /api/chair/coordinate" のようにメソッドとパターンで指定する
+	Routes map[string]ratelimit.Limit
+}
+
+// defaultRateLimitRules は重いSQLを実行するルートだけを制限する
+func defaultRateLimitRules() rateLimitRules {
+	return rateLimitRules{
+		Roles: map[auth.Role]ratelimit.Limit{},
+		Routes: map[string]ratelimit.Limit{
+			"POST /api/chair/coordinate":  {Rate: 20, Burst: 40},
+			"POST /api/chair/coordinates": {Rate: 5, Burst: 10},
+			"GET /api/app/nearby-chairs":  {Rate: 10, Burst: 20},
+		},
+	}
+}
+
+var rateLimits = defaultRateLimitRules()
+
+// localRateLimitStore は自分のバケット。rate_limit.server が自分を指している場合は他のサーバーからも使われる
+var localRateLimitStore = ratelimit.NewMemoryStore()
+
+var rateLimitStore ratelimit.Store = localRateLimitStore
+
+// parseRateLimits は "chair=50:100;POST /api/chair/coordinate=20:40" の形式の設定を読み込む
+// キーはロール名か、メソッドとパターン。値は1秒あたりの回数とバーストで、0 を指定すると制限しない
+func parseRateLimits(s string, cfg *rateLimitRules) error {
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid rate limit: %s", entry)
+		}
+		key = strings.TrimSpace(key)
+
+		limit := ratelimit.Limit{}
+		if value != "0" {
+			rate, burst, ok := strings.Cut(value, ":")
+			if !ok {
+				return fmt.Errorf("invalid rate limit: %s", entry)
+			}
+			var err error
+			if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.Rate <= 0 {
+				return fmt.Errorf("invalid rate limit: %s", entry)
+			}
+			if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+				return fmt.Errorf("invalid rate limit: %s", entry)
+			}
+		}
+
+		switch role := auth.Role(key); role {
+		case auth.RoleUser, auth.RoleOwner, auth.RoleChair:
+			cfg.Roles[role] = limit
+		default:
+			if !strings.Contains(key, " /") {
+				return fmt.Errorf("invalid rate limit key: %s", key)
+			}
+			cfg.Routes[key] = limit
+		}
+	}
+	return nil
+}
+
+// rules はデフォルトの制限を Limits で上書きしたものを返す
+func (c rateLimitConfig) rules() (rateLimitRules, error) {
+	rules := defaultRateLimitRules()
+	if err := parseRateLimits(c.Limits, &rules); err != nil {
+		return rateLimitRules{}, err
+	}
+	return rules, nil
+}
+
+// setupRateLimits は appConfig からレート制限を組み立てる。設定は loadConfig で検証済み
+func setupRateLimits() {
+	rules, err := appConfig.RateLimit.rules()
+	if err != nil {
+		panic(err)
+	}
+	rateLimits = rules
+	if server := appConfig.RateLimit.Server; server != "" {
+		rateLimitStore = &ratelimit.RemoteStore{
+			URL:    strings.TrimSuffix(server, "/") + "/api/internal/rate-limit/take",
+			Token:  appConfig.RateLimit.Token,
+			Client: &http.Client{Timeout: 200 * time.Millisecond},
+		}
+	}
+}
+
+// lookupRateLimit は設定上の名前（ロール名か、メソッドとパターン）から制限を引く
+// バケットを持つサーバーは、他のサーバーから渡された名前を自分の設定で解決する
+func lookupRateLimit(name string) (ratelimit.Limit, bool) {
+	var limit ratelimit.Limit
+	switch role := auth.Role(name); role {
+	case auth.RoleUser, auth.RoleOwner, auth.RoleChair:
+		limit = rateLimits.Roles[role]
+	default:
+		var ok bool
+		if limit, ok = rateLimits.Routes[name]; !ok {
+			return ratelimit.Limit{}, false
+		}
+	}
+	limit.Name = name
+	return limit, true
+}
+
+// rateLimitMiddleware は認証済みの利用者ごとにリクエスト数を制限する。認証ミドルウェアの後に使うこと
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		principals := auth.Principals(ctx)
+		if len(principals) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal := principals[len(principals)-1]
+
+		route := r.Method + " " + chi.RouteContext(ctx).RoutePattern()
+		key := string(principal.Role) + ":" + principal.ID
+		limit, ok := lookupRateLimit(route)
+		if ok {
+			key += ":" + route
+		} else {
+			limit, _ = lookupRateLimit(string(principal.Role))
+		}
+		if !limit.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := rateLimitStore.Take(ctx, key, limit)
+		if err != nil {
+			// 共有先のサーバーに繋がらない場合は自分のバケットで制限する
+			slog.Error("failed to take rate limit token", "error", err)
+			result, _ = localRateLimitStore.Take(ctx, key, limit)
+		}
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(result.RetryAfterSeconds()))
+			writeError(w, http.StatusTooManyRequests, errors.New("too many requests"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
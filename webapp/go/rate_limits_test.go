@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isucon/isucon14/webapp/go/auth"
+	"github.com/isucon/isucon14/webapp/go/ratelimit"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	cfg := rateLimitRules{Roles: map[auth.Role]ratelimit.Limit{}, Routes: map[string]ratelimit.Limit{}}
+	if err := parseRateLimits("chair=50:100; POST /api/chair/coordinate=2.5:5;GET /api/app/nearby-chairs=0", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Roles[auth.RoleChair]; got != (ratelimit.Limit{Rate: 50, Burst: 100}) {
+		t.Errorf("Roles[chair] = %+v", got)
+	}
+	if got := cfg.Routes["POST /api/chair/coordinate"]; got != (ratelimit.Limit{Rate: 2.5, Burst: 5}) {
+		t.Errorf("Routes[POST /api/chair/coordinate] = %+v", got)
+	}
+	if got, ok := cfg.Routes["GET /api/app/nearby-chairs"]; !ok || got.Enabled() {
+		t.Errorf("Routes[GET /api/app/nearby-chairs] = %+v, %v, want disabled", got, ok)
+	}
+
+	for _, s := range []string{"chair", "chair=10", "chair=-1:10", "unknown=1:1"} {
+		if err := parseRateLimits(s, &cfg); err == nil {
+			t.Errorf("parseRateLimits(%q) should fail", s)
+		}
+	}
+}
+
+func TestLookupRateLimit(t *testing.T) {
+	if got, ok := lookupRateLimit("POST /api/chair/coordinate"); !ok || got != (ratelimit.Limit{Rate: 20, Burst: 40, Name: "POST /api/chair/coordinate"}) {
+		t.Errorf("lookupRateLimit(route) = %+v, %v", got, ok)
+	}
+	// ロールは設定がなくても引けるが、制限はしない
+	if got, ok := lookupRateLimit("app"); !ok || got.Enabled() {
+		t.Errorf("lookupRateLimit(app) = %+v, %v, want disabled", got, ok)
+	}
+	if _, ok := lookupRateLimit("GET /api/unknown"); ok {
+		t.Error("lookupRateLimit() should not find an unknown route")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	const route = "POST /api/chair/coordinate"
+	saved := rateLimits.Routes[route]
+	rateLimits.Routes[route] = ratelimit.Limit{Rate: 1, Burst: 2}
+	defer func() { rateLimits.Routes[route] = saved }()
+
+	withChair := func(id string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleChair, ID: id}, &Chair{ID: id})
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		}
+	}
+	newRouter := func(id string) http.Handler {
+		mux := chi.NewRouter()
+		mux.With(withChair(id), rateLimitMiddleware).HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		return mux
+	}
+
+	post := func(h http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/chair/coordinate", nil))
+		return rec
+	}
+
+	chair1 := newRouter("rate-limit-chair1")
+	for i := 0; i < 2; i++ {
+		if rec := post(chair1); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, http.StatusNoContent)
+		}
+	}
+	rec := post(chair1)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+
+	// 別の椅子は制限されない
+	if rec := post(newRouter("rate-limit-chair2")); rec.Code != http.StatusNoContent {
+		t.Errorf("another chair: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,115 @@
+// Package ratelimit はトークンバケットでリクエスト数を制限する。
+// バケットはメモリ上に持つが、RemoteStore を使うと他のサーバーのバケットを共有できる。
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit はバケットの設定
+type Limit struct {
+	// Rate は1秒あたりに補充するトークン数
+	Rate float64
+	// Burst はバケットの容量。連続して受け付けられるリクエスト数
+	Burst int
+	// Name は設定上の名前。RemoteStore は Rate と Burst の代わりにこれを送り、Handler 側の設定で制限を決める
+	Name string
+}
+
+// Enabled は制限が有効かどうかを返す。Rate か Burst が0以下なら制限しない
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Result は Take の結果
+type Result struct {
+	Allowed bool
+	// RetryAfter は拒否された場合に、次のトークンが補充されるまでの時間
+	RetryAfter time.Duration
+}
+
+// RetryAfterSeconds は Retry-After ヘッダーに入れる秒数を返す。1秒未満は切り上げる
+func (r Result) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(r.RetryAfter.Seconds())))
+}
+
+// Store はバケットを保持し、トークンを1つ取り出す
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+type bucket struct {
+	tokens    float64
+	limit     Limit
+	updatedAt time.Time
+}
+
+// sweepInterval ごとに、満タンに戻ったバケットを捨てる
+const sweepInterval = time.Minute
+
+// MemoryStore はメモリ上にバケットを持つ Store
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst)}
+		s.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.updatedAt).Seconds()
+		b.tokens = min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.limit = limit
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Result{Allowed: true}, nil
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return Result{RetryAfter: time.Duration(wait * float64(time.Second))}, nil
+}
+
+// sweep は満タンに戻ったバケットを捨てる。次に使われたときは満タンで作り直すので結果は変わらない
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Len は保持しているバケットの数を返す
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
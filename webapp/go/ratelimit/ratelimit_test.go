@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < limit.Burst; i++ {
+		if res, _ := s.Take(ctx, "a", limit); !res.Allowed {
+			t.Fatalf("request %d should be allowed within burst", i)
+		}
+	}
+	res, _ := s.Take(ctx, "a", limit)
+	if res.Allowed {
+		t.Fatal("request over burst should be rejected")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want 500ms", res.RetryAfter)
+	}
+	if got := res.RetryAfterSeconds(); got != 1 {
+		t.Errorf("RetryAfterSeconds() = %d, want 1", got)
+	}
+
+	// 別のキーは独立している
+	if res, _ := s.Take(ctx, "b", limit); !res.Allowed {
+		t.Error("another key should be allowed")
+	}
+
+	// 0.5秒で1つ補充される
+	now = now.Add(500 * time.Millisecond)
+	if res, _ := s.Take(ctx, "a", limit); !res.Allowed {
+		t.Error("request after refill should be allowed")
+	}
+	if res, _ := s.Take(ctx, "a", limit); res.Allowed {
+		t.Error("bucket should be empty again")
+	}
+
+	// 満タンに戻ったバケットは捨てられる
+	now = now.Add(time.Hour)
+	s.Take(ctx, "c", limit)
+	if got := s.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1 after sweep", got)
+	}
+}
+
+func TestMemoryStore_Disabled(t *testing.T) {
+	s := NewMemoryStore()
+	for i := 0; i < 10; i++ {
+		if res, _ := s.Take(context.Background(), "a", Limit{}); !res.Allowed {
+			t.Fatal("disabled limit should always allow")
+		}
+	}
+	if got := s.Len(); got != 0 {
+		t.Errorf("Len() = %d, want 0", got)
+	}
+}
+
+func TestRemoteStore(t *testing.T) {
+	// サーバー側の設定だけを使うので、クライアントが送った Rate と Burst は無視される
+	limits := func(name string) (Limit, bool) {
+		if name != "test" {
+			return Limit{}, false
+		}
+		return Limit{Rate: 1, Burst: 1, Name: name}, true
+	}
+	server := httptest.NewServer(Handler(NewMemoryStore(), "secret", limits))
+	defer server.Close()
+	ctx := context.Background()
+	limit := Limit{Rate: 1000, Burst: 1000, Name: "test"}
+
+	s := &RemoteStore{URL: server.URL, Token: "secret"}
+	if res, err := s.Take(ctx, "a", limit); err != nil || !res.Allowed {
+		t.Fatalf("Take() = %v, %v", res, err)
+	}
+	res, err := s.Take(ctx, "a", limit)
+	if err != nil || res.Allowed {
+		t.Fatalf("Take() = %v, %v, want rejected", res, err)
+	}
+	if res.RetryAfter <= 0 {
+		t.Errorf("RetryAfter = %v, want positive", res.RetryAfter)
+	}
+
+	if _, err := s.Take(ctx, "b", Limit{Rate: 1, Burst: 1, Name: "unknown"}); err == nil {
+		t.Error("Take() with an unknown limit should fail")
+	}
+
+	unauthorized := &RemoteStore{URL: server.URL, Token: "wrong"}
+	if _, err := unauthorized.Take(ctx, "a", limit); err == nil {
+		t.Error("Take() with a wrong token should fail")
+	}
+}
+
+func TestHandler_EmptyToken(t *testing.T) {
+	server := httptest.NewServer(Handler(NewMemoryStore(), "", func(string) (Limit, bool) { return Limit{Rate: 1, Burst: 1}, true }))
+	defer server.Close()
+
+	s := &RemoteStore{URL: server.URL}
+	if _, err := s.Take(context.Background(), "a", Limit{Rate: 1, Burst: 1}); err == nil {
+		t.Error("Take() should fail when the server has no token")
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type takeRequest struct {
+	Key   string `json:"key"`
+	Limit string `json:"limit"`
+}
+
+type takeResponse struct {
+	Allowed      bool  `json:"allowed"`
+	RetryAfterMs int64 `json:"retry_after_ms"`
+}
+
+// RemoteStore は他のサーバーの Handler にトークンを取りに行く Store
+// 複数のサーバーで同じバケットを使いたい場合に、1台のサーバーにバケットを集める
+type RemoteStore struct {
+	// URL は Handler を公開しているエンドポイント
+	URL string
+	// Token は Authorization: Bearer で付ける
+	Token  string
+	Client *http.Client
+}
+
+func (s *RemoteStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	body, err := json.Marshal(&takeRequest{Key: key, Limit: limit.Name})
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("unexpected status code from rate limit server: %d", res.StatusCode)
+	}
+
+	resBody := &takeResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+		return Result{}, err
+	}
+	return Result{Allowed: resBody.Allowed, RetryAfter: time.Duration(resBody.RetryAfterMs) * time.Millisecond}, nil
+}
+
+// Handler は RemoteStore からのリクエストを store で処理する
+// Authorization: Bearer で token と同じ値が渡されたリクエストだけを受け付け、token が空なら何も受け付けない
+// 制限はリクエストで渡された名前を limits で引いたものを使い、クライアントの指定は信用しない
+func Handler(store Store, token string, limits func(name string) (Limit, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "rate limit server is disabled", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		req := &takeRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Key == "" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		limit, ok := limits(req.Limit)
+		if !ok {
+			http.Error(w, "unknown limit", http.StatusBadRequest)
+			return
+		}
+
+		result, err := store.Take(r.Context(), req.Key, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		json.NewEncoder(w).Encode(&takeResponse{Allowed: result.Allowed, RetryAfterMs: result.RetryAfter.Milliseconds()})
+	})
+}
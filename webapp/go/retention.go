@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	chairLocationRetentionModeDownsample = "downsample"
+	chairLocationRetentionModeArchive    = "archive"
+
+	chairLocationRetentionBatchSize = 10000
+)
+
+// startChairLocationRetention は古い chair_locations を定期的に間引くジョブを起動する
+// chairs.total_distance は位置情報の投稿時に積算済みなので、ここで行を消しても総移動距離は変わらない
+// また、各椅子の最新の位置情報は getChairLocation で参照されるので必ず残す
+func startChairLocationRetention() {
+	cfg := appConfig.ChairLocation
+	if cfg.Retention == 0 {
+		return
+	}
+
+	runPeriodically(time.Duration(cfg.RetentionInterval), func(ctx context.Context) {
+		removed, err := cleanupChairLocations(ctx, cfg, time.Now().Add(-time.Duration(cfg.Retention)))
+		if err != nil {
+			slog.Error("failed to clean up chair_locations", "error", err)
+			return
+		}
+		if removed > 0 {
+			slog.Info("cleaned up chair_locations", "mode", cfg.RetentionMode, "removed", removed)
+		}
+	})
+}
+
+func cleanupChairLocations(ctx context.Context, cfg chairLocationConfig, cutoff time.Time) (int, error) {
+	removed := 0
+	for {
+		ids := []string{}
+		var err error
+		switch cfg.RetentionMode {
+		case chairLocationRetentionModeArchive:
+			err = db.SelectContext(ctx, &ids, `
+				SELECT id FROM chair_locations cl
+				WHERE created_at < ?
+				AND created_at < (SELECT MAX(created_at) FROM chair_locations WHERE chair_id = cl.chair_id)
+				LIMIT ?`,
+				cutoff, chairLocationRetentionBatchSize,
+			)
+		default:
+			// 区間ごとに最新の1点だけ残す。最新の位置情報は最後の区間の先頭になるので消えない
+			err = db.SelectContext(ctx, &ids, `
+				SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY chair_id, FLOOR(UNIX_TIMESTAMP(created_at) / ?) ORDER BY created_at DESC) AS rn
+					FROM chair_locations
+					WHERE created_at < ?
+				) t
+				WHERE rn > 1
+				LIMIT ?`,
+				int(time.Duration(cfg.DownsampleInterval).Seconds()), cutoff, chairLocationRetentionBatchSize,
+			)
+		}
+		if err != nil {
+			return removed, err
+		}
+		if len(ids) == 0 {
+			return removed, nil
+		}
+
+		if err := removeChairLocations(ctx, cfg.RetentionMode == chairLocationRetentionModeArchive, ids); err != nil {
+			return removed, err
+		}
+		removed += len(ids)
+
+		if len(ids) < chairLocationRetentionBatchSize {
+			return removed, nil
+		}
+	}
+}
+
+func removeChairLocations(ctx context.Context, archive bool, ids []string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if archive {
+		query, args, err := sqlx.In("INSERT INTO chair_locations_archive SELECT * FROM chair_locations WHERE id IN (?)", ids)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	query, args, err := sqlx.In("DELETE FROM chair_locations WHERE id IN (?)", ids)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
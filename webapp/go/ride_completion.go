@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/oklog/ulid/v2"
+)
+
+var errPaymentTokenNotRegistered = errors.New("payment token not registered")
+
+// completeRide はライドを COMPLETED にして決済する。呼び出し側でライドをロックしておくこと
+// ride は完了後の値で上書きする
+func completeRide(ctx context.Context, tx *sqlx.Tx, ride *Ride) error {
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)`,
+		ulid.Make().String(), ride.ID, "COMPLETED",
+	); err != nil {
+		return err
+	}
+
+	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ?`, ride.ID); err != nil {
+		return err
+	}
+
+	paymentToken := &PaymentToken{}
+	if err := tx.GetContext(ctx, paymentToken, `SELECT * FROM payment_tokens WHERE user_id = ?`, ride.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errPaymentTokenNotRegistered
+		}
+		return err
+	}
+
+	fare, err := calculateDiscountedFare(ctx, tx, ride.UserID, ride, ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
+	if err != nil {
+		return err
+	}
+	paymentGatewayRequest := &paymentGatewayPostPaymentRequest{
+		Amount: fare,
+	}
+
+	var paymentGatewayURL string
+	if err := tx.GetContext(ctx, &paymentGatewayURL, "SELECT value FROM settings WHERE name = 'payment_gateway_url'"); err != nil {
+		return err
+	}
+
+	return requestPaymentGatewayPostPayment(ctx, paymentGatewayURL, paymentToken.Token, paymentGatewayRequest, func() ([]Ride, error) {
+		rides := []Ride{}
+		if err := tx.SelectContext(ctx, &rides, `SELECT * FROM rides WHERE user_id = ? ORDER BY created_at ASC`, ride.UserID); err != nil {
+			return nil, err
+		}
+		return rides, nil
+	})
+}
+
+// startRideAutoCompletion は ARRIVED のまま評価されないライドを完了させるジョブを起動する
+// 評価されないままだとユーザーは次のライドを呼べず、椅子も COMPLETED を受け取れないのでマッチングに戻れない
+// 完了した椅子は次の通知で COMPLETED を受け取った時点でマッチング対象になる
+func startRideAutoCompletion() {
+	cfg := appConfig.Ride
+	runPeriodically(time.Duration(cfg.AutoCompleteInterval), func(ctx context.Context) {
+		completed, err := autoCompleteArrivedRides(ctx, time.Now().Add(-time.Duration(cfg.AutoCompleteAfter)))
+		if err != nil {
+			slog.Error("failed to auto-complete rides", "error", err)
+			return
+		}
+		if completed > 0 {
+			slog.Info("auto-completed rides", "completed", completed)
+		}
+	})
+}
+
+// rideCompletionBackoff は自動完了に失敗したライドの再試行を遅らせる
+// 決済トークンが登録されていないライドなどを毎回試してエラーを出し続けないよう、失敗するたびに間隔を倍にする
+type rideCompletionBackoff struct {
+	mu      sync.Mutex
+	retries map[string]rideCompletionRetry
+	// minDelay は1回目の失敗後の間隔、maxDelay は間隔の上限
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+type rideCompletionRetry struct {
+	failures int
+	next     time.Time
+}
+
+var rideCompletionRetries = newRideCompletionBackoff(time.Second, 10*time.Minute)
+
+func newRideCompletionBackoff(minDelay, maxDelay time.Duration) *rideCompletionBackoff {
+	return &rideCompletionBackoff{retries: map[string]rideCompletionRetry{}, minDelay: minDelay, maxDelay: maxDelay}
+}
+
+// Ready は now の時点でライドを試してよいかを返す
+func (b *rideCompletionBackoff) Ready(rideID string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	retry, ok := b.retries[rideID]
+	return !ok || !now.Before(retry.next)
+}
+
+// Fail は失敗を記録し、次に試すまでの間隔を返す
+func (b *rideCompletionBackoff) Fail(rideID string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	retry := b.retries[rideID]
+	delay := b.minDelay
+	for i := 0; i < retry.failures && delay < b.maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.maxDelay)
+	retry.failures++
+	retry.next = now.Add(delay)
+	b.retries[rideID] = retry
+	return delay
+}
+
+// Done は完了したか、完了させる必要がなくなったライドを忘れる
+func (b *rideCompletionBackoff) Done(rideID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.retries, rideID)
+}
+
+func (b *rideCompletionBackoff) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retries = map[string]rideCompletionRetry{}
+}
+
+// autoCompleteArrivedRides は arrivedBefore より前に ARRIVED になり、まだ完了していないライドを完了させる
+// 失敗したライドは rideCompletionRetries の間隔をあけて再試行する
+func autoCompleteArrivedRides(ctx context.Context, arrivedBefore time.Time) (int, error) {
+	rideIDs := []string{}
+	if err := db.SelectContext(
+		ctx,
+		&rideIDs,
+		`SELECT rs.ride_id
+		FROM ride_statuses rs
+		WHERE rs.status = 'ARRIVED' AND rs.created_at < ?
+		AND NOT EXISTS (SELECT 1 FROM ride_statuses c WHERE c.ride_id = rs.ride_id AND c.status = 'COMPLETED')`,
+		arrivedBefore,
+	); err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	now := time.Now()
+	for _, rideID := range rideIDs {
+		if !rideCompletionRetries.Ready(rideID, now) {
+			continue
+		}
+		ok, err := autoCompleteRide(ctx, rideID)
+		if err != nil {
+			delay := rideCompletionRetries.Fail(rideID, now)
+			if errors.Is(err, errPaymentTokenNotRegistered) {
+				slog.Warn("cannot auto-complete ride without payment token", "ride_id", rideID, "retry_after", delay)
+				continue
+			}
+			slog.Error("failed to auto-complete ride", "ride_id", rideID, "error", err, "retry_after", delay)
+			continue
+		}
+		rideCompletionRetries.Done(rideID)
+		if ok {
+			completed++
+		}
+	}
+	return completed, nil
+}
+
+func autoCompleteRide(ctx context.Context, rideID string) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	ride := &Ride{}
+	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ? FOR UPDATE`, rideID); err != nil {
+		return false, err
+	}
+	// ロックを取るまでの間にユーザーが評価して完了しているかもしれないので、キャッシュを通さずに確認する
+	var status string
+	if err := tx.GetContext(ctx, &status, `SELECT status FROM ride_statuses WHERE ride_id = ? ORDER BY created_at DESC LIMIT 1`, ride.ID); err != nil {
+		return false, err
+	}
+	if status != "ARRIVED" {
+		return false, nil
+	}
+
+	// 完了日時として使うので updated_at を更新しておく
+	if _, err := tx.ExecContext(ctx, `UPDATE rides SET updated_at = CURRENT_TIMESTAMP(6) WHERE id = ?`, ride.ID); err != nil {
+		return false, err
+	}
+	if err := completeRide(ctx, tx, ride); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	rideStatusCache.Store(ride.ID, "COMPLETED")
+
+	return true, nil
+}
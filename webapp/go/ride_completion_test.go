@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRideCompletionBackoff(t *testing.T) {
+	b := newRideCompletionBackoff(time.Second, 5*time.Second)
+	now := time.Now()
+
+	if !b.Ready("ride", now) {
+		t.Fatal("Ready() should be true before any failure")
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := b.Fail("ride", now); got != want {
+			t.Errorf("Fail() #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if b.Ready("ride", now.Add(4*time.Second)) {
+		t.Error("Ready() should be false until the delay passes")
+	}
+	if !b.Ready("ride", now.Add(5*time.Second)) {
+		t.Error("Ready() should be true after the delay")
+	}
+	if !b.Ready("other", now) {
+		t.Error("failures of a ride should not delay other rides")
+	}
+
+	b.Done("ride")
+	if got := b.Fail("ride", now); got != time.Second {
+		t.Errorf("Fail() after Done() = %v, want the minimum delay", got)
+	}
+}
+
+// 自動完了したライドには評価がないので、同じ椅子の次のライドの通知で椅子の統計を出しても失敗しないこと
+func TestAutoCompletedRideInChairStats(t *testing.T) {
+	paymentGateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer paymentGateway.Close()
+	defer rideCompletionRetries.Clear()
+	defer rideStatusCache.Delete("ride1")
+	defer rideStatusCache.Delete("ride2")
+
+	now := time.Now()
+	chair := Chair{ID: "chair1", Name: "chair", Model: "model"}
+	autoCompleted := Ride{ID: "ride1", UserID: "user1", ChairID: sql.NullString{String: chair.ID, Valid: true}, DestinationLatitude: 10, CreatedAt: now, UpdatedAt: now}
+	evaluation := 4
+	evaluated := Ride{ID: "ride0", UserID: "user0", ChairID: autoCompleted.ChairID, DestinationLatitude: 10, Evaluation: &evaluation, CreatedAt: now, UpdatedAt: now}
+	current := Ride{ID: "ride2", UserID: "user2", ChairID: autoCompleted.ChairID, DestinationLatitude: 10, CreatedAt: now, UpdatedAt: now}
+
+	f := newFakeDB(t)
+	f.query(`SELECT rs.ride_id`, []string{"ride_id"}, []any{autoCompleted.ID})
+	f.returns(`SELECT \* FROM rides WHERE id = \?`, autoCompleted).with(autoCompleted.ID)
+	f.returns(`SELECT status FROM ride_statuses`, "ARRIVED").with(autoCompleted.ID)
+	f.exec(`UPDATE rides SET updated_at`, 1)
+	f.exec(`INSERT INTO ride_statuses`, 1)
+	f.returns(`FROM payment_tokens`, PaymentToken{UserID: autoCompleted.UserID, Token: "token"})
+	f.query(`FROM coupons WHERE used_by`, nil)
+	f.returns(`FROM settings`, paymentGateway.URL)
+
+	completed, err := autoCompleteArrivedRides(context.Background(), now)
+	if err != nil || completed != 1 {
+		t.Fatalf("autoCompleteArrivedRides() = %d, %v, want 1", completed, err)
+	}
+	if execs := f.executed(`evaluation`); len(execs) != 0 {
+		t.Fatalf("auto-completion should not set evaluation: %v", execs)
+	}
+
+	statuses := func(rideID string, names ...string) []any {
+		rows := []any{}
+		for i, name := range names {
+			rows = append(rows, RideStatus{ID: rideID + name, RideID: rideID, Status: name, CreatedAt: now.Add(time.Duration(i) * time.Second)})
+		}
+		return rows
+	}
+	rideStatusCache.Store(current.ID, "ENROUTE")
+	f.returns(`SELECT \* FROM rides WHERE user_id = \? ORDER BY created_at DESC LIMIT 1`, current)
+	f.query(`app_sent_at IS NULL`, nil)
+	f.returns(`SELECT \* FROM chairs WHERE id = \?`, chair)
+	f.returns(`SELECT \* FROM rides WHERE chair_id = \?`, current, autoCompleted, evaluated)
+	f.returns(`SELECT \* FROM ride_statuses WHERE ride_id = \? ORDER BY created_at`, statuses(current.ID, "MATCHING", "ENROUTE")...).with(current.ID)
+	for _, ride := range []Ride{autoCompleted, evaluated} {
+		f.returns(`SELECT \* FROM ride_statuses WHERE ride_id = \? ORDER BY created_at`, statuses(ride.ID, "MATCHING", "ENROUTE", "PICKUP", "CARRYING", "ARRIVED", "COMPLETED")...).with(ride.ID)
+	}
+	f.query(`FROM chair_locations`, nil)
+	f.query(`FROM chair_models`, nil)
+
+	rec := httptest.NewRecorder()
+	appGetNotification(rec, asUser(newRequest(http.MethodGet, "/api/app/notification", ""), &User{ID: current.UserID}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	res := decodeJSON[appGetNotificationResponse](t, rec.Body.Bytes())
+	if res.Data == nil || res.Data.Chair == nil {
+		t.Fatalf("response = %s, want chair", rec.Body.String())
+	}
+	// 完了したライドは2件とも数え、平均は評価のあるライドだけで出す
+	if got := res.Data.Chair.Stats; got.TotalRidesCount != 2 || got.TotalEvaluationAvg != 4 {
+		t.Errorf("stats = %+v, want 2 rides and average 4", got)
+	}
+}
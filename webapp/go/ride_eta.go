@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/eta"
+	"github.com/jmoiron/sqlx"
+)
+
+// 椅子は ride.chair_move_interval ごとに速度ぶん移動するものとして到着予定を見積もる
+var etaCalculator = eta.NewCalculator(time.Duration(defaultConfig().Ride.ChairMoveInterval))
+
+type rideETA struct {
+	// PickupMs は現在から配車位置に着くまでの見込み時間(ミリ秒)
+	PickupMs int64 `json:"pickup_ms"`
+	// TripMs は現在から目的地に着くまでの見込み時間(ミリ秒)
+	TripMs int64 `json:"trip_ms"`
+}
+
+// estimateRideETA は status のライドについて、椅子が location にいる場合の到着予定を返す
+// 到着予定を出せない状態の場合は nil を返す
+func estimateRideETA(status string, pickup, destination, location Coordinate, speed int) *rideETA {
+	toPickup := 0
+	toDestination := 0
+	switch status {
+	case "MATCHING", "ENROUTE":
+		toPickup = calculateDistance(location.Latitude, location.Longitude, pickup.Latitude, pickup.Longitude)
+		toDestination = calculateDistance(pickup.Latitude, pickup.Longitude, destination.Latitude, destination.Longitude)
+	case "PICKUP":
+		toDestination = calculateDistance(pickup.Latitude, pickup.Longitude, destination.Latitude, destination.Longitude)
+	case "CARRYING":
+		toDestination = calculateDistance(location.Latitude, location.Longitude, destination.Latitude, destination.Longitude)
+	default:
+		return nil
+	}
+
+	estimate, ok := etaCalculator.Estimate(toPickup, toDestination, speed)
+	if !ok {
+		return nil
+	}
+	return &rideETA{
+		PickupMs: estimate.Pickup.Milliseconds(),
+		TripMs:   estimate.Trip.Milliseconds(),
+	}
+}
+
+type chairWithSpeed struct {
+	Chair
+	Speed int `db:"speed"`
+}
+
+// getAvailableChairs はマッチング可能な椅子を速度の速い順に返す
+// 経由地への到着の記録は数えず、すべてのライドで6つのステータスを椅子に通知済みなら空いているとみなす
+func getAvailableChairs(ctx context.Context, q sqlx.QueryerContext, limit int) ([]*chairWithSpeed, error) {
+	chairs := []*chairWithSpeed{}
+	query := `
+		SELECT chairs.*, cm.speed
+		FROM chairs
+		INNER JOIN chair_models cm ON cm.name = chairs.model
+		WHERE chairs.is_active = TRUE
+		AND NOT EXISTS (
+			SELECT 1
+			FROM ride_statuses rs
+			INNER JOIN rides r ON r.id = rs.ride_id
+			WHERE r.chair_id = chairs.id
+			AND rs.waypoint_id IS NULL
+			GROUP BY rs.ride_id
+			HAVING COUNT(rs.chair_sent_at) != 6
+		)
+		ORDER BY cm.speed DESC
+		LIMIT ?
+	`
+	if err := sqlx.SelectContext(ctx, q, &chairs, query, limit); err != nil {
+		return nil, err
+	}
+	return chairs, nil
+}
+
+// availableChairsSnapshot はある時点で空いていた椅子の一覧
+type availableChairsSnapshot struct {
+	Chairs    []*chairWithSpeed
+	FetchedAt time.Time
+}
+
+// latestAvailableChairs はマッチングのたびに、割り当てずに残った椅子で更新する
+var latestAvailableChairs atomic.Pointer[availableChairsSnapshot]
+
+// availableChairsTTL より古い一覧は使わず、DBから取り直す
+const availableChairsTTL = 2 * time.Second
+
+func storeAvailableChairs(chairs []*chairWithSpeed) {
+	latestAvailableChairs.Store(&availableChairsSnapshot{Chairs: slices.Clone(chairs), FetchedAt: time.Now()})
+}
+
+// getAvailableChairsForEstimate は見積もり用に空いている椅子を返す
+// 直近のマッチングで取得した一覧があればそれを使い、なければDBから取得する。返した一覧は変更しないこと
+func getAvailableChairsForEstimate(ctx context.Context, q sqlx.QueryerContext) ([]*chairWithSpeed, error) {
+	if snapshot := latestAvailableChairs.Load(); snapshot != nil && time.Since(snapshot.FetchedAt) < availableChairsTTL {
+		return snapshot.Chairs, nil
+	}
+	chairs, err := getAvailableChairs(ctx, q, 30)
+	if err != nil {
+		return nil, err
+	}
+	storeAvailableChairs(chairs)
+	return chairs, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGetAvailableChairsForEstimateUsesSnapshot(t *testing.T) {
+	defer latestAvailableChairs.Store(nil)
+
+	chairs := []*chairWithSpeed{{Chair: Chair{ID: "a"}, Speed: 3}, {Chair: Chair{ID: "b"}, Speed: 2}}
+	storeAvailableChairs(chairs)
+	// マッチングで割り当てた椅子を取り除いても、保存した一覧は変わらない
+	chairs = append(chairs[:0], chairs[1:]...)
+
+	// 直近の一覧があるうちはDBを引かない
+	got, err := getAvailableChairsForEstimate(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "a" || got[1].ID != "b" {
+		t.Errorf("getAvailableChairsForEstimate() = %v, want snapshot", got)
+	}
+}
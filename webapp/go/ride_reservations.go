@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/oklog/ulid/v2"
+)
+
+// startRideReservationScheduler は予約時刻が近づいたライドをマッチングに回すジョブを起動する
+func startRideReservationScheduler() {
+	runPeriodically(time.Duration(appConfig.Ride.ReservationInterval), func(ctx context.Context) {
+		released, err := releaseDueRideReservations(ctx, time.Now())
+		if err != nil {
+			slog.Error("failed to release ride reservations", "error", err)
+			return
+		}
+		if released > 0 {
+			slog.Info("released ride reservations", "released", released)
+		}
+	})
+}
+
+// createRideReservation はライドの予約を作成し、見積もり運賃を返す
+// クーポンは予約時に紐づけておき、予約時刻になっても見積もり通りの運賃になるようにする
+func createRideReservation(ctx context.Context, tx *sqlx.Tx, userID, reservationID string, pickup Coordinate, waypoints []Coordinate, destination Coordinate, scheduledAt time.Time) (int, error) {
+	var rideCount int
+	if err := tx.GetContext(
+		ctx,
+		&rideCount,
+		`SELECT (SELECT COUNT(*) FROM rides WHERE user_id = ?) + (SELECT COUNT(*) FROM ride_reservations WHERE user_id = ? AND status = 'SCHEDULED')`,
+		userID, userID,
+	); err != nil {
+		return 0, err
+	}
+
+	// 経由地はいったん予約IDで登録し、マッチングに回すときにライドIDへ付け替える
+	routeDistance, err := insertRideWaypoints(ctx, tx, reservationID, pickup, waypoints, destination)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO ride_reservations (id, user_id, pickup_latitude, pickup_longitude, destination_latitude, destination_longitude, scheduled_at, route_distance)
+				  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		reservationID, userID, pickup.Latitude, pickup.Longitude, destination.Latitude, destination.Longitude, scheduledAt, routeDistance,
+	); err != nil {
+		return 0, err
+	}
+
+	if err := assignCoupon(ctx, tx, userID, reservationID, rideCount == 0); err != nil {
+		return 0, err
+	}
+
+	discount := 0
+	if err := tx.GetContext(ctx, &discount, "SELECT discount FROM coupons WHERE used_by = ?", reservationID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+	return calculateFareByDistance(calculateRouteDistance(pickup, waypoints, destination), discount), nil
+}
+
+// reservationDistance は予約の走行距離を返す
+func reservationDistance(reservation *RideReservation) int {
+	if reservation.RouteDistance != nil {
+		return *reservation.RouteDistance
+	}
+	return calculateDistance(reservation.PickupLatitude, reservation.PickupLongitude, reservation.DestinationLatitude, reservation.DestinationLongitude)
+}
+
+// releaseDueRideReservations は予約時刻が now + ride.reservation_lead_time 以前の予約をライドにする
+// ユーザーに進行中のライドがある場合は次回に持ち越す
+func releaseDueRideReservations(ctx context.Context, now time.Time) (int, error) {
+	ids := []string{}
+	if err := db.SelectContext(
+		ctx,
+		&ids,
+		`SELECT id FROM ride_reservations WHERE status = 'SCHEDULED' AND scheduled_at <= ? ORDER BY scheduled_at`,
+		now.Add(time.Duration(appConfig.Ride.ReservationLeadTime)),
+	); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range ids {
+		ok, err := releaseRideReservation(ctx, id)
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
+
+func releaseRideReservation(ctx context.Context, reservationID string) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	reservation := &RideReservation{}
+	if err := tx.GetContext(ctx, reservation, `SELECT * FROM ride_reservations WHERE id = ? FOR UPDATE`, reservationID); err != nil {
+		return false, err
+	}
+	// 他のインスタンスが先に処理したか、キャンセルされた
+	if reservation.Status != "SCHEDULED" {
+		return false, nil
+	}
+
+	continuing, err := hasContinuingRide(ctx, tx, reservation.UserID)
+	if err != nil {
+		return false, err
+	}
+	if continuing {
+		return false, nil
+	}
+
+	// ライドIDは予約IDと別に振る。予約IDは予約受付時に振ったものなので、ライドのIDの順序が崩れてしまう
+	rideID := ulid.Make().String()
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO rides (id, user_id, pickup_latitude, pickup_longitude, destination_latitude, destination_longitude, route_distance)
+				  VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rideID, reservation.UserID, reservation.PickupLatitude, reservation.PickupLongitude, reservation.DestinationLatitude, reservation.DestinationLongitude, reservation.RouteDistance,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO ride_statuses (id, ride_id, status) VALUES (?, ?, ?)`,
+		ulid.Make().String(), rideID, "MATCHING",
+	); err != nil {
+		return false, err
+	}
+	// 予約時に紐づけたクーポンと経由地をライドに付け替える
+	if _, err := tx.ExecContext(ctx, `UPDATE coupons SET used_by = ? WHERE used_by = ?`, rideID, reservation.ID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE ride_waypoints SET ride_id = ? WHERE ride_id = ?`, rideID, reservation.ID); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE ride_reservations SET status = 'RELEASED', released_at = ?, ride_id = ? WHERE id = ?`,
+		time.Now(), rideID, reservation.ID,
+	); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	rideStatusCache.Store(rideID, "MATCHING")
+
+	return true, nil
+}
+
+type appGetRideReservationsResponse struct {
+	Reservations []appGetRideReservationsResponseItem `json:"reservations"`
+}
+
+type appGetRideReservationsResponseItem struct {
+	ID                    string     `json:"id"`
+	PickupCoordinate      Coordinate `json:"pickup_coordinate"`
+	DestinationCoordinate Coordinate `json:"destination_coordinate"`
+	Fare                  int        `json:"fare"`
+	ScheduledAt           int64      `json:"scheduled_at"`
+	CreatedAt             int64      `json:"created_at"`
+}
+
+// appGetRideReservations はまだマッチングに回していない予約を予約時刻の早い順に返す
+func appGetRideReservations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	type reservationWithDiscount struct {
+		RideReservation
+		Discount int `db:"discount"`
+	}
+	reservations := []reservationWithDiscount{}
+	if err := db.SelectContext(
+		ctx,
+		&reservations,
+		`SELECT ride_reservations.*, COALESCE(coupons.discount, 0) AS discount
+		FROM ride_reservations
+		LEFT JOIN coupons ON coupons.used_by = ride_reservations.id
+		WHERE ride_reservations.user_id = ? AND ride_reservations.status = 'SCHEDULED'
+		ORDER BY ride_reservations.scheduled_at`,
+		user.ID,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items := make([]appGetRideReservationsResponseItem, 0, len(reservations))
+	for _, reservation := range reservations {
+		items = append(items, appGetRideReservationsResponseItem{
+			ID:                    reservation.ID,
+			PickupCoordinate:      Coordinate{Latitude: reservation.PickupLatitude, Longitude: reservation.PickupLongitude},
+			DestinationCoordinate: Coordinate{Latitude: reservation.DestinationLatitude, Longitude: reservation.DestinationLongitude},
+			Fare:                  calculateFareByDistance(reservationDistance(&reservation.RideReservation), reservation.Discount),
+			ScheduledAt:           reservation.ScheduledAt.UnixMilli(),
+			CreatedAt:             reservation.CreatedAt.UnixMilli(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, &appGetRideReservationsResponse{Reservations: items})
+}
+
+// appDeleteRideReservation は予約をキャンセルし、紐づけていたクーポンを未使用に戻して経由地を消す
+// すでにマッチングに回した予約はキャンセルできない
+func appDeleteRideReservation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := UserFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	reservationID := r.PathValue("reservation_id")
+
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	reservation := &RideReservation{}
+	if err := tx.GetContext(ctx, reservation, `SELECT * FROM ride_reservations WHERE id = ? AND user_id = ? FOR UPDATE`, reservationID, user.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("reservation not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if reservation.Status != "SCHEDULED" {
+		writeError(w, http.StatusConflict, errors.New("reservation is already released or canceled"))
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE ride_reservations SET status = 'CANCELED' WHERE id = ?`, reservation.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE coupons SET used_by = NULL WHERE used_by = ?`, reservation.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// 経由地は予約IDで登録してあり、キャンセルした予約はライドにならないので消しておく
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ride_waypoints WHERE ride_id = ?`, reservation.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
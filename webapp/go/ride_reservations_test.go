@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAppPostRidesScheduled(t *testing.T) {
+	user := &User{ID: "user1"}
+	scheduledAt := time.Now().Add(time.Hour).UnixMilli()
+	newPost := func(body string) *http.Request {
+		return asUser(newRequest(http.MethodPost, "/api/app/rides", body), user)
+	}
+	body := fmt.Sprintf(`{"pickup_coordinate":{"latitude":0,"longitude":0},"destination_coordinate":{"latitude":10,"longitude":0},"waypoints":[{"latitude":5,"longitude":5}],"scheduled_at":%d}`, scheduledAt)
+
+	runHandlerTests(t, appPostRides, []handlerTest{
+		{
+			name: "reserved",
+			setup: func(f *fakeDB) {
+				// 進行中のライドがあっても予約は受け付けるので、hasContinuingRide は呼ばない
+				f.returns(`SELECT \(SELECT COUNT\(\*\) FROM rides WHERE user_id = \?\) \+ \(SELECT COUNT\(\*\) FROM ride_reservations`, 0)
+				f.exec(`^INSERT INTO ride_waypoints`, 1)
+				f.exec(`^INSERT INTO ride_reservations`, 1)
+				f.returns(`FROM coupons WHERE user_id = \? AND code = 'CP_NEW2024'`, Coupon{UserID: user.ID, Code: "CP_NEW2024", Discount: 3000, CreatedAt: time.Now()})
+				f.exec(`^UPDATE coupons SET used_by = \? WHERE user_id = \? AND code = 'CP_NEW2024'`, 1)
+				f.returns(`SELECT discount FROM coupons WHERE used_by = \?`, 3000)
+			},
+			req:        newPost(body),
+			wantStatus: http.StatusAccepted,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[appPostRidesResponse](t, body)
+				if res.RideID != "" || res.ReservationID == "" || res.ScheduledAt != scheduledAt {
+					t.Fatalf("response = %+v, want a reservation without ride_id", res)
+				}
+				distance := calculateRouteDistance(Coordinate{}, []Coordinate{{Latitude: 5, Longitude: 5}}, Coordinate{Latitude: 10})
+				if want := calculateFareByDistance(distance, 3000); res.Fare != want {
+					t.Errorf("fare = %d, want %d", res.Fare, want)
+				}
+				// 経由地とクーポンは予約IDで登録する
+				if waypoints := f.executed(`^INSERT INTO ride_waypoints`); len(waypoints) != 1 || waypoints[0].Args[1] != res.ReservationID {
+					t.Errorf("INSERT INTO ride_waypoints = %+v", waypoints)
+				}
+				if coupons := f.executed(`^UPDATE coupons`); len(coupons) != 1 || coupons[0].Args[0] != res.ReservationID {
+					t.Errorf("UPDATE coupons = %+v", coupons)
+				}
+				if len(f.executed(`^INSERT INTO rides`)) != 0 {
+					t.Error("a ride should not be created until the reservation is released")
+				}
+			},
+		},
+		{
+			name:       "scheduled in the past",
+			req:        newPost(`{"pickup_coordinate":{"latitude":0,"longitude":0},"destination_coordinate":{"latitude":10,"longitude":0},"scheduled_at":1}`),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"scheduled_at"},
+		},
+		{
+			name:       "missing destination",
+			req:        newPost(fmt.Sprintf(`{"pickup_coordinate":{"latitude":0,"longitude":0},"scheduled_at":%d}`, scheduledAt)),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   errCodeValidationFailed,
+			wantFields: []string{"destination_coordinate"},
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodPost, "/api/app/rides", body),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
+
+func TestAppGetRideReservations(t *testing.T) {
+	user := &User{ID: "user1"}
+	now := time.Now()
+	routeDistance := 30
+	type reservationRow struct {
+		RideReservation
+		Discount int `db:"discount"`
+	}
+	first := reservationRow{
+		RideReservation: RideReservation{ID: "rsv1", UserID: user.ID, DestinationLatitude: 10, ScheduledAt: now.Add(time.Hour), Status: "SCHEDULED", CreatedAt: now, UpdatedAt: now},
+		Discount:        500,
+	}
+	second := reservationRow{
+		RideReservation: RideReservation{ID: "rsv2", UserID: user.ID, DestinationLatitude: 10, ScheduledAt: now.Add(2 * time.Hour), RouteDistance: &routeDistance, Status: "SCHEDULED", CreatedAt: now, UpdatedAt: now},
+	}
+
+	runHandlerTests(t, appGetRideReservations, []handlerTest{
+		{
+			name: "scheduled",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM ride_reservations\s+LEFT JOIN coupons .*\s+WHERE ride_reservations.user_id = \? AND ride_reservations.status = 'SCHEDULED'`, first, second).with(user.ID)
+			},
+			req:        asUser(newRequest(http.MethodGet, "/api/app/reservations", ""), user),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[appGetRideReservationsResponse](t, body)
+				if len(res.Reservations) != 2 {
+					t.Fatalf("reservations = %+v, want 2", res.Reservations)
+				}
+				if got, want := res.Reservations[0].Fare, calculateFareByDistance(10, 500); got != want || res.Reservations[0].ScheduledAt != first.ScheduledAt.UnixMilli() {
+					t.Errorf("reservations[0] = %+v, want fare %d", res.Reservations[0], want)
+				}
+				// 経由地がある予約は経由地を回る距離で見積もる
+				if got, want := res.Reservations[1].Fare, calculateFareByDistance(routeDistance, 0); got != want {
+					t.Errorf("reservations[1].fare = %d, want %d", got, want)
+				}
+			},
+		},
+		{
+			name: "none",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM ride_reservations`)
+			},
+			req:        asUser(newRequest(http.MethodGet, "/api/app/reservations", ""), user),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				if res := decodeJSON[appGetRideReservationsResponse](t, body); res.Reservations == nil || len(res.Reservations) != 0 {
+					t.Errorf("reservations = %+v, want an empty list", res.Reservations)
+				}
+			},
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodGet, "/api/app/reservations", ""),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
+
+func TestAppDeleteRideReservation(t *testing.T) {
+	user := &User{ID: "user1"}
+	now := time.Now()
+	reservation := func(status string) RideReservation {
+		return RideReservation{ID: "rsv1", UserID: user.ID, ScheduledAt: now.Add(time.Hour), Status: status, CreatedAt: now, UpdatedAt: now}
+	}
+	newDelete := func() *http.Request {
+		return asUser(newRequest(http.MethodDelete, "/api/app/reservations/rsv1", "", "reservation_id", "rsv1"), user)
+	}
+
+	runHandlerTests(t, appDeleteRideReservation, []handlerTest{
+		{
+			name: "canceled",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM ride_reservations WHERE id = \? AND user_id = \? FOR UPDATE`, reservation("SCHEDULED")).with("rsv1", user.ID)
+				f.exec(`^UPDATE ride_reservations SET status = 'CANCELED'`, 1).with("rsv1")
+				f.exec(`^UPDATE coupons SET used_by = NULL WHERE used_by = \?`, 1).with("rsv1")
+				f.exec(`^DELETE FROM ride_waypoints WHERE ride_id = \?`, 1).with("rsv1")
+			},
+			req:        newDelete(),
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				for _, pattern := range []string{`^UPDATE ride_reservations`, `^UPDATE coupons`, `^DELETE FROM ride_waypoints`} {
+					if len(f.executed(pattern)) != 1 {
+						t.Errorf("%s executed %d times, want 1", pattern, len(f.executed(pattern)))
+					}
+				}
+			},
+		},
+		{
+			name: "already released",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM ride_reservations WHERE id = \? AND user_id = \? FOR UPDATE`, reservation("RELEASED"))
+			},
+			req:        newDelete(),
+			wantStatus: http.StatusConflict,
+			wantCode:   errCodeConflict,
+		},
+		{
+			name: "other user's reservation",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM ride_reservations WHERE id = \? AND user_id = \? FOR UPDATE`)
+			},
+			req:        newDelete(),
+			wantStatus: http.StatusNotFound,
+			wantCode:   errCodeNotFound,
+		},
+		{
+			name:       "unauthenticated",
+			req:        newRequest(http.MethodDelete, "/api/app/reservations/rsv1", "", "reservation_id", "rsv1"),
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   errCodeUnauthorized,
+		},
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	sessionRoleApp   = string(auth.RoleUser)
+	sessionRoleOwner = string(auth.RoleOwner)
+	sessionRoleChair = string(auth.RoleChair)
+)
+
+var sessionCookieNames = map[string]string{
+	sessionRoleApp:   "app_session",
+	sessionRoleOwner: "owner_session",
+	sessionRoleChair: "chair_session",
+}
+
+type sessionEntry struct {
+	mu      sync.Mutex
+	session Session
+	// persistedAt は last_seen_at を最後にDBに書き込んだ日時
+	persistedAt time.Time
+}
+
+// sessionCache はハッシュ化したトークンからセッションを引くキャッシュ
+var sessionCache = newTokenCache(func(ctx context.Context, token string) (*sessionEntry, error) {
+	sessions := []Session{}
+	if err := db.SelectContext(ctx, &sessions, `SELECT * FROM sessions WHERE token_prefix = ?`, accessTokenLookupPrefix(token)); err != nil {
+		return nil, err
+	}
+	session, ok := findByAccessTokenHash(sessions, token, func(s *Session) string { return s.Token })
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &sessionEntry{session: *session, persistedAt: session.LastSeenAt}, nil
+})
+
+// createSession はセッションを作成する。コミット後に cacheSession を呼ぶこと
+// hashedToken は hashAccessToken でハッシュ化したトークン
+func createSession(ctx context.Context, tx *sqlx.Tx, role, principalID, hashedToken string, now time.Time) (*Session, error) {
+	session := &Session{
+		ID:          ulid.Make().String(),
+		Token:       hashedToken,
+		TokenPrefix: accessTokenLookupPrefix(hashedToken),
+		Role:        role,
+		PrincipalID: principalID,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(time.Duration(appConfig.Session.TTL)),
+	}
+	if _, err := tx.NamedExecContext(
+		ctx,
+		`INSERT INTO sessions (id, token, token_prefix, role, principal_id, created_at, last_seen_at, expires_at) VALUES (:id, :token, :token_prefix, :role, :principal_id, :created_at, :last_seen_at, :expires_at)`,
+		session,
+	); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func cacheSession(session *Session) {
+	sessionCache.Store(session.Token, &sessionEntry{session: *session, persistedAt: session.LastSeenAt})
+}
+
+// createPrincipalCredential は登録時に発行したトークンを再ログイン用の資格情報として保存する
+// アクセストークンは再ログインのたびに変わるが、資格情報は変わらない
+func createPrincipalCredential(ctx context.Context, tx *sqlx.Tx, role, principalID, hashedToken string, now time.Time) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO principal_credentials (role, principal_id, token_prefix, token, created_at) VALUES (?, ?, ?, ?, ?)`,
+		role, principalID, accessTokenLookupPrefix(hashedToken), hashedToken, now,
+	)
+	return err
+}
+
+// principalAccessTokens はロールごとの、利用者の行をロックするクエリとアクセストークンを書き換えるクエリ、キャッシュの名前
+var principalAccessTokens = map[string]struct {
+	selectQuery string
+	updateQuery string
+	cache       string
+}{
+	sessionRoleApp: {
+		selectQuery: `SELECT id FROM users WHERE id = ? FOR UPDATE`,
+		updateQuery: `UPDATE users SET access_token = ?, updated_at = updated_at WHERE id = ?`,
+		cache:       "user",
+	},
+	sessionRoleOwner: {
+		selectQuery: `SELECT id FROM owners WHERE id = ? FOR UPDATE`,
+		updateQuery: `UPDATE owners SET access_token = ?, updated_at = updated_at WHERE id = ?`,
+		cache:       "owner",
+	},
+	sessionRoleChair: {
+		selectQuery: `SELECT id FROM chairs WHERE id = ? FOR UPDATE`,
+		updateQuery: `UPDATE chairs SET access_token = ?, updated_at = updated_at WHERE id = ?`,
+		cache:       "chair",
+	},
+}
+
+type postSessionsResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// postSessions は登録時に発行したトークンを Authorization: Bearer で受け取って再ログインさせる
+// ログアウトや失効、有効期限切れの後でも使える。新しいセッションのトークンをアクセストークンにするので、
+// それまでのセッションはすべて失効させる
+func postSessions(role string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		credential, ok := bearerToken(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errors.New("bearer token is required"))
+			return
+		}
+
+		hashedCredential := hashAccessToken(credential)
+		credentials := []PrincipalCredential{}
+		if err := db.SelectContext(
+			ctx,
+			&credentials,
+			`SELECT * FROM principal_credentials WHERE role = ? AND token_prefix = ?`,
+			role, accessTokenLookupPrefix(hashedCredential),
+		); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		found, ok := findByAccessTokenHash(credentials, hashedCredential, func(c *PrincipalCredential) string { return c.Token })
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid credential"))
+			return
+		}
+		principalID := found.PrincipalID
+
+		if _, err := revokeSessionsByPrincipal(ctx, role, principalID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		accessToken := secureRandomStr(32)
+		hashedToken := hashAccessToken(accessToken)
+		queries := principalAccessTokens[role]
+
+		tx, err := db.Beginx()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer tx.Rollback()
+
+		var lockedID string
+		if err := tx.GetContext(ctx, &lockedID, queries.selectQuery, principalID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeError(w, http.StatusUnauthorized, errors.New("invalid credential"))
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if _, err := tx.ExecContext(ctx, queries.updateQuery, hashedToken, principalID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		session, err := createSession(ctx, tx, role, principalID, hashedToken, time.Now())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		invalidateTokenCache(queries.cache, principalID)
+		cacheSession(session)
+
+		setSessionCookie(w, role, accessToken)
+
+		writeJSON(w, http.StatusCreated, &postSessionsResponse{AccessToken: accessToken})
+	}
+}
+
+func setSessionCookie(w http.ResponseWriter, role, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     sessionCookieNames[role],
+		Value:    token,
+		MaxAge:   int(time.Duration(appConfig.Session.TTL).Seconds()),
+		HttpOnly: true,
+		Secure:   appConfig.Session.CookieSecure,
+		SameSite: appConfig.Session.sameSite(),
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter, role string) {
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     sessionCookieNames[role],
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   appConfig.Session.CookieSecure,
+		SameSite: appConfig.Session.sameSite(),
+	})
+}
+
+// authenticateSession はクッキーか Authorization: Bearer で渡されたセッションを検証し、有効期限を延長する
+// エラーの場合はレスポンスのステータスコードも返す
+func authenticateSession(w http.ResponseWriter, r *http.Request, role string) (*Session, int, error) {
+	cookieName := sessionCookieNames[role]
+	token := ""
+	fromCookie := false
+	if c, err := r.Cookie(cookieName); err == nil && c.Value != "" {
+		token = c.Value
+		fromCookie = true
+	} else if t, ok := bearerToken(r); ok {
+		token = t
+	} else {
+		return nil, http.StatusUnauthorized, fmt.Errorf("%s cookie or bearer token is required", cookieName)
+	}
+
+	hashedToken := hashAccessToken(token)
+	entry, ok, err := sessionCache.Get(r.Context(), hashedToken)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if !ok {
+		return nil, http.StatusUnauthorized, errors.New("invalid access token")
+	}
+
+	now := time.Now()
+	entry.mu.Lock()
+	if entry.session.Role != role || entry.session.RevokedAt != nil {
+		entry.mu.Unlock()
+		return nil, http.StatusUnauthorized, errors.New("invalid access token")
+	}
+	if now.After(entry.session.ExpiresAt) {
+		entry.mu.Unlock()
+		return nil, http.StatusUnauthorized, errors.New("session expired")
+	}
+	entry.session.LastSeenAt = now
+	entry.session.ExpiresAt = now.Add(time.Duration(appConfig.Session.TTL))
+	persist := now.Sub(entry.persistedAt) >= time.Duration(appConfig.Session.TouchInterval)
+	if persist {
+		entry.persistedAt = now
+	}
+	session := entry.session
+	entry.mu.Unlock()
+
+	// 延長はリクエストのたびに書き込まず、TouchInterval ごとにまとめて反映する
+	if persist {
+		if _, err := db.ExecContext(
+			r.Context(),
+			`UPDATE sessions SET last_seen_at = ?, expires_at = ? WHERE id = ? AND revoked_at IS NULL`,
+			session.LastSeenAt, session.ExpiresAt, session.ID,
+		); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		// Bearer で使っているクライアントにはクッキーを発行しない
+		if fromCookie {
+			setSessionCookie(w, role, token)
+		}
+	}
+
+	return &session, 0, nil
+}
+
+// revokeSessionByID はセッションを失効させ、失効させた件数を返す
+func revokeSessionByID(ctx context.Context, id string) (int, error) {
+	sessions := []Session{}
+	if err := db.SelectContext(ctx, &sessions, `SELECT * FROM sessions WHERE id = ? AND revoked_at IS NULL`, id); err != nil {
+		return 0, err
+	}
+	return revokeSessionList(ctx, sessions)
+}
+
+// revokeSessionsByPrincipal は利用者のすべてのセッションを失効させ、失効させた件数を返す
+func revokeSessionsByPrincipal(ctx context.Context, role, principalID string) (int, error) {
+	sessions := []Session{}
+	if err := db.SelectContext(ctx, &sessions, `SELECT * FROM sessions WHERE role = ? AND principal_id = ? AND revoked_at IS NULL`, role, principalID); err != nil {
+		return 0, err
+	}
+	return revokeSessionList(ctx, sessions)
+}
+
+// revokeSessionsByRole はロールのすべてのセッションを失効させ、失効させた件数を返す
+func revokeSessionsByRole(ctx context.Context, role string) (int, error) {
+	sessions := []Session{}
+	if err := db.SelectContext(ctx, &sessions, `SELECT * FROM sessions WHERE role = ? AND revoked_at IS NULL`, role); err != nil {
+		return 0, err
+	}
+	return revokeSessionList(ctx, sessions)
+}
+
+func revokeSessionList(ctx context.Context, sessions []Session) (int, error) {
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+	ids := make([]string, 0, len(sessions))
+	tokens := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+		tokens = append(tokens, session.Token)
+	}
+	query, args, err := sqlx.In(`UPDATE sessions SET revoked_at = ? WHERE id IN (?) AND revoked_at IS NULL`, time.Now(), ids)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return 0, err
+	}
+	invalidateTokenCache("session", tokens...)
+	return len(sessions), nil
+}
+
+// postLogout は今のセッションを失効させてクッキーを消す
+func postLogout(role string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session, ok := SessionFrom(ctx)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errUnauthenticated)
+			return
+		}
+
+		if _, err := revokeSessionByID(ctx, session.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		clearSessionCookie(w, role)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type adminSession struct {
+	ID          string `json:"id"`
+	Role        string `json:"role"`
+	PrincipalID string `json:"principal_id"`
+	CreatedAt   int64  `json:"created_at"`
+	LastSeenAt  int64  `json:"last_seen_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+	RevokedAt   *int64 `json:"revoked_at,omitempty"`
+}
+
+type adminGetSessionsResponse struct {
+	Sessions []adminSession `json:"sessions"`
+}
+
+// adminGetSessions は利用者のセッション一覧を返す
+// last_seen_at と expires_at はDBに書き込まれた値なので、最大で TouchInterval だけ古い
+func adminGetSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	role := r.URL.Query().Get("role")
+	principalID := r.URL.Query().Get("principal_id")
+	if _, ok := sessionCookieNames[role]; !ok || principalID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("required query parameters(role, principal_id) are invalid"))
+		return
+	}
+
+	sessions := []Session{}
+	if err := db.SelectContext(ctx, &sessions, `SELECT * FROM sessions WHERE role = ? AND principal_id = ? ORDER BY created_at DESC`, role, principalID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := adminGetSessionsResponse{Sessions: []adminSession{}}
+	for _, session := range sessions {
+		s := adminSession{
+			ID:          session.ID,
+			Role:        session.Role,
+			PrincipalID: session.PrincipalID,
+			CreatedAt:   session.CreatedAt.UnixMilli(),
+			LastSeenAt:  session.LastSeenAt.UnixMilli(),
+			ExpiresAt:   session.ExpiresAt.UnixMilli(),
+		}
+		if session.RevokedAt != nil {
+			t := session.RevokedAt.UnixMilli()
+			s.RevokedAt = &t
+		}
+		res.Sessions = append(res.Sessions, s)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+type adminPostSessionsRevokeRequest struct {
+	// SessionID を指定するとそのセッションだけ、Role と PrincipalID を指定するとその利用者の全セッションを、
+	// Role だけを指定するとそのロールの全セッションを失効させる。利用者は登録時のトークンで再ログインできる
+	SessionID   string `json:"session_id"`
+	Role        string `json:"role" validate:"oneof=app owner chair"`
+	PrincipalID string `json:"principal_id"`
+}
+
+type adminPostSessionsRevokeResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+func adminPostSessionsRevoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := &adminPostSessionsRevokeRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var revoked int
+	var err error
+	switch {
+	case req.SessionID != "":
+		revoked, err = revokeSessionByID(ctx, req.SessionID)
+	case req.PrincipalID != "":
+		if _, ok := sessionCookieNames[req.Role]; !ok {
+			writeError(w, http.StatusBadRequest, errors.New("role is invalid"))
+			return
+		}
+		revoked, err = revokeSessionsByPrincipal(ctx, req.Role, req.PrincipalID)
+	case req.Role != "":
+		revoked, err = revokeSessionsByRole(ctx, req.Role)
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("session_id, principal_id or role is required"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &adminPostSessionsRevokeResponse{Revoked: revoked})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrincipalAccessTokensCoverRoles(t *testing.T) {
+	// 再ログインはどのロールでもアクセストークンを差し替えられること
+	for role := range sessionCookieNames {
+		queries, ok := principalAccessTokens[role]
+		if !ok {
+			t.Errorf("principalAccessTokens has no entry for %s", role)
+			continue
+		}
+		if _, ok := getTokenCache(queries.cache); !ok {
+			t.Errorf("principalAccessTokens[%s].cache = %q, want a token cache name", role, queries.cache)
+		}
+	}
+}
+
+func TestAppAuthMiddlewareLooksUpSessionByPrefix(t *testing.T) {
+	const token = "token"
+	hashed := hashAccessToken(token)
+	now := time.Now()
+	user := User{ID: "user1", Username: "user", AccessToken: hashed, CreatedAt: now, UpdatedAt: now}
+	session := func(id, token string) Session {
+		return Session{
+			ID: id, Token: token, TokenPrefix: accessTokenLookupPrefix(token), Role: sessionRoleApp, PrincipalID: user.ID,
+			CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour),
+		}
+	}
+	// 先頭だけが同じ別のセッション
+	other := session("session0", accessTokenHashPrefix+accessTokenLookupPrefix(hashed)+"0000")
+
+	tests := []struct {
+		name       string
+		candidates []any
+		wantStatus int
+	}{
+		{name: "valid", candidates: []any{other, session("session1", hashed)}, wantStatus: http.StatusNoContent},
+		{name: "same prefix only", candidates: []any{other}, wantStatus: http.StatusUnauthorized},
+		{name: "no session", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionCache.Clear()
+			userTokenCache.Clear()
+			t.Cleanup(func() {
+				sessionCache.Clear()
+				userTokenCache.Clear()
+			})
+			f := newFakeDB(t)
+			// ハッシュではなく先頭部分で候補を引く
+			f.returns(`^SELECT \* FROM sessions WHERE token_prefix = \?$`, tt.candidates...).with(accessTokenLookupPrefix(hashed))
+			f.returns(`^SELECT \* FROM users WHERE id = \?$`, user).with(user.ID)
+
+			handler := appAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, ok := UserFrom(r.Context()); !ok || got.ID != user.ID {
+					t.Errorf("UserFrom() = %+v, %v", got, ok)
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			req := newRequest(http.MethodGet, "/api/app/rides", "")
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPostSessionsLooksUpCredentialByPrefix(t *testing.T) {
+	const credential = "credential"
+	hashed := hashAccessToken(credential)
+	now := time.Now()
+	found := PrincipalCredential{Role: sessionRoleApp, PrincipalID: "user1", TokenPrefix: accessTokenLookupPrefix(hashed), Token: hashed, CreatedAt: now}
+	other := PrincipalCredential{Role: sessionRoleApp, PrincipalID: "user2", TokenPrefix: found.TokenPrefix, Token: found.Token + "0", CreatedAt: now}
+
+	newLogin := func() *http.Request {
+		req := newRequest(http.MethodPost, "/api/app/sessions", "")
+		req.Header.Set("Authorization", "Bearer "+credential)
+		return req
+	}
+	t.Cleanup(sessionCache.Clear)
+	runHandlerTests(t, postSessions(sessionRoleApp), []handlerTest{
+		{
+			name: "valid",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM principal_credentials WHERE role = \? AND token_prefix = \?`, other, found).with(sessionRoleApp, found.TokenPrefix)
+				f.returns(`FROM sessions WHERE role = \? AND principal_id = \?`)
+				f.returns(`SELECT id FROM users WHERE id = \? FOR UPDATE`, "user1")
+				f.exec(`UPDATE users SET access_token`, 1)
+				f.exec(`INSERT INTO sessions`, 1)
+			},
+			req:        newLogin(),
+			wantStatus: http.StatusCreated,
+			check: func(t *testing.T, f *fakeDB, body []byte) {
+				res := decodeJSON[postSessionsResponse](t, body)
+				inserts := f.executed(`INSERT INTO sessions`)
+				if len(inserts) != 1 {
+					t.Fatalf("INSERT INTO sessions executed %d times, want 1", len(inserts))
+				}
+				// 新しいセッションにはトークンのハッシュと、その先頭部分を保存する
+				newHash := hashAccessToken(res.AccessToken)
+				if inserts[0].Args[1] != newHash || inserts[0].Args[2] != accessTokenLookupPrefix(newHash) {
+					t.Errorf("INSERT INTO sessions args = %v", inserts[0].Args)
+				}
+			},
+		},
+		{
+			name: "same prefix only",
+			setup: func(f *fakeDB) {
+				f.returns(`FROM principal_credentials WHERE role = \? AND token_prefix = \?`, other)
+			},
+			req:        newLogin(),
+			wantStatus: http.StatusUnauthorized,
+		},
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucon14/webapp/go/isuutil"
+)
+
+type tokenCacheEntry[T any] struct {
+	value T
+	found bool
+	// expiresAt は存在しなかった場合の有効期限
+	expiresAt time.Time
+}
+
+// tokenCache はトークンや利用者のIDから利用者やセッションを引くキャッシュ
+// キャッシュにない場合はDBから読み込むので、他のサーバーで登録されたトークンも使える
+type tokenCache[T any] struct {
+	entries sync.Map
+	group   *isuutil.SingleFlightGroup[tokenCacheEntry[T]]
+	load    func(ctx context.Context, token string) (T, error)
+
+	// mu は読み込んだ値の保存と Delete を排他にする
+	// generation は Delete のたびに増やし、読み込み中に消されたトークンの古い値を保存しないようにする
+	mu         sync.Mutex
+	generation uint64
+}
+
+// newTokenCache は load でDBから読み込むキャッシュを作る。load は見つからない場合に sql.ErrNoRows を返すこと
+func newTokenCache[T any](load func(ctx context.Context, token string) (T, error)) *tokenCache[T] {
+	return &tokenCache[T]{
+		group: isuutil.NewSingleFlightGroup[tokenCacheEntry[T]](),
+		load:  load,
+	}
+}
+
+func (c *tokenCache[T]) Get(ctx context.Context, token string) (T, bool, error) {
+	var zero T
+	if item, ok := c.entries.Load(token); ok {
+		entry := item.(tokenCacheEntry[T])
+		if entry.found {
+			return entry.value, true, nil
+		}
+		if time.Now().Before(entry.expiresAt) {
+			return zero, false, nil
+		}
+	}
+
+	// 同じトークンの読み込みは1回にまとめる。先に来たリクエストが切断されても他を巻き込まないようにする
+	ctx = context.WithoutCancel(ctx)
+	entry, err, _ := c.group.Do(token, func() (tokenCacheEntry[T], error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		value, err := c.load(ctx, token)
+		entry := tokenCacheEntry[T]{value: value, found: true}
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return tokenCacheEntry[T]{}, err
+			}
+			entry = tokenCacheEntry[T]{expiresAt: time.Now().Add(time.Duration(appConfig.TokenCache.NegativeTTL))}
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// 読み込み中に Delete された場合、読んだ値は古いかもしれないので保存しない
+		if c.generation != generation {
+			return entry, nil
+		}
+		// 読み込み中に Store された値があればそちらを優先する
+		if item, ok := c.entries.Load(token); ok && entry.found {
+			if existing := item.(tokenCacheEntry[T]); existing.found {
+				return existing, nil
+			}
+		}
+		c.entries.Store(token, entry)
+		return entry, nil
+	})
+	if err != nil {
+		return zero, false, err
+	}
+	return entry.value, entry.found, nil
+}
+
+// Store は新しく作った値を保存する。既存の値の一部を書き換える場合は、古い値で上書きしないよう Update を使う
+func (c *tokenCache[T]) Store(token string, value T) {
+	c.entries.Store(token, tokenCacheEntry[T]{value: value, found: true})
+}
+
+// Update はキャッシュにある値を update で書き換える。キャッシュにない場合は何もしない
+func (c *tokenCache[T]) Update(token string, update func(value T) T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.entries.Load(token); ok {
+		if entry := item.(tokenCacheEntry[T]); entry.found {
+			c.entries.Store(token, tokenCacheEntry[T]{value: update(entry.value), found: true})
+		}
+	}
+}
+
+func (c *tokenCache[T]) Delete(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries.Delete(token)
+}
+
+func (c *tokenCache[T]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries.Clear()
+}
+
+// tokenCachePeers は同じDBを使う他のサーバー。キャッシュを更新したら無効化を通知する
+var tokenCachePeers []string
+
+type internalPostTokenCacheInvalidateRequest struct {
+	// Cache は user, owner, chair, session, api_key のいずれか
+	Cache string `json:"cache" validate:"required,oneof=user owner chair session api_key"`
+	// Tokens はキャッシュのキー。user, owner, chair では利用者のID
+	Tokens []string `json:"tokens"`
+}
+
+func getTokenCache(name string) (interface{ Delete(string) }, bool) {
+	switch name {
+	case "user":
+		return userTokenCache, true
+	case "owner":
+		return ownerTokenCache, true
+	case "chair":
+		return chairTokenCache, true
+	case "session":
+		return sessionCache, true
+	case "api_key":
+		return ownerAPIKeyCache, true
+	}
+	return nil, false
+}
+
+// invalidateTokenCache は自分のキャッシュから消し、他のサーバーにも非同期で無効化を通知する
+func invalidateTokenCache(name string, tokens ...string) {
+	cache, ok := getTokenCache(name)
+	if !ok || len(tokens) == 0 {
+		return
+	}
+	for _, token := range tokens {
+		cache.Delete(token)
+	}
+	if len(tokenCachePeers) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(&internalPostTokenCacheInvalidateRequest{Cache: name, Tokens: tokens})
+	if err != nil {
+		slog.Error("failed to marshal token cache invalidation", "error", err)
+		return
+	}
+	for _, peer := range tokenCachePeers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+"/api/internal/token-cache/invalidate", bytes.NewReader(body))
+			if err != nil {
+				slog.Error("failed to create token cache invalidation request", "peer", peer, "error", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+appConfig.TokenCache.InvalidationToken)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				slog.Error("failed to notify token cache invalidation", "peer", peer, "error", err)
+				return
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusNoContent {
+				slog.Error("unexpected status from token cache invalidation", "peer", peer, "status", res.StatusCode)
+			}
+		}(peer)
+	}
+}
+
+// internalPostTokenCacheInvalidate は他のサーバーからの無効化の通知を受けて、キャッシュから消す
+// 次に使われたときにDBから読み直される。token_cache.peers を設定した場合だけ登録する
+func internalPostTokenCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	if appConfig.TokenCache.InvalidationToken == "" {
+		writeError(w, http.StatusForbidden, errors.New("token cache invalidation is disabled"))
+		return
+	}
+	token, ok := bearerToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(appConfig.TokenCache.InvalidationToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid invalidation token"))
+		return
+	}
+
+	req := &internalPostTokenCacheInvalidateRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cache, ok := getTokenCache(req.Cache)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errors.New("unknown cache"))
+		return
+	}
+	for _, token := range req.Tokens {
+		cache.Delete(token)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestTokenCache(t *testing.T) {
+	loads := 0
+	cache := newTokenCache(func(ctx context.Context, token string) (string, error) {
+		loads++
+		if token == "missing" {
+			return "", sql.ErrNoRows
+		}
+		return "loaded:" + token, nil
+	})
+	ctx := context.Background()
+
+	got, ok, err := cache.Get(ctx, "a")
+	if err != nil || !ok || got != "loaded:a" {
+		t.Fatalf("Get(a) = %q, %v, %v", got, ok, err)
+	}
+	if _, _, _ = cache.Get(ctx, "a"); loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+
+	cache.Store("b", "stored")
+	if got, ok, _ := cache.Get(ctx, "b"); !ok || got != "stored" || loads != 1 {
+		t.Errorf("Get(b) = %q, %v, loads = %d", got, ok, loads)
+	}
+
+	if _, ok, err := cache.Get(ctx, "missing"); ok || err != nil {
+		t.Fatalf("Get(missing) = %v, %v", ok, err)
+	}
+	if _, ok, _ := cache.Get(ctx, "missing"); ok || loads != 2 {
+		t.Errorf("negative entry should be cached: ok = %v, loads = %d", ok, loads)
+	}
+
+	// 期限切れの否定キャッシュはDBを読み直す
+	cache.entries.Store("missing", tokenCacheEntry[string]{expiresAt: time.Now().Add(-time.Second)})
+	if _, _, _ = cache.Get(ctx, "missing"); loads != 3 {
+		t.Errorf("loads = %d, want 3", loads)
+	}
+
+	cache.Delete("a")
+	if _, _, _ = cache.Get(ctx, "a"); loads != 4 {
+		t.Errorf("loads = %d, want 4", loads)
+	}
+}
+
+func TestTokenCacheDeleteDuringLoad(t *testing.T) {
+	var cache *tokenCache[string]
+	cache = newTokenCache(func(ctx context.Context, token string) (string, error) {
+		// 読み込み中に他のリクエストが値を書き換えて無効化した
+		cache.Delete(token)
+		return "stale", nil
+	})
+
+	if got, ok, err := cache.Get(context.Background(), "a"); err != nil || !ok || got != "stale" {
+		t.Fatalf("Get(a) = %q, %v, %v", got, ok, err)
+	}
+	if _, ok := cache.entries.Load("a"); ok {
+		t.Error("value loaded before Delete should not be cached")
+	}
+}
+
+func TestTokenCacheUpdate(t *testing.T) {
+	cache := newTokenCache(func(ctx context.Context, token string) (int, error) {
+		return 0, sql.ErrNoRows
+	})
+	add := func(v int) int { return v + 1 }
+
+	cache.Update("a", add)
+	if _, ok := cache.entries.Load("a"); ok {
+		t.Error("Update() should not add a missing entry")
+	}
+
+	cache.Store("a", 1)
+	cache.Update("a", add)
+	if got, ok, _ := cache.Get(context.Background(), "a"); !ok || got != 2 {
+		t.Errorf("Get(a) = %d, %v, want 2", got, ok)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type trailPoint struct {
+	Latitude   int   `json:"latitude"`
+	Longitude  int   `json:"longitude"`
+	RecordedAt int64 `json:"recorded_at"`
+}
+
+// simplifyTrail は Douglas–Peucker 法で軌跡を間引く
+// 始点と終点は必ず残し、線分からの距離が tolerance 以下の点を取り除く
+// 座標は整数グリッド上にあるので tolerance=0 でも一直線上の中間点はすべて消える
+func simplifyTrail(points []trailPoint, tolerance float64) []trailPoint {
+	if len(points) <= 2 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0] = true
+	keep[len(points)-1] = true
+
+	// 再帰だと長い軌跡でスタックが深くなるので、区間をスタックに積んで処理する
+	type span struct{ start, end int }
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		maxDistance := -1.0
+		index := -1
+		for i := s.start + 1; i < s.end; i++ {
+			d := segmentDistance(points[i], points[s.start], points[s.end])
+			if d > maxDistance {
+				maxDistance = d
+				index = i
+			}
+		}
+		if index < 0 || maxDistance <= tolerance {
+			continue
+		}
+		keep[index] = true
+		stack = append(stack, span{s.start, index}, span{index, s.end})
+	}
+
+	simplified := make([]trailPoint, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// segmentDistance は点pと線分abとのユークリッド距離を返す
+// 線分の外側へ引き返した点を残すため、直線ではなく線分の端までの距離を測る
+func segmentDistance(p, a, b trailPoint) float64 {
+	dx := float64(b.Latitude - a.Latitude)
+	dy := float64(b.Longitude - a.Longitude)
+	px := float64(p.Latitude - a.Latitude)
+	py := float64(p.Longitude - a.Longitude)
+	if dx == 0 && dy == 0 {
+		return math.Hypot(px, py)
+	}
+	t := (px*dx + py*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(px-t*dx, py-t*dy)
+}
+
+type ownerGetChairTrailResponse struct {
+	ChairID string       `json:"chair_id"`
+	Points  []trailPoint `json:"points"`
+}
+
+func ownerGetChairTrail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := OwnerFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	chairID := r.PathValue("chair_id")
+
+	since, err := parseQueryUnixMilli(r, "since", time.Unix(0, 0))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	until, err := parseQueryUnixMilli(r, "until", time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tolerance := 0.0
+	if r.URL.Query().Get("tolerance") != "" {
+		parsed, err := strconv.ParseFloat(r.URL.Query().Get("tolerance"), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, newFieldError("tolerance", "number", "tolerance must be a number"))
+			return
+		}
+		if parsed < 0 {
+			writeError(w, http.StatusBadRequest, newFieldError("tolerance", "min", "tolerance must be at least 0"))
+			return
+		}
+		tolerance = parsed
+	}
+
+	chair := &Chair{}
+	if err := db.GetContext(ctx, chair, "SELECT * FROM chairs WHERE id = ? AND owner_id = ?", chairID, owner.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("chair not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// アーカイブ済みの位置情報も含めて軌跡を組み立てる
+	locations := []ChairLocation{}
+	if err := db.SelectContext(ctx, &locations, `
+		SELECT * FROM (
+			SELECT * FROM chair_locations WHERE chair_id = ? AND created_at BETWEEN ? AND ? + INTERVAL 999 MICROSECOND
+			UNION ALL
+			SELECT * FROM chair_locations_archive WHERE chair_id = ? AND created_at BETWEEN ? AND ? + INTERVAL 999 MICROSECOND
+		) locations
+		ORDER BY created_at`,
+		chair.ID, since, until, chair.ID, since, until,
+	); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	points := make([]trailPoint, 0, len(locations))
+	for _, location := range locations {
+		points = append(points, trailPoint{
+			Latitude:   location.Latitude,
+			Longitude:  location.Longitude,
+			RecordedAt: location.CreatedAt.UnixMilli(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, &ownerGetChairTrailResponse{
+		ChairID: chair.ID,
+		Points:  simplifyTrail(points, tolerance),
+	})
+}
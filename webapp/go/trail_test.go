@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSimplifyTrail(t *testing.T) {
+	p := func(lat, lon int) trailPoint {
+		return trailPoint{Latitude: lat, Longitude: lon}
+	}
+
+	tests := []struct {
+		name      string
+		points    []trailPoint
+		tolerance float64
+		want      []trailPoint
+	}{
+		{
+			name:   "short trail is returned as is",
+			points: []trailPoint{p(0, 0), p(3, 4)},
+			want:   []trailPoint{p(0, 0), p(3, 4)},
+		},
+		{
+			name:   "collinear points are removed",
+			points: []trailPoint{p(0, 0), p(1, 0), p(2, 0), p(3, 0)},
+			want:   []trailPoint{p(0, 0), p(3, 0)},
+		},
+		{
+			name:   "corner is kept",
+			points: []trailPoint{p(0, 0), p(1, 0), p(2, 0), p(2, 1), p(2, 2)},
+			want:   []trailPoint{p(0, 0), p(2, 0), p(2, 2)},
+		},
+		{
+			name:      "small deviation within tolerance is removed",
+			points:    []trailPoint{p(0, 0), p(5, 1), p(10, 0)},
+			tolerance: 1,
+			want:      []trailPoint{p(0, 0), p(10, 0)},
+		},
+		{
+			name:   "round trip keeps the turning point",
+			points: []trailPoint{p(0, 0), p(5, 0), p(0, 0)},
+			want:   []trailPoint{p(0, 0), p(5, 0), p(0, 0)},
+		},
+		{
+			name:   "backtracking past the end point is kept",
+			points: []trailPoint{p(0, 0), p(8, 0), p(5, 0)},
+			want:   []trailPoint{p(0, 0), p(8, 0), p(5, 0)},
+		},
+		{
+			name:   "backtracking behind the start point is kept",
+			points: []trailPoint{p(5, 0), p(0, 0), p(10, 0)},
+			want:   []trailPoint{p(5, 0), p(0, 0), p(10, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := simplifyTrail(tt.points, tt.tolerance)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("simplifyTrail() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
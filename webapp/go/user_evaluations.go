@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ride.low_rated_user_evaluation 未満の平均評価のユーザーはマッチングで後回しにする
+// 評価が lowRatedUserMinEvaluations 件に満たないユーザーは対象外
+const lowRatedUserMinEvaluations = 3
+
+// updateRideUserEvaluationQuery は椅子からユーザーへの評価を記録する
+// rides.updated_at は椅子の最新のライドや売上、完了日時に使うので、評価では変えない
+const updateRideUserEvaluationQuery = `UPDATE rides SET user_evaluation = ?, updated_at = updated_at WHERE id = ?`
+
+type userEvaluationStats struct {
+	UserID string  `db:"user_id"`
+	Avg    float64 `db:"avg"`
+	Count  int     `db:"count"`
+}
+
+// getUserEvaluationStats は椅子からユーザーへの評価の平均と件数を返す。評価のないユーザーは含まない
+func getUserEvaluationStats(ctx context.Context, q sqlx.QueryerContext, userIDs []string) (map[string]userEvaluationStats, error) {
+	result := map[string]userEvaluationStats{}
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sqlx.In(
+		`SELECT user_id, AVG(user_evaluation) AS avg, COUNT(user_evaluation) AS count
+		FROM rides
+		WHERE user_id IN (?) AND user_evaluation IS NOT NULL
+		GROUP BY user_id`,
+		userIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stats := []userEvaluationStats{}
+	if err := sqlx.SelectContext(ctx, q, &stats, query, args...); err != nil {
+		return nil, err
+	}
+	for _, s := range stats {
+		result[s.UserID] = s
+	}
+	return result, nil
+}
+
+func isLowRatedUser(stats userEvaluationStats) bool {
+	return stats.Count >= lowRatedUserMinEvaluations && stats.Avg < appConfig.Ride.LowRatedUserEvaluation
+}
+
+type chairPostRideEvaluationRequest struct {
+	Evaluation int `json:"evaluation" validate:"min=1,max=5"`
+}
+
+// chairPostRideEvaluation は椅子がユーザーを評価する。目的地に到着した後(ARRIVED, COMPLETED)に1回だけ評価できる
+func chairPostRideEvaluation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	chair, ok := ChairFrom(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, errUnauthenticated)
+		return
+	}
+
+	rideID := r.PathValue("ride_id")
+
+	req := &chairPostRideEvaluationRequest{}
+	if err := bindJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	ride := &Ride{}
+	if err := tx.GetContext(ctx, ride, `SELECT * FROM rides WHERE id = ? FOR UPDATE`, rideID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("ride not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if ride.ChairID.String != chair.ID {
+		writeError(w, http.StatusBadRequest, errors.New("not assigned to this ride"))
+		return
+	}
+
+	status, err := getLatestRideStatus(ctx, tx, ride.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if status != "ARRIVED" && status != "COMPLETED" {
+		writeError(w, http.StatusBadRequest, errors.New("not arrived yet"))
+		return
+	}
+	if ride.UserEvaluation != nil {
+		writeError(w, http.StatusConflict, errors.New("already evaluated"))
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, updateRideUserEvaluationQuery, req.Evaluation, ride.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
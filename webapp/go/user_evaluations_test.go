@@ -0,0 +1,13 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestUpdateRideUserEvaluationQueryKeepsUpdatedAt(t *testing.T) {
+	// rides.updated_at は ON UPDATE CURRENT_TIMESTAMP なので、明示的に元の値を入れないと評価したライドが椅子の最新のライドに戻ってしまう
+	if !regexp.MustCompile(`\bupdated_at\s*=\s*updated_at\b`).MatchString(updateRideUserEvaluationQuery) {
+		t.Errorf("query %q must keep rides.updated_at", updateRideUserEvaluationQuery)
+	}
+}
@@ -0,0 +1,188 @@
+// Package validation はリクエストの構造体を validate タグで検証する。
+//
+// 使えるルールは次のとおり。カンマ区切りで複数指定できる。
+//
+//	required    文字列・スライスが空でない、ポインタが nil でない
+//	min=N       数値は N 以上、文字列は N 文字以上、スライスは N 要素以上
+//	max=N       数値は N 以下、文字列は N 文字以下、スライスは N 要素以下
+//	oneof=a b   文字列がいずれかに一致する
+//	date        文字列が YYYY-MM-DD 形式の日付
+//
+// required 以外のルールは、ポインタが nil の場合と、ポインタでない文字列・スライスが空の場合は検証しない。
+// ポインタのフィールドは JSON で指定された値として、空文字列でも検証する。
+// 構造体のフィールドと、構造体のスライスの要素は再帰的に検証する。
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError はフィールドごとの検証エラー
+type FieldError struct {
+	// Field は JSON でのフィールドのパス。例: pickup_coordinate.latitude, coordinates[0].timestamp
+	Field string `json:"field"`
+	// Code は満たさなかったルール名
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors は検証エラーの一覧
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fe.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Struct は v の validate タグを検証する。問題がなければ nil を返す
+// 不正なタグは実装ミスなので panic する
+func Struct(v any) Errors {
+	var errs Errors
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateValue(v reflect.Value, path string, errs *Errors) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := joinPath(path, jsonName(field))
+			if tag := field.Tag.Get("validate"); tag != "" {
+				if !validateField(v.Field(i), fieldPath, tag, errs) {
+					continue
+				}
+			}
+			validateValue(v.Field(i), fieldPath, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// validateField はフィールドのルールを検証し、すべて満たした場合に true を返す
+func validateField(v reflect.Value, path, tag string, errs *Errors) bool {
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if name == "required" {
+			if isEmpty(v) {
+				*errs = append(*errs, FieldError{Field: path, Code: "required", Message: fmt.Sprintf("%s is required", path)})
+				return false
+			}
+			continue
+		}
+
+		target := v
+		for target.Kind() == reflect.Pointer {
+			if target.IsNil() {
+				return true
+			}
+			target = target.Elem()
+		}
+		if v.Kind() != reflect.Pointer && (target.Kind() == reflect.String || target.Kind() == reflect.Slice) && target.Len() == 0 {
+			continue
+		}
+
+		if message, ok := checkRule(target, name, param, path); !ok {
+			*errs = append(*errs, FieldError{Field: path, Code: name, Message: message})
+			return false
+		}
+	}
+	return true
+}
+
+func checkRule(v reflect.Value, name, param, path string) (string, bool) {
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: invalid %s parameter %q", name, param))
+		}
+		var n float64
+		var unit string
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		case reflect.String:
+			n = float64(utf8.RuneCountInString(v.String()))
+			unit = " characters"
+		case reflect.Slice, reflect.Array:
+			n = float64(v.Len())
+			unit = " items"
+		default:
+			panic(fmt.Sprintf("validation: %s is not supported for %s", name, v.Kind()))
+		}
+		if name == "min" && n < limit {
+			return fmt.Sprintf("%s must be at least %s%s", path, param, unit), false
+		}
+		if name == "max" && n > limit {
+			return fmt.Sprintf("%s must be at most %s%s", path, param, unit), false
+		}
+	case "oneof":
+		options := strings.Fields(param)
+		if !slices.Contains(options, v.String()) {
+			return fmt.Sprintf("%s must be one of %s", path, strings.Join(options, ", ")), false
+		}
+	case "date":
+		if _, err := time.Parse(time.DateOnly, v.String()); err != nil {
+			return fmt.Sprintf("%s must be a date in YYYY-MM-DD format", path), false
+		}
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", name))
+	}
+	return "", true
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+type coordinate struct {
+	Latitude  int `json:"latitude" validate:"min=-100,max=100"`
+	Longitude int `json:"longitude" validate:"min=-100,max=100"`
+}
+
+type request struct {
+	Name        string       `json:"name" validate:"required,max=5"`
+	DateOfBirth string       `json:"date_of_birth" validate:"date"`
+	Status      string       `json:"status,omitempty" validate:"oneof=ENROUTE CARRYING"`
+	Evaluation  int          `json:"evaluation" validate:"min=1,max=5"`
+	MaxUses     *int         `json:"max_uses" validate:"min=1"`
+	Nickname    *string      `json:"nickname" validate:"min=1"`
+	Pickup      *coordinate  `json:"pickup_coordinate" validate:"required"`
+	Waypoints   []coordinate `json:"waypoints" validate:"max=2"`
+}
+
+func intPtr(n int) *int { return &n }
+
+func stringPtr(s string) *string { return &s }
+
+func fields(errs Errors) map[string]string {
+	m := map[string]string{}
+	for _, e := range errs {
+		m[e.Field] = e.Code
+	}
+	return m
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name string
+		req  request
+		want map[string]string
+	}{
+		{
+			name: "valid",
+			req:  request{Name: "chair", DateOfBirth: "2000-01-31", Evaluation: 5, Pickup: &coordinate{}, Waypoints: []coordinate{{1, 2}}},
+			want: map[string]string{},
+		},
+		{
+			name: "missing required",
+			req:  request{Evaluation: 1},
+			want: map[string]string{"name": "required", "pickup_coordinate": "required"},
+		},
+		{
+			name: "out of range",
+			req: request{
+				Name:        "too long",
+				DateOfBirth: "2000/01/31",
+				Status:      "ARRIVED",
+				Evaluation:  0,
+				MaxUses:     intPtr(0),
+				Nickname:    stringPtr(""),
+				Pickup:      &coordinate{Latitude: 101},
+				Waypoints:   []coordinate{{}, {Longitude: -101}, {}},
+			},
+			want: map[string]string{
+				"name":                       "max",
+				"date_of_birth":              "date",
+				"status":                     "oneof",
+				"evaluation":                 "min",
+				"max_uses":                   "min",
+				"nickname":                   "min",
+				"pickup_coordinate.latitude": "max",
+				"waypoints":                  "max",
+			},
+		},
+		{
+			name: "nested slice element",
+			req:  request{Name: "a", Evaluation: 1, Pickup: &coordinate{}, Waypoints: []coordinate{{}, {Longitude: -101}}},
+			want: map[string]string{"waypoints[1].longitude": "min"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fields(Struct(&tt.req))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Struct() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStruct_MultibyteLength(t *testing.T) {
+	if errs := Struct(&request{Name: "あいうえお", Evaluation: 1, Pickup: &coordinate{}}); errs != nil {
+		t.Errorf("Struct() = %v, want nil", errs)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxRideWaypoints は1回のライドで指定できる経由地の数の上限
+const maxRideWaypoints = 5
+
+func validateWaypoints(waypoints []Coordinate) error {
+	if len(waypoints) > maxRideWaypoints {
+		return newFieldError("waypoints", "max", fmt.Sprintf("waypoints must be at most %d items", maxRideWaypoints))
+	}
+	return nil
+}
+
+// calculateRouteDistance は配車位置から経由地を順に回って目的地に着くまでの各区間のマンハッタン距離の合計を返す
+func calculateRouteDistance(pickup Coordinate, waypoints []Coordinate, destination Coordinate) int {
+	distance := 0
+	from := pickup
+	for _, waypoint := range waypoints {
+		distance += calculateDistance(from.Latitude, from.Longitude, waypoint.Latitude, waypoint.Longitude)
+		from = waypoint
+	}
+	return distance + calculateDistance(from.Latitude, from.Longitude, destination.Latitude, destination.Longitude)
+}
+
+// rideDistance はライドの走行距離を返す。経由地がない場合は配車位置から目的地までの距離
+func rideDistance(ride *Ride) int {
+	if ride.RouteDistance != nil {
+		return *ride.RouteDistance
+	}
+	return calculateDistance(ride.PickupLatitude, ride.PickupLongitude, ride.DestinationLatitude, ride.DestinationLongitude)
+}
+
+// insertRideWaypoints は経由地を登録し、rides.route_distance に入れる走行距離を返す
+// 経由地がない場合は何もせず nil を返す
+func insertRideWaypoints(ctx context.Context, tx *sqlx.Tx, rideID string, pickup Coordinate, waypoints []Coordinate, destination Coordinate) (*int, error) {
+	if len(waypoints) == 0 {
+		return nil, nil
+	}
+
+	rows := make([]RideWaypoint, 0, len(waypoints))
+	for i, waypoint := range waypoints {
+		rows = append(rows, RideWaypoint{
+			ID:        ulid.Make().String(),
+			RideID:    rideID,
+			Position:  i + 1,
+			Latitude:  waypoint.Latitude,
+			Longitude: waypoint.Longitude,
+		})
+	}
+	if _, err := tx.NamedExecContext(
+		ctx,
+		`INSERT INTO ride_waypoints (id, ride_id, position, latitude, longitude) VALUES (:id, :ride_id, :position, :latitude, :longitude)`,
+		rows,
+	); err != nil {
+		return nil, err
+	}
+
+	distance := calculateRouteDistance(pickup, waypoints, destination)
+	return &distance, nil
+}
+
+// getPendingRideWaypoints はまだ到着していない経由地を経由する順に返す
+func getPendingRideWaypoints(ctx context.Context, tx *sqlx.Tx, ride *Ride) ([]RideWaypoint, error) {
+	waypoints := []RideWaypoint{}
+	if ride.RouteDistance == nil {
+		return waypoints, nil
+	}
+	if err := tx.SelectContext(ctx, &waypoints, `SELECT * FROM ride_waypoints WHERE ride_id = ? AND arrived_at IS NULL ORDER BY position`, ride.ID); err != nil {
+		return nil, err
+	}
+	return waypoints, nil
+}
+
+// getNextRideWaypoint は次に向かう経由地を返す。すべて回り終えているか経由地がなければ nil
+func getNextRideWaypoint(ctx context.Context, tx *sqlx.Tx, ride *Ride) (*Coordinate, error) {
+	if ride.RouteDistance == nil {
+		return nil, nil
+	}
+	waypoint := RideWaypoint{}
+	if err := tx.GetContext(ctx, &waypoint, `SELECT * FROM ride_waypoints WHERE ride_id = ? AND arrived_at IS NULL ORDER BY position LIMIT 1`, ride.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &Coordinate{Latitude: waypoint.Latitude, Longitude: waypoint.Longitude}, nil
+}
+
+// recordWaypointArrival は経由地への到着を記録する
+// 既存のクライアントに見えないよう、ステータスは CARRYING のままで通知済みとして ride_statuses に残す
+func recordWaypointArrival(ctx context.Context, tx *sqlx.Tx, arrival chairWaypointArrival, arrivedAt time.Time) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE ride_waypoints SET arrived_at = ? WHERE id = ?`, arrivedAt, arrival.WaypointID); err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO ride_statuses (id, ride_id, status, waypoint_id, app_sent_at, chair_sent_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		ulid.Make().String(), arrival.RideID, "CARRYING", arrival.WaypointID, now, now,
+	)
+	return err
+}
@@ -3,6 +3,9 @@ SET CHARACTER_SET_CONNECTION = utf8mb4;
 
 USE isuride;
 
+-- このファイルは初期状態のスキーマ。変更は webapp/go/migrations.go のマイグレーションで適用する
+DROP TABLE IF EXISTS schema_migrations;
+
 DROP TABLE IF EXISTS settings;
 CREATE TABLE settings
 (
@@ -15,8 +18,9 @@ CREATE TABLE settings
 DROP TABLE IF EXISTS chair_models;
 CREATE TABLE chair_models
 (
-  name  VARCHAR(50) NOT NULL COMMENT '椅子モデル名',
-  speed INTEGER     NOT NULL COMMENT '移動速度',
+  name       VARCHAR(50) NOT NULL COMMENT '椅子モデル名',
+  speed      INTEGER     NOT NULL COMMENT '移動速度',
+  retired_at DATETIME(6) NULL     COMMENT '廃止日時',
   PRIMARY KEY (name)
 )
   COMMENT = '椅子モデルテーブル';
@@ -48,6 +52,19 @@ CREATE TABLE chair_locations
 )
   COMMENT = '椅子の現在位置情報テーブル';
 
+DROP TABLE IF EXISTS chair_locations_archive;
+CREATE TABLE chair_locations_archive
+(
+  id         VARCHAR(26) NOT NULL,
+  chair_id   VARCHAR(26) NOT NULL COMMENT '椅子ID',
+  latitude   INTEGER     NOT NULL COMMENT '経度',
+  longitude  INTEGER     NOT NULL COMMENT '緯度',
+  created_at DATETIME(6) NOT NULL COMMENT '登録日時',
+  PRIMARY KEY (id),
+  INDEX chair_id_created_at_idx (chair_id, created_at)
+)
+  COMMENT = '保持期間を過ぎた椅子の位置情報テーブル';
+
 DROP TABLE IF EXISTS users;
 CREATE TABLE users
 (
@@ -94,6 +111,77 @@ CREATE TABLE rides
 )
   COMMENT = 'ライド情報テーブル';
 
+DROP TABLE IF EXISTS ride_evaluation_comments;
+CREATE TABLE ride_evaluation_comments
+(
+  ride_id    VARCHAR(26)  NOT NULL COMMENT 'ライドID',
+  comment    VARCHAR(200) NOT NULL COMMENT '評価コメント',
+  created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (ride_id)
+)
+  COMMENT = 'ライドの評価コメントテーブル';
+
+DROP TABLE IF EXISTS ride_evaluation_tags;
+CREATE TABLE ride_evaluation_tags
+(
+  ride_id    VARCHAR(26) NOT NULL COMMENT 'ライドID',
+  tag        VARCHAR(30) NOT NULL COMMENT 'タグ',
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (ride_id, tag)
+)
+  COMMENT = 'ライドの評価タグテーブル';
+
+DROP TABLE IF EXISTS user_places;
+CREATE TABLE user_places
+(
+  id         VARCHAR(26) NOT NULL COMMENT '地点ID',
+  user_id    VARCHAR(26) NOT NULL COMMENT 'ユーザーID',
+  name       VARCHAR(30) NOT NULL COMMENT '地点名',
+  latitude   INTEGER     NOT NULL COMMENT '経度',
+  longitude  INTEGER     NOT NULL COMMENT '緯度',
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (id),
+  UNIQUE (user_id, name)
+)
+  COMMENT = 'ユーザーのお気に入り地点テーブル';
+
+DROP TABLE IF EXISTS ride_waypoints;
+CREATE TABLE ride_waypoints
+(
+  id         VARCHAR(26) NOT NULL COMMENT '経由地ID',
+  ride_id    VARCHAR(26) NOT NULL COMMENT 'ライドID(予約の場合は予約ID)',
+  position   INTEGER     NOT NULL COMMENT '経由する順番(1始まり)',
+  latitude   INTEGER     NOT NULL COMMENT '経度',
+  longitude  INTEGER     NOT NULL COMMENT '緯度',
+  arrived_at DATETIME(6) NULL     COMMENT '到着日時',
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '登録日時',
+  PRIMARY KEY (id),
+  UNIQUE (ride_id, position)
+)
+  COMMENT = 'ライドの経由地テーブル';
+
+DROP TABLE IF EXISTS ride_reservations;
+CREATE TABLE ride_reservations
+(
+  id                    VARCHAR(26)                                 NOT NULL COMMENT '予約ID',
+  user_id               VARCHAR(26)                                 NOT NULL COMMENT 'ユーザーID',
+  pickup_latitude       INTEGER                                     NOT NULL COMMENT '配車位置(経度)',
+  pickup_longitude      INTEGER                                     NOT NULL COMMENT '配車位置(緯度)',
+  destination_latitude  INTEGER                                     NOT NULL COMMENT '目的地(経度)',
+  destination_longitude INTEGER                                     NOT NULL COMMENT '目的地(緯度)',
+  scheduled_at          DATETIME(6)                                 NOT NULL COMMENT '予約日時',
+  route_distance        INTEGER                                     NULL     COMMENT '経由地を含めた走行距離',
+  status                ENUM ('SCHEDULED', 'RELEASED', 'CANCELED') NOT NULL DEFAULT 'SCHEDULED' COMMENT '状態',
+  released_at           DATETIME(6)                                 NULL     COMMENT 'マッチングに回した日時',
+  ride_id               VARCHAR(26)                                 NULL     COMMENT 'マッチングに回したライドID',
+  created_at            DATETIME(6)                                 NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '予約受付日時',
+  updated_at            DATETIME(6)                                 NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6) COMMENT '状態更新日時',
+  PRIMARY KEY (id),
+  INDEX user_id_status_idx (user_id, status),
+  INDEX status_scheduled_at_idx (status, scheduled_at)
+)
+  COMMENT = 'ライド予約テーブル';
+
 DROP TABLE IF EXISTS ride_statuses;
 CREATE TABLE ride_statuses
 (
@@ -123,6 +211,70 @@ CREATE TABLE owners
 )
   COMMENT = '椅子のオーナー情報テーブル';
 
+DROP TABLE IF EXISTS sessions;
+CREATE TABLE sessions
+(
+  id           VARCHAR(26)                     NOT NULL COMMENT 'セッションID',
+  token        VARCHAR(255)                    NOT NULL COMMENT 'クッキーに入れるトークン',
+  role         ENUM ('app', 'owner', 'chair') NOT NULL COMMENT '利用者の種類',
+  principal_id VARCHAR(26)                     NOT NULL COMMENT 'ユーザー・オーナー・椅子のID',
+  created_at   DATETIME(6)                     NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '作成日時',
+  last_seen_at DATETIME(6)                     NOT NULL COMMENT '最終利用日時',
+  expires_at   DATETIME(6)                     NOT NULL COMMENT '有効期限',
+  revoked_at   DATETIME(6)                     NULL     COMMENT '失効日時',
+  PRIMARY KEY (id),
+  UNIQUE (token),
+  INDEX role_principal_id_idx (role, principal_id)
+)
+  COMMENT = 'ログインセッションテーブル';
+
+DROP TABLE IF EXISTS principal_credentials;
+CREATE TABLE principal_credentials
+(
+  role         ENUM ('app', 'owner', 'chair') NOT NULL COMMENT '利用者の種類',
+  principal_id VARCHAR(26)                     NOT NULL COMMENT 'ユーザー・オーナー・椅子のID',
+  token        VARCHAR(255)                    NOT NULL COMMENT '再ログインに使うトークンのハッシュ',
+  created_at   DATETIME(6)                     NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '作成日時',
+  PRIMARY KEY (role, principal_id),
+  UNIQUE (token)
+)
+  COMMENT = '再ログイン用の資格情報テーブル';
+
+DROP TABLE IF EXISTS owner_api_keys;
+CREATE TABLE owner_api_keys
+(
+  id         VARCHAR(26)  NOT NULL COMMENT 'APIキーID',
+  owner_id   VARCHAR(26)  NOT NULL COMMENT 'オーナーID',
+  name       VARCHAR(30)  NOT NULL COMMENT 'キー名',
+  key_hash   CHAR(64)     NOT NULL COMMENT 'APIキーのSHA-256',
+  key_prefix VARCHAR(16)  NOT NULL COMMENT '表示用のAPIキーの先頭',
+  scopes     VARCHAR(255) NOT NULL COMMENT 'スコープ(カンマ区切り)',
+  created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '発行日時',
+  revoked_at DATETIME(6)  NULL     COMMENT '失効日時',
+  PRIMARY KEY (id),
+  UNIQUE (key_hash),
+  INDEX owner_id_idx (owner_id)
+)
+  COMMENT = 'オーナーのAPIキーテーブル';
+
+DROP TABLE IF EXISTS chair_register_tokens;
+CREATE TABLE chair_register_tokens
+(
+  id         VARCHAR(26)  NOT NULL COMMENT '椅子登録トークンID',
+  owner_id   VARCHAR(26)  NOT NULL COMMENT 'オーナーID',
+  name       VARCHAR(30)  NOT NULL COMMENT 'トークン名',
+  token      VARCHAR(255) NOT NULL COMMENT '椅子登録トークン',
+  max_uses   INTEGER      NULL     COMMENT '最大利用回数(NULLなら無制限)',
+  used_count INTEGER      NOT NULL DEFAULT 0 COMMENT '利用回数',
+  expires_at DATETIME(6)  NULL     COMMENT '有効期限(NULLなら無期限)',
+  revoked_at DATETIME(6)  NULL     COMMENT '失効日時',
+  created_at DATETIME(6)  NOT NULL DEFAULT CURRENT_TIMESTAMP(6) COMMENT '発行日時',
+  PRIMARY KEY (id),
+  UNIQUE (token),
+  INDEX owner_id_idx (owner_id)
+)
+  COMMENT = '椅子登録トークンテーブル';
+
 DROP TABLE IF EXISTS coupons;
 CREATE TABLE coupons
 (